@@ -0,0 +1,258 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package api holds the schemas of the JSON API served under /api/v1/, which mirrors the HTML routes.
+// Fields are only ever added to these schemas, never renamed or removed, within a version of the API.
+package api
+
+import (
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/mxclient"
+)
+
+type Room struct {
+	RoomID          string `json:"room_id"`
+	Name            string `json:"name"`
+	CanonicalAlias  string `json:"canonical_alias,omitempty"`
+	Topic           string `json:"topic,omitempty"`
+	RoomVersion     string `json:"room_version"`
+	AvatarURL       string `json:"avatar_url,omitempty"`
+	NumMemberEvents int    `json:"num_member_events"`
+	NumMembers      int    `json:"num_members"`
+	NumServers      int    `json:"num_servers"`
+
+	PredecessorRoomID  string `json:"predecessor_room_id,omitempty"`
+	PredecessorEventID string `json:"predecessor_event_id,omitempty"`
+	SuccessorRoomID    string `json:"successor_room_id,omitempty"`
+}
+
+func NewRoom(info mxclient.RoomInfo) Room {
+	return Room{
+		RoomID:          info.RoomID,
+		Name:            info.Name,
+		CanonicalAlias:  info.CanonicalAlias,
+		Topic:           info.Topic,
+		RoomVersion:     info.RoomVersion,
+		AvatarURL:       info.AvatarURL.String(),
+		NumMemberEvents: info.NumMemberEvents,
+		NumMembers:      info.NumMembers,
+		NumServers:      info.NumServers,
+
+		PredecessorRoomID:  info.PredecessorRoomID,
+		PredecessorEventID: info.PredecessorEventID,
+		SuccessorRoomID:    info.SuccessorRoomID,
+	}
+}
+
+type Member struct {
+	UserID      string `json:"user_id"`
+	Membership  string `json:"membership"`
+	DisplayName string `json:"display_name,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+	PowerLevel  int    `json:"power_level"`
+}
+
+func NewMember(info mxclient.MemberInfo) Member {
+	return Member{
+		UserID:      info.MXID,
+		Membership:  info.Membership,
+		DisplayName: info.DisplayName,
+		AvatarURL:   info.AvatarURL.String(),
+		PowerLevel:  int(info.PowerLevel),
+	}
+}
+
+// NewMembers returns the members which events were sent by or are about, as pages of events only need those.
+func NewMembers(events []gomatrix.Event, memberMap map[string]mxclient.MemberInfo) map[string]Member {
+	members := make(map[string]Member)
+	add := func(mxid string) {
+		if member, ok := memberMap[mxid]; ok {
+			members[mxid] = NewMember(member)
+		}
+	}
+	for _, ev := range events {
+		add(ev.Sender)
+		if ev.Type == "m.room.member" && ev.StateKey != nil {
+			add(*ev.StateKey)
+		}
+	}
+	return members
+}
+
+// Pagination describes pages which are numbered from 1.
+type Pagination struct {
+	Page     int `json:"page"`
+	PageSize int `json:"page_size"`
+}
+
+// PublicRoomsResp mirrors the room directory at /.
+type PublicRoomsResp struct {
+	Pagination
+	Query string                `json:"query,omitempty"`
+	Rooms []gomatrix.PublicRoom `json:"rooms"`
+}
+
+type ReactionGroup struct {
+	Key     string   `json:"key"`
+	Count   int      `json:"count"`
+	Senders []string `json:"senders"`
+}
+
+func NewReactions(reactions map[string][]mxclient.ReactionGroup) map[string][]ReactionGroup {
+	groups := make(map[string][]ReactionGroup, len(reactions))
+	for eventID, eventGroups := range reactions {
+		for _, group := range eventGroups {
+			groups[eventID] = append(groups[eventID], ReactionGroup{group.Key, group.Count, group.Senders})
+		}
+	}
+	return groups
+}
+
+type ThreadSummary struct {
+	Count int `json:"count"`
+}
+
+// EventsResp mirrors the room timeline at /room/:roomID/.
+// Events are newest first. The next page of older events is at the same anchor with offset increased by page_size,
+// and the page of newer events at the same anchor with offset decreased by the number of events.
+type EventsResp struct {
+	Room Room `json:"room"`
+	// Anchor is the ID of the event the page is relative to, the latest event if none was asked for.
+	Anchor      string `json:"anchor"`
+	Offset      int    `json:"offset"`
+	PageSize    int    `json:"page_size"`
+	AtTopEnd    bool   `json:"at_top_end"`
+	AtBottomEnd bool   `json:"at_bottom_end"`
+
+	Events []gomatrix.Event `json:"events"`
+	// Members are those who sent or are the subject of Events, by user ID.
+	Members map[string]Member `json:"members"`
+	// Reactions, Threads and InReplyTo are keyed by the ID of the event they belong to, see the HTML timeline.
+	Reactions map[string][]ReactionGroup `json:"reactions"`
+	Threads   map[string]ThreadSummary   `json:"threads"`
+	InReplyTo map[string]gomatrix.Event  `json:"in_reply_to"`
+
+	// Predecessor continues the page into the room this one was upgraded from, if the server follows room upgrades.
+	Predecessor *EventsResp `json:"predecessor,omitempty"`
+}
+
+// StreamUpdate is the data of each "events" message of the Server-Sent Events stream at /room/:roomID/stream, which
+// is only served as text/event-stream rather than under /api/v1/.
+// Events are newest first, HTML renders them as rows of the HTML timeline, oldest first, to be appended to it.
+type StreamUpdate struct {
+	Events  []gomatrix.Event  `json:"events"`
+	Members map[string]Member `json:"members"`
+	HTML    string            `json:"html"`
+}
+
+// EditsResp mirrors the edit history at /room/:roomID/edits/:eventID, edits being oldest first.
+type EditsResp struct {
+	Room     Room              `json:"room"`
+	Original gomatrix.Event    `json:"original"`
+	Edits    []gomatrix.Event  `json:"edits"`
+	Members  map[string]Member `json:"members"`
+}
+
+// ThreadResp mirrors the thread at /room/:roomID/thread/:eventID, replies being newest first.
+// The page of older replies is fetched by passing next_batch as from.
+type ThreadResp struct {
+	Room      Room                       `json:"room"`
+	Root      gomatrix.Event             `json:"root"`
+	Replies   []gomatrix.Event           `json:"replies"`
+	From      string                     `json:"from,omitempty"`
+	NextBatch string                     `json:"next_batch,omitempty"`
+	Members   map[string]Member          `json:"members"`
+	Reactions map[string][]ReactionGroup `json:"reactions"`
+	InReplyTo map[string]gomatrix.Event  `json:"in_reply_to"`
+}
+
+// SearchResp mirrors the search at /room/:roomID/search, events being newest first.
+// The page of older results is fetched by passing next_batch as from.
+type SearchResp struct {
+	Room       Room              `json:"room"`
+	Query      string            `json:"query"`
+	From       string            `json:"from,omitempty"`
+	Events     []gomatrix.Event  `json:"events"`
+	Highlights []string          `json:"highlights"`
+	Count      int               `json:"count"`
+	NextBatch  string            `json:"next_batch,omitempty"`
+	Members    map[string]Member `json:"members"`
+}
+
+type ServerUserCount struct {
+	ServerName string `json:"server_name"`
+	NumUsers   int    `json:"num_users"`
+}
+
+// ServersResp mirrors /room/:roomID/servers, servers with the most users first.
+type ServersResp struct {
+	Pagination
+	Room    Room              `json:"room"`
+	Servers []ServerUserCount `json:"servers"`
+}
+
+type ServerAliases struct {
+	ServerName string   `json:"server_name"`
+	Aliases    []string `json:"aliases"`
+}
+
+// AliasesResp mirrors /room/:roomID/aliases.
+type AliasesResp struct {
+	Pagination
+	Room    Room            `json:"room"`
+	Aliases []ServerAliases `json:"aliases"`
+}
+
+// MembersResp mirrors /room/:roomID/members, members with the highest power level first.
+type MembersResp struct {
+	Pagination
+	Room    Room     `json:"room"`
+	Members []Member `json:"members"`
+}
+
+// MemberResp mirrors /room/:roomID/members/:mxid.
+type MemberResp struct {
+	Room   Room   `json:"room"`
+	Member Member `json:"member"`
+}
+
+// DateResp mirrors /room/:roomID/date/:day, which redirects to the first event sent on or after the day.
+// EventID is empty if there is none. OutsideHistory is set if the day is further back than the history we are willing
+// to load, EventID then being the oldest event loaded.
+type DateResp struct {
+	Day            string `json:"day"`
+	EventID        string `json:"event_id,omitempty"`
+	OutsideHistory bool   `json:"outside_history,omitempty"`
+}
+
+// CalendarResp mirrors /room/:roomID/calendar, counting the messages loaded by day. AtHistoryStart is set once they
+// reach back to the creation of the room.
+type CalendarResp struct {
+	Room Room `json:"room"`
+	// Days counts the messages held by the day they were sent on, keyed as YYYY-MM-DD.
+	Days           map[string]int `json:"days"`
+	AtHistoryStart bool           `json:"at_history_start"`
+}
+
+// PowerLevelsResp mirrors /room/:roomID/power_levels, power_levels having the schema of the m.room.power_levels event.
+type PowerLevelsResp struct {
+	Room        Room                 `json:"room"`
+	PowerLevels mxclient.PowerLevels `json:"power_levels"`
+}
+
+// AliasResp mirrors /alias/:roomAlias, which redirects to the room the alias points to.
+type AliasResp struct {
+	RoomID  string   `json:"room_id"`
+	Servers []string `json:"servers"`
+}
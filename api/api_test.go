@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/mxclient"
+	"reflect"
+	"testing"
+)
+
+func TestNewError(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		expStatus int
+		expBody   Error
+	}{
+		{
+			"should time out",
+			context.DeadlineExceeded,
+			504,
+			Error{ErrCodeTimeout, "The Homeserver took too long to respond, please try again later."},
+		}, {
+			"should pass on the errcode of the homeserver",
+			gomatrix.HTTPError{Code: 403, WrappedError: gomatrix.RespError{ErrCode: "M_FORBIDDEN", Err: "Not allowed"}},
+			403,
+			Error{"M_FORBIDDEN", "Not allowed"},
+		}, {
+			"should not blame the caller for the homeserver failing",
+			gomatrix.HTTPError{Code: 500, WrappedError: gomatrix.RespError{ErrCode: "M_UNKNOWN", Err: "Oops"}},
+			502,
+			Error{"M_UNKNOWN", "Oops"},
+		}, {
+			"should fail internally otherwise",
+			errors.New("broken"),
+			500,
+			Error{ErrCodeUnknown, "broken"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := NewError(tt.err)
+			if status != tt.expStatus || body != tt.expBody {
+				t.Errorf("NewError() = %d, %v, want %d, %v", status, body, tt.expStatus, tt.expBody)
+			}
+		})
+	}
+}
+
+func TestNewMembers(t *testing.T) {
+	target := "@bob:example.org"
+	events := []gomatrix.Event{
+		{Type: "m.room.message", Sender: "@alice:example.org"},
+		{Type: "m.room.member", Sender: "@alice:example.org", StateKey: &target},
+	}
+	memberMap := map[string]mxclient.MemberInfo{
+		"@alice:example.org":   {MXID: "@alice:example.org", Membership: "join", PowerLevel: 100},
+		"@bob:example.org":     {MXID: "@bob:example.org", Membership: "ban"},
+		"@charlie:example.org": {MXID: "@charlie:example.org", Membership: "join"},
+	}
+
+	expected := map[string]Member{
+		"@alice:example.org": {UserID: "@alice:example.org", Membership: "join", PowerLevel: 100},
+		"@bob:example.org":   {UserID: "@bob:example.org", Membership: "ban"},
+	}
+	if members := NewMembers(events, memberMap); !reflect.DeepEqual(members, expected) {
+		t.Error("Members mismatch expectation", members)
+	}
+}
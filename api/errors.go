@@ -0,0 +1,69 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"context"
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/mxclient"
+	"github.com/matrix-org/matrix-static/workers"
+	"net/http"
+)
+
+const (
+	ErrCodeUnknown      = "M_UNKNOWN"
+	ErrCodeNotFound     = "M_NOT_FOUND"
+	ErrCodeInvalidParam = "M_INVALID_PARAM"
+	ErrCodeUnrecognized = "M_UNRECOGNIZED"
+	// ErrCodeTimeout is not a Matrix errcode as it is us timing out waiting on the homeserver.
+	ErrCodeTimeout = "MS_TIMEOUT"
+	// ErrCodeRoomNotLoaded is not a Matrix errcode as it is the room being evicted whilst we handled the request,
+	// retrying it will load the room again.
+	ErrCodeRoomNotLoaded = "MS_ROOM_NOT_LOADED"
+)
+
+// RetryAfter is the number of seconds for clients to wait before retrying a request we responded to with a 503.
+const RetryAfter = 1
+
+// Error is the body of every unsuccessful response, modelled on the errors of the Matrix Client-Server API.
+type Error struct {
+	ErrCode string `json:"errcode"`
+	Err     string `json:"error"`
+}
+
+// NewError returns the status code and body to respond to err with, passing on the errcode of the homeserver if it
+// was the homeserver which refused us.
+func NewError(err error) (int, Error) {
+	if err == context.DeadlineExceeded {
+		return http.StatusGatewayTimeout, Error{ErrCodeTimeout, "The Homeserver took too long to respond, please try again later."}
+	}
+	if err == workers.ErrRoomNotLoaded {
+		return http.StatusServiceUnavailable, Error{ErrCodeRoomNotLoaded, "The room was unloaded whilst handling the request, please try again."}
+	}
+
+	if respErr, ok := mxclient.UnwrapRespError(err); ok {
+		status := http.StatusBadGateway
+		// client errors such as the room not being peekable are as much the caller's as ours.
+		if httpErr := err.(gomatrix.HTTPError); httpErr.Code/100 == 4 {
+			status = httpErr.Code
+		}
+		return status, Error{respErr.ErrCode, respErr.Err}
+	}
+
+	if httpErr, ok := err.(gomatrix.HTTPError); ok {
+		return http.StatusBadGateway, Error{ErrCodeUnknown, httpErr.Message}
+	}
+	return http.StatusInternalServerError, Error{ErrCodeUnknown, err.Error()}
+}
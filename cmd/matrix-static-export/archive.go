@@ -0,0 +1,470 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/matrix-org/matrix-static/mxclient"
+	"github.com/matrix-org/matrix-static/utils"
+	"golang.org/x/net/html"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// liveRoot is what the URLs in the rendered pages are relative to, as the templates set <base href="/">.
+var liveRoot, _ = url.Parse("/")
+
+// archive is the directory an export is written to. Pages are rendered with the templates of the server and then have
+// their links rewritten from the routes of the server to the files of the archive, relative to the page linking, so
+// that the archive can be served from anywhere. Links to pages the archive does not have point to matrix.to instead
+// where there is an equivalent, and are dropped otherwise.
+type archive struct {
+	dir        string
+	httpClient *http.Client
+	mediaURL   *url.URL
+
+	rooms map[string]*exportedRoom
+	// media maps the thumbnails downloaded to their file, so that each is only fetched once per export.
+	media map[string]string
+
+	// written holds every file written or left unchanged by this export, numChanged how many of them changed.
+	written    map[string]bool
+	numChanged int
+}
+
+func newArchive(dir string, client *mxclient.Client) (*archive, error) {
+	mediaURL, err := url.Parse(client.MediaBaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &archive{
+		dir:        dir,
+		httpClient: client.Client.Client,
+		mediaURL:   mediaURL,
+		rooms:      make(map[string]*exportedRoom),
+		media:      make(map[string]string),
+		written:    make(map[string]bool),
+	}, nil
+}
+
+// writeFile writes data to name within the archive, leaving the file untouched if it already holds data so that
+// re-running an export only modifies the files which changed.
+func (a *archive) writeFile(name string, data []byte) error {
+	a.written[name] = true
+
+	filename := filepath.Join(a.dir, filepath.FromSlash(name))
+	if existing, err := ioutil.ReadFile(filename); err == nil && bytes.Equal(existing, data) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+	a.numChanged++
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+// readFile returns the contents of name within the archive.
+func (a *archive) readFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(a.dir, filepath.FromSlash(name)))
+}
+
+// keepFile leaves name within the archive as it is, returning false if there is no such file to keep.
+func (a *archive) keepFile(name string) bool {
+	if _, err := os.Stat(filepath.Join(a.dir, filepath.FromSlash(name))); err != nil {
+		return false
+	}
+	a.written[name] = true
+	return true
+}
+
+// writePage rewrites the links of page, rendered by the templates, and writes it to name within the archive.
+func (a *archive) writePage(name string, page []byte) error {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		return err
+	}
+	a.rewriteNode(doc, name)
+
+	buf := new(bytes.Buffer)
+	if err = html.Render(buf, doc); err != nil {
+		return err
+	}
+	return a.writeFile(name, buf.Bytes())
+}
+
+// copyDir copies the files within srcDir to the same place in the archive.
+func (a *archive) copyDir(srcDir string) error {
+	return filepath.Walk(srcDir, func(filename string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(srcDir, filename)
+		if err != nil {
+			return err
+		}
+		return a.writeFile(filepath.ToSlash(rel), data)
+	})
+}
+
+// prune removes the files within dir of the archive which this export did not write, such as pages of members who
+// have since left.
+func (a *archive) prune(dir string) error {
+	root := filepath.Join(a.dir, filepath.FromSlash(dir))
+	return filepath.Walk(root, func(filename string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(a.dir, filename)
+		if err != nil {
+			return err
+		}
+		if a.written[filepath.ToSlash(rel)] {
+			return nil
+		}
+		log.WithField("file", rel).Info("Removing stale file")
+		return os.Remove(filename)
+	})
+}
+
+// rewriteNode rewrites the links within n for the page at name, dropping what cannot work without the server.
+func (a *archive) rewriteNode(n *html.Node, name string) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.ElementNode {
+			switch c.Data {
+			// forms submit to the server, and <base> would break the links made relative to the page.
+			case "base", "form":
+				n.RemoveChild(c)
+				c = next
+				continue
+			}
+
+			if !a.rewriteAttrs(c, name) && c.Data == "link" {
+				n.RemoveChild(c)
+				c = next
+				continue
+			}
+		}
+		a.rewriteNode(c, name)
+		c = next
+	}
+}
+
+// rewriteAttrs rewrites the href and src attributes of n, removing those with nowhere to point to.
+// It returns false if any were removed.
+func (a *archive) rewriteAttrs(n *html.Node, name string) bool {
+	kept := n.Attr[:0]
+	ok := true
+	for _, attr := range n.Attr {
+		if attr.Key == "href" || attr.Key == "src" {
+			target, found := a.rewriteURL(attr.Val, name)
+			if !found {
+				ok = false
+				continue
+			}
+			attr.Val = target
+		}
+		kept = append(kept, attr)
+	}
+	n.Attr = kept
+	return ok
+}
+
+// rewriteURL returns what ref, a link on the page at name, should point to within the archive.
+func (a *archive) rewriteURL(ref, name string) (string, bool) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", false
+	}
+
+	// leave fragments within the same page and links elsewhere alone, except for the thumbnails we archive.
+	if ref == "" || ref[0] == '#' {
+		return ref, true
+	}
+	if u.IsAbs() {
+		if local, ok := a.thumbnail(u); ok {
+			return relativeTo(name, local), true
+		}
+		return ref, true
+	}
+
+	target, external, ok := a.localPath(liveRoot.ResolveReference(u))
+	switch {
+	case !ok:
+		return "", false
+	case external:
+		return target, true
+	}
+	return relativeTo(name, target), true
+}
+
+// localPath maps u, a URL of the server, to the file of the archive serving the same page or an external equivalent.
+func (a *archive) localPath(u *url.URL) (target string, external, ok bool) {
+	segments := strings.Split(strings.TrimPrefix(u.Path, "/"), "/")
+	query := u.Query()
+
+	switch segments[0] {
+	case "":
+		// only the first page of the room list is archived.
+		return "index.html", false, len(u.RawQuery) == 0
+	case "css", "img", "robots.txt":
+		return strings.TrimPrefix(u.Path, "/"), false, true
+	case "avatar":
+		if len(segments) != 2 {
+			return "", false, false
+		}
+		avatar, err := a.avatar(segments[1])
+		return avatar, false, err == nil
+	case "room":
+		if len(segments) < 2 {
+			return "", false, false
+		}
+		return a.roomPath(segments[1], segments[2:], query)
+	}
+	return "", false, false
+}
+
+// roomPath is localPath for the routes within the room roomID.
+func (a *archive) roomPath(roomID string, segments []string, query url.Values) (target string, external, ok bool) {
+	room, exported := a.rooms[roomID]
+	if len(segments) == 0 {
+		segments = []string{""}
+	}
+
+	switch segments[0] {
+	case "":
+		anchor := query.Get("anchor")
+		if !exported {
+			return matrixToURL(roomID, anchor), true, true
+		}
+		if anchor == "" {
+			return roomFile(roomID, "index.html"), false, true
+		}
+		index, found := room.index[anchor]
+		if !found {
+			return matrixToURL(roomID, anchor), true, true
+		}
+		offset := utils.StrToIntDefault(query.Get("offset"), 0)
+		return room.pagePath(utils.Bound(0, index-offset, len(room.events)-1)), false, true
+
+	case "edits", "thread":
+		if len(segments) != 2 {
+			return "", false, false
+		}
+		return matrixToURL(roomID, segments[1]), true, true
+	case "members":
+		if len(segments) == 2 {
+			return matrixToURL(segments[1], ""), true, true
+		}
+		fallthrough
+	case "servers", "aliases":
+		if !exported {
+			return "", false, false
+		}
+		return roomFile(roomID, listFile(segments[0], utils.StrToIntDefault(query.Get("page"), 1))), false, true
+	case "power_levels":
+		return roomFile(roomID, "power_levels/index.html"), false, exported
+	case "calendar":
+		// older months than were exported cannot be loaded.
+		return roomFile(roomID, "calendar/index.html"), false, exported && query.Get("since") == ""
+	case "date":
+		if !exported || len(segments) != 2 {
+			return "", false, false
+		}
+		date, err := time.ParseInLocation(mxclient.DayFormat, segments[1], time.Local)
+		if err != nil {
+			return "", false, false
+		}
+		ts := mxclient.Timestamp(date)
+		index := sort.Search(len(room.events), func(i int) bool {
+			return room.events[i].Timestamp >= ts
+		})
+		if index == len(room.events) {
+			return roomFile(roomID, "index.html"), false, true
+		}
+		return room.pagePath(index), false, true
+	}
+	return "", false, false
+}
+
+// avatar writes the generated avatar for identifier to the archive, unless it is already there, returning its file.
+// Avatars only differ by their letter so are stored by it.
+func (a *archive) avatar(identifier string) (string, error) {
+	letter := utils.AvatarLetter(identifier)
+	name := fmt.Sprintf("avatar/%x.png", letter)
+	// avatars are drawn in a random colour, so keep the existing one rather than change it every export.
+	if a.written[name] || a.keepFile(name) {
+		return name, nil
+	}
+
+	avatar, err := utils.DrawAvatar(letter)
+	if err != nil {
+		log.WithField("identifier", identifier).WithError(err).Error("Failed to draw avatar")
+		return "", err
+	}
+	return name, a.writeFile(name, avatar)
+}
+
+// mediaIDRegex matches the characters the spec restricts media IDs to.
+var mediaIDRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// serverNameRegex matches hostnames with an optional port. IPv6 literals are left out as their brackets would be taken
+// for a pattern when looking for the thumbnail amongst those already downloaded.
+var serverNameRegex = regexp.MustCompile(`^[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*(:[0-9]+)?$`)
+
+// thumbnailMethods are the methods of thumbnailing the media repository supports.
+var thumbnailMethods = map[string]bool{"crop": true, "scale": true}
+
+// thumbnail downloads u to the archive if it is a thumbnail of the media repository, returning its file.
+// Thumbnails which fail to download are left pointing to the media repository, as are those which cannot be safely
+// named within the archive.
+func (a *archive) thumbnail(u *url.URL) (string, bool) {
+	const thumbnailPath = "/_matrix/media/r0/thumbnail/"
+	i := strings.Index(u.Path, thumbnailPath)
+	if u.Host != a.mediaURL.Host || i == -1 {
+		return "", false
+	}
+	if local, ok := a.media[u.String()]; ok {
+		return local, local != ""
+	}
+
+	base, ok := a.thumbnailBase(u.Path[i+len(thumbnailPath):], u.Query())
+	if !ok {
+		log.WithField("url", u.String()).Warn("Refusing to archive thumbnail")
+		a.media[u.String()] = ""
+		return "", false
+	}
+
+	// media never changes so is only ever downloaded once.
+	if existing, _ := filepath.Glob(filepath.Join(a.dir, filepath.FromSlash(base)) + ".*"); len(existing) == 1 {
+		local := base + path.Ext(existing[0])
+		a.written[local] = true
+		a.media[u.String()] = local
+		return local, true
+	}
+
+	local, err := a.downloadThumbnail(u, base)
+	if err != nil {
+		log.WithField("url", u.String()).WithError(err).Warn("Failed to download thumbnail")
+	}
+	a.media[u.String()] = local
+	return local, err == nil
+}
+
+// thumbnailBase returns the name of the file within the archive, bar its extension, to store the thumbnail of the
+// media at mediaPath, "<server name>/<media ID>", with the given query. The same media may be thumbnailed at
+// different sizes, so those are part of the name.
+func (a *archive) thumbnailBase(mediaPath string, query url.Values) (string, bool) {
+	serverName, mediaID, ok := mxclient.NewMXCURL("mxc://"+mediaPath, "").ServerNameAndMediaID()
+	if !ok || !serverNameRegex.MatchString(serverName) || !mediaIDRegex.MatchString(mediaID) {
+		return "", false
+	}
+	width, errWidth := strconv.Atoi(query.Get("width"))
+	height, errHeight := strconv.Atoi(query.Get("height"))
+	method := query.Get("method")
+	if errWidth != nil || errHeight != nil || width <= 0 || height <= 0 || !thumbnailMethods[method] {
+		return "", false
+	}
+
+	base := fmt.Sprintf("media/%s/%s/%dx%d-%s", serverName, mediaID, width, height, method)
+	// whatever slipped past the above must not be able to write outside of the archive.
+	filename := filepath.Join(a.dir, filepath.FromSlash(base))
+	if !strings.HasPrefix(filename, filepath.Clean(a.dir)+string(filepath.Separator)) {
+		return "", false
+	}
+	return base, true
+}
+
+func (a *archive) downloadThumbnail(u *url.URL, base string) (string, error) {
+	resp, err := a.httpClient.Get(u.String())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	// static hosts serve files by their extension, so one matching the media is needed for it to display.
+	ext := ".bin"
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil {
+		if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+			sort.Strings(exts)
+			ext = exts[0]
+		}
+	}
+
+	local := base + ext
+	return local, a.writeFile(local, data)
+}
+
+// relativeTo returns the path of the archive file target relative to the page at name.
+func relativeTo(name, target string) string {
+	segments := strings.Split(target, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Repeat("../", strings.Count(name, "/")) + strings.Join(segments, "/")
+}
+
+// roomFile returns the path of name within the directory of the room in the archive.
+func roomFile(roomID, name string) string {
+	return "room/" + roomID + "/" + name
+}
+
+// listFile returns the file of page of a paginated list such as the members of a room, where page 0 lists all.
+func listFile(list string, page int) string {
+	switch page {
+	case 0:
+		return list + "/all.html"
+	case 1:
+		return list + "/index.html"
+	}
+	return fmt.Sprintf("%s/page-%d.html", list, page)
+}
+
+// matrixToURL links to the room, or the event within it if there is one, or the user if roomID is one, on matrix.to.
+func matrixToURL(roomID, eventID string) string {
+	if eventID == "" {
+		return "https://matrix.to/#/" + roomID
+	}
+	return "https://matrix.to/#/" + roomID + "/" + eventID
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/mxclient"
+	"golang.org/x/net/html"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testArchive() *archive {
+	day := mxclient.Timestamp(time.Date(2020, 1, 1, 0, 0, 0, 0, time.Local))
+	room := &exportedRoom{
+		info:  mxclient.RoomInfo{RoomID: "!r:b"},
+		index: make(map[string]int),
+	}
+	for i := 0; i < 65; i++ {
+		ev := gomatrix.Event{ID: fmt.Sprintf("$%d", i), Timestamp: day + int64(i)*int64(time.Hour/time.Millisecond)}
+		room.events = append(room.events, ev)
+		room.index[ev.ID] = i
+	}
+
+	mediaURL, _ := url.Parse("https://matrix.example.org")
+	return &archive{
+		mediaURL: mediaURL,
+		rooms:    map[string]*exportedRoom{"!r:b": room},
+		written:  make(map[string]bool),
+	}
+}
+
+func TestArchive_rewriteURL(t *testing.T) {
+	a := testArchive()
+	const from = "room/!r:b/page-2.html"
+
+	tests := []struct {
+		ref   string
+		exp   string
+		expOk bool
+	}{
+		{"./css/main.css", "../../css/main.css", true},
+		{"/img/favicon.ico", "../../img/favicon.ico", true},
+		{"./", "../../index.html", true},
+		{"?anchor=$59&offset=-30", "", false},
+		{"./room/!r:b/", "../../room/%21r:b/index.html", true},
+		{"./room/!r:b/?anchor=$59&offset=30", "../../room/%21r:b/page-1.html", true},
+		{"./room/!r:b/?anchor=$59&offset=-30", "../../room/%21r:b/page-3.html", true},
+		{"./room/!r:b/?anchor=$5&highlight=$5", "../../room/%21r:b/page-1.html", true},
+		{"./room/!r:b/?anchor=$unknown", "https://matrix.to/#/!r:b/$unknown", true},
+		{"./room/!other:b/", "https://matrix.to/#/!other:b", true},
+		{"./room/!r:b/date/2020-01-02", "../../room/%21r:b/page-1.html", true},
+		{"./room/!r:b/date/2020-01-03", "../../room/%21r:b/page-2.html", true},
+		{"./room/!r:b/date/2030-01-01", "../../room/%21r:b/index.html", true},
+		{"./room/!r:b/members?page=2", "../../room/%21r:b/members/page-2.html", true},
+		{"./room/!r:b/members?page=0", "../../room/%21r:b/members/all.html", true},
+		{"./room/!r:b/members/@u:b", "https://matrix.to/#/@u:b", true},
+		{"./room/!r:b/edits/$1", "https://matrix.to/#/!r:b/$1", true},
+		{"./room/!r:b/calendar", "../../room/%21r:b/calendar/index.html", true},
+		{"./room/!r:b/calendar?since=2019-12", "", false},
+		{"./room/!r:b/search?q=hi", "", false},
+		{"./room/!r:b/feed.atom", "", false},
+		{"https://example.org/", "https://example.org/", true},
+		{"#top", "#top", true},
+	}
+	for _, tt := range tests {
+		if got, ok := a.rewriteURL(tt.ref, from); got != tt.exp || ok != tt.expOk {
+			t.Errorf("rewriteURL(%q) = %q, %t, want %q, %t", tt.ref, got, ok, tt.exp, tt.expOk)
+		}
+	}
+}
+
+func TestArchive_rewriteNode(t *testing.T) {
+	a := testArchive()
+	doc, err := html.Parse(strings.NewReader(`<html><head><link rel="alternate" href="./room/!r:b/feed.atom"><base href="/"></head>` +
+		`<body><form action="./room/!r:b/search"><input name="q"></form><a href="./room/!r:b/search">Search</a></body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.rewriteNode(doc, "index.html")
+
+	buf := new(bytes.Buffer)
+	html.Render(buf, doc)
+	if exp := `<html><head></head><body><a>Search</a></body></html>`; buf.String() != exp {
+		t.Errorf("rewriteNode() = %s, want %s", buf.String(), exp)
+	}
+}
+
+func TestArchive_thumbnailBase(t *testing.T) {
+	a := testArchive()
+	a.dir = "archive"
+
+	tests := []struct {
+		mediaPath string
+		query     string
+		exp       string
+		expOk     bool
+	}{
+		{"example.org/abc123", "width=32&height=32&method=crop", "media/example.org/abc123/32x32-crop", true},
+		{"example.org:8448/abc_-1", "width=640&height=480&method=scale", "media/example.org:8448/abc_-1/640x480-scale", true},
+		{"example.org/../../../etc/passwd", "width=32&height=32&method=crop", "", false},
+		{"../../etc/passwd", "width=32&height=32&method=crop", "", false},
+		{"../x", "width=32&height=32&method=crop", "", false},
+		{"example.org/..", "width=32&height=32&method=crop", "", false},
+		{"example.org/abc", "width=32/../../x&height=32&method=crop", "", false},
+		{"example.org/abc", "width=32&height=32&method=../../x", "", false},
+		{"example.org/abc", "width=-1&height=32&method=crop", "", false},
+		{"example.org/abc", "height=32&method=crop", "", false},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		if got, ok := a.thumbnailBase(tt.mediaPath, query); got != tt.exp || ok != tt.expOk {
+			t.Errorf("thumbnailBase(%q, %q) = %q, %t, want %q, %t", tt.mediaPath, tt.query, got, ok, tt.exp, tt.expOk)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/mxclient"
+	"github.com/matrix-org/matrix-static/sanitizer"
+	"github.com/matrix-org/matrix-static/templates"
+	"github.com/matrix-org/matrix-static/workers"
+	"sort"
+)
+
+// The page sizes match those of the server so that archived pages look the same.
+const (
+	RoomTimelineSize    = 30
+	RoomMembersPageSize = 20
+	RoomServersPageSize = 30
+	RoomAliasesPageSize = 10
+)
+
+// crawlPageSize is how many events are asked of the workers at once whilst crawling a room.
+const crawlPageSize = 500
+
+// exportedRoom is what was crawled of a room, which its pages are rendered from.
+type exportedRoom struct {
+	info      mxclient.RoomInfo
+	memberMap map[string]mxclient.MemberInfo
+	// events are oldest first, with edits applied.
+	events    []gomatrix.Event
+	index     map[string]int
+	reactions map[string][]mxclient.ReactionGroup
+	threads   map[string]mxclient.ThreadSummary
+	inReplyTo map[string]gomatrix.Event
+	// atHistoryStart is set if events start at the beginning of the room.
+	atHistoryStart bool
+}
+
+// numPages returns how many timeline pages the room has. Pages are counted from the oldest event so that new
+// events only ever change the latest pages, and a room without events still has an empty page.
+func (r *exportedRoom) numPages() int {
+	if len(r.events) == 0 {
+		return 1
+	}
+	return (len(r.events)-1)/RoomTimelineSize + 1
+}
+
+// pagePath returns the file of the timeline page holding the event at index.
+func (r *exportedRoom) pagePath(index int) string {
+	return timelinePagePath(r.info.RoomID, index/RoomTimelineSize+1)
+}
+
+func timelinePagePath(roomID string, page int) string {
+	return roomFile(roomID, fmt.Sprintf("page-%d.html", page))
+}
+
+// crawlRoom pages back through the history of the room from its latest event until its beginning, or until events
+// sent before since, if it is not 0.
+func crawlRoom(ctx context.Context, pool *workers.Workers, roomID string, since int64) (*exportedRoom, error) {
+	if err := pool.LoadRoom(ctx, roomID); err != nil {
+		return nil, err
+	}
+
+	room := &exportedRoom{
+		index:     make(map[string]int),
+		reactions: make(map[string][]mxclient.ReactionGroup),
+		threads:   make(map[string]mxclient.ThreadSummary),
+		inReplyTo: make(map[string]gomatrix.Event),
+	}
+
+	var newestFirst []gomatrix.Event
+	job := workers.RoomEventsJob{RoomID: roomID, PageSize: crawlPageSize}
+	for {
+		resp, err := pool.GetRoomEvents(ctx, job)
+		if err == nil {
+			err = resp.Err
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		room.info, room.memberMap = resp.RoomInfo, resp.MemberMap
+		for eventID, reactions := range resp.Reactions {
+			room.reactions[eventID] = reactions
+		}
+		for eventID, thread := range resp.Threads {
+			room.threads[eventID] = thread
+		}
+		for eventID, parent := range resp.InReplyTo {
+			room.inReplyTo[eventID] = parent
+		}
+
+		reachedSince := false
+		for _, ev := range resp.Events {
+			if since != 0 && ev.Timestamp < since {
+				reachedSince = true
+				break
+			}
+			newestFirst = append(newestFirst, ev)
+		}
+		if reachedSince || resp.AtTopEnd || len(resp.Events) == 0 {
+			room.atHistoryStart = !reachedSince
+			break
+		}
+
+		if job.Anchor == "" {
+			job.Anchor = resp.Events[0].ID
+		}
+		job.Offset += len(resp.Events)
+	}
+
+	room.events = mxclient.ReverseEventsCopy(newestFirst)
+	for i, ev := range room.events {
+		room.index[ev.ID] = i
+	}
+	return room, nil
+}
+
+// exporter renders the pages of crawled rooms into an archive.
+type exporter struct {
+	ctx       context.Context
+	pool      *workers.Workers
+	archive   *archive
+	sanitizer *sanitizer.Sanitizer
+
+	mediaBaseURL string
+}
+
+func (e *exporter) writePage(name string, p templates.Page) error {
+	buf := new(bytes.Buffer)
+	templates.WritePageTemplate(buf, p)
+	return e.archive.writePage(name, buf.Bytes())
+}
+
+// exportRoom writes every page of the room, removing any left over from previous exports which no longer exist.
+func (e *exporter) exportRoom(room *exportedRoom) error {
+	roomID := room.info.RoomID
+	for _, export := range []func(*exportedRoom) error{e.exportTimeline, e.exportMembers, e.exportServers, e.exportAliases, e.exportPowerLevels, e.exportCalendar} {
+		if err := export(room); err != nil {
+			return err
+		}
+	}
+	return e.archive.prune(roomFile(roomID, ""))
+}
+
+// exportState is what is remembered of the previous export of a room, kept within the archive.
+type exportState struct {
+	// FirstEventID and LastEventID are the oldest and latest events of the timeline exported.
+	FirstEventID string `json:"first_event_id"`
+	LastEventID  string `json:"last_event_id"`
+}
+
+func exportStatePath(roomID string) string {
+	return roomFile(roomID, "export-state.json")
+}
+
+// firstChangedPage returns the first timeline page which may have changed since the previous export of the room,
+// being the page of the last event exported then as pages are counted from the oldest event. Should the timeline no
+// longer start at the same event, e.g. as --since changed, every page has moved so the first is returned.
+func (e *exporter) firstChangedPage(room *exportedRoom) int {
+	data, err := e.archive.readFile(exportStatePath(room.info.RoomID))
+	if err != nil || len(room.events) == 0 {
+		return 1
+	}
+	var state exportState
+	if err = json.Unmarshal(data, &state); err != nil || state.FirstEventID != room.events[0].ID {
+		return 1
+	}
+	index, found := room.index[state.LastEventID]
+	if !found {
+		return 1
+	}
+	return index/RoomTimelineSize + 1
+}
+
+// exportTimeline writes the timeline pages from the one holding the last event exported previously onwards, leaving
+// the older pages as they were. Edits, reactions and redactions of the events on those are therefore not reflected.
+func (e *exporter) exportTimeline(room *exportedRoom) error {
+	firstChanged := e.firstChangedPage(room)
+	numPages := room.numPages()
+	for page := 1; page <= numPages; page++ {
+		name := timelinePagePath(room.info.RoomID, page)
+		if page < firstChanged && e.archive.keepFile(name) {
+			continue
+		}
+
+		start := (page - 1) * RoomTimelineSize
+		end := start + RoomTimelineSize
+		if end > len(room.events) {
+			end = len(room.events)
+		}
+		events := room.events[start:end]
+
+		chatPage := &templates.RoomChatPage{
+			RoomInfo:  room.info,
+			MemberMap: room.memberMap,
+			Events:    events,
+			Reactions: room.reactions,
+			Threads:   room.threads,
+			InReplyTo: room.inReplyTo,
+			PageSize:  RoomTimelineSize,
+
+			AtTopEnd:    page == 1 && room.atHistoryStart,
+			AtBottomEnd: page == numPages,
+
+			Sanitizer:    e.sanitizer,
+			MediaBaseURL: e.mediaBaseURL,
+		}
+		// the links to older and newer pages are relative to the latest event of the page, see archive.roomPath.
+		if len(events) > 0 {
+			chatPage.Anchor = events[len(events)-1].ID
+		}
+
+		if err := e.writePage(name, chatPage); err != nil {
+			return err
+		}
+		if page == numPages {
+			if err := e.writePage(roomFile(room.info.RoomID, "index.html"), chatPage); err != nil {
+				return err
+			}
+		}
+	}
+
+	var state exportState
+	if len(room.events) > 0 {
+		state = exportState{room.events[0].ID, room.events[len(room.events)-1].ID}
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return e.archive.writeFile(exportStatePath(room.info.RoomID), data)
+}
+
+// exportList writes each page of a paginated list of the room, as well as the page listing everything.
+// job returns the page for the given page number, and whether there is a page after it.
+func (e *exporter) exportList(room *exportedRoom, list string, job func(page int) (templates.Page, bool, error)) error {
+	for page := 1; ; page++ {
+		p, hasNext, err := job(page)
+		if err != nil {
+			return err
+		}
+		if err = e.writePage(roomFile(room.info.RoomID, listFile(list, page)), p); err != nil {
+			return err
+		}
+		if !hasNext {
+			break
+		}
+	}
+
+	p, _, err := job(0)
+	if err != nil {
+		return err
+	}
+	return e.writePage(roomFile(room.info.RoomID, listFile(list, 0)), p)
+}
+
+func (e *exporter) exportMembers(room *exportedRoom) error {
+	return e.exportList(room, "members", func(page int) (templates.Page, bool, error) {
+		resp, err := e.pool.Submit(e.ctx, room.info.RoomID, workers.RoomMembersJob{
+			RoomID:   room.info.RoomID,
+			Page:     page,
+			PageSize: RoomMembersPageSize,
+		})
+		if err != nil {
+			return nil, false, err
+		}
+		p := templates.RoomMembersPage(resp.(workers.RoomMembersResp))
+		if p.Err != nil {
+			return nil, false, p.Err
+		}
+		return &p, p.HasNextPage(), nil
+	})
+}
+
+func (e *exporter) exportServers(room *exportedRoom) error {
+	return e.exportList(room, "servers", func(page int) (templates.Page, bool, error) {
+		resp, err := e.pool.Submit(e.ctx, room.info.RoomID, workers.RoomServersJob{
+			RoomID:   room.info.RoomID,
+			Page:     page,
+			PageSize: RoomServersPageSize,
+		})
+		if err != nil {
+			return nil, false, err
+		}
+		p := templates.RoomServersPage(resp.(workers.RoomServersResp))
+		if p.Err != nil {
+			return nil, false, p.Err
+		}
+		return &p, p.HasNextPage(), nil
+	})
+}
+
+func (e *exporter) exportAliases(room *exportedRoom) error {
+	return e.exportList(room, "aliases", func(page int) (templates.Page, bool, error) {
+		resp, err := e.pool.Submit(e.ctx, room.info.RoomID, workers.RoomAliasesJob{
+			RoomID:   room.info.RoomID,
+			Page:     page,
+			PageSize: RoomAliasesPageSize,
+		})
+		if err != nil {
+			return nil, false, err
+		}
+		p := templates.RoomAliasesPage(resp.(workers.RoomAliasesResp))
+		if p.Err != nil {
+			return nil, false, p.Err
+		}
+		return &p, p.HasNextPage(), nil
+	})
+}
+
+func (e *exporter) exportPowerLevels(room *exportedRoom) error {
+	resp, err := e.pool.Submit(e.ctx, room.info.RoomID, workers.RoomPowerLevelsJob{RoomID: room.info.RoomID})
+	if err != nil {
+		return err
+	}
+	p := templates.RoomPowerLevelsPage(resp.(workers.RoomPowerLevelsResp))
+	if p.Err != nil {
+		return p.Err
+	}
+	return e.writePage(roomFile(room.info.RoomID, "power_levels/index.html"), &p)
+}
+
+func (e *exporter) exportCalendar(room *exportedRoom) error {
+	return e.writePage(roomFile(room.info.RoomID, "calendar/index.html"), &templates.RoomCalendarPage{
+		RoomInfo:       room.info,
+		Days:           mxclient.MessageDays(room.events),
+		AtHistoryStart: room.atHistoryStart,
+	})
+}
+
+// exportIndex writes the list of exported rooms.
+func (e *exporter) exportIndex() error {
+	rooms := make([]gomatrix.PublicRoom, 0, len(e.archive.rooms))
+	for _, room := range e.archive.rooms {
+		rooms = append(rooms, gomatrix.PublicRoom{
+			RoomID:           room.info.RoomID,
+			Name:             room.info.Name,
+			CanonicalAlias:   room.info.CanonicalAlias,
+			Topic:            room.info.Topic,
+			NumJoinedMembers: room.info.NumMembers,
+			AvatarURL:        room.info.AvatarURL.ToThumbURL(60, 60, "crop"),
+		})
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].Name < rooms[j].Name
+	})
+
+	return e.writePage("index.html", &templates.RoomsPage{
+		Rooms: rooms,
+		Page:  1,
+		// there is only ever the one page.
+		PageSize: len(rooms) + 1,
+	})
+}
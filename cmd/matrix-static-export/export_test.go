@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/mxclient"
+	"github.com/matrix-org/matrix-static/sanitizer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExporter_exportTimeline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "matrix-static-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	room := &exportedRoom{
+		info:  mxclient.RoomInfo{RoomID: "!r:b"},
+		index: make(map[string]int),
+	}
+	addEvents := func(n int) {
+		for i := 0; i < n; i++ {
+			ev := gomatrix.Event{ID: fmt.Sprintf("$%d", len(room.events)), Type: "m.room.message", Content: map[string]interface{}{"body": "hi"}}
+			room.index[ev.ID] = len(room.events)
+			room.events = append(room.events, ev)
+		}
+	}
+	export := func() *archive {
+		a := testArchive()
+		a.dir = dir
+		a.rooms = map[string]*exportedRoom{"!r:b": room}
+		e := &exporter{archive: a, sanitizer: sanitizer.InitSanitizer()}
+		if err := e.exportTimeline(room); err != nil {
+			t.Fatal("Failed exporting timeline", err)
+		}
+		return a
+	}
+	pageFile := func(page int) string {
+		return filepath.Join(dir, filepath.FromSlash(timelinePagePath("!r:b", page)))
+	}
+
+	addEvents(65)
+	export()
+
+	// mark the pages so as to tell whether they are rendered again.
+	for page := 1; page <= 3; page++ {
+		if err := ioutil.WriteFile(pageFile(page), []byte("unchanged"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	addEvents(30)
+	a := export()
+	for page, expUnchanged := range map[int]bool{1: true, 2: true, 3: false, 4: false} {
+		data, err := ioutil.ReadFile(pageFile(page))
+		if err != nil || (string(data) == "unchanged") != expUnchanged {
+			t.Errorf("page %d: unchanged = %t, want %t", page, string(data) == "unchanged", expUnchanged)
+		}
+		if !a.written[timelinePagePath("!r:b", page)] {
+			t.Errorf("page %d should be kept by the export", page)
+		}
+	}
+
+	// once the timeline starts elsewhere every page has moved.
+	room.events = room.events[1:]
+	room.index = make(map[string]int)
+	for i, ev := range room.events {
+		room.index[ev.ID] = i
+	}
+	export()
+	if data, _ := ioutil.ReadFile(pageFile(1)); string(data) == "unchanged" {
+		t.Error("every page should be rendered again once the timeline starts at another event")
+	}
+}
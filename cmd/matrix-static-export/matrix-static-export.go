@@ -0,0 +1,142 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/matrix-org/matrix-static/mxclient"
+	"github.com/matrix-org/matrix-static/sanitizer"
+	"github.com/matrix-org/matrix-static/workers"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type configVars struct {
+	ConfigFile string
+	OutputDir  string
+	AssetsDir  string
+	StorePath  string
+	Since      string
+}
+
+func main() {
+	config := configVars{}
+
+	flag.StringVar(&config.ConfigFile, "config-file", "./config.json", "The path to the desired config file.")
+	flag.StringVar(&config.OutputDir, "output-dir", "./export", "Directory to write the archive to.")
+	flag.StringVar(&config.AssetsDir, "assets-dir", "./assets", "Directory holding the stylesheets and images to copy into the archive.")
+	flag.StringVar(&config.StorePath, "store-path", "./export-store", "Directory to persist crawled rooms to so that re-runs only fetch what is new, disabled if empty.")
+	flag.StringVar(&config.Since, "since", "", "Only export events sent on or after this day, as YYYY-MM-DD. Exports all history if empty.")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <room ID or alias>...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := export(config, flag.Args()); err != nil {
+		log.WithError(err).Error("Export failed")
+		os.Exit(1)
+	}
+}
+
+// export crawls each of rooms and writes them to an archive in config.OutputDir.
+func export(config configVars, rooms []string) error {
+	var since int64
+	if config.Since != "" {
+		date, err := time.ParseInLocation(mxclient.DayFormat, config.Since, time.Local)
+		if err != nil {
+			return fmt.Errorf("invalid --since: %v", err)
+		}
+		since = mxclient.Timestamp(date)
+	}
+
+	client, err := mxclient.NewClient(config.ConfigFile)
+	if err != nil {
+		return err
+	}
+	if config.StorePath != "" {
+		store, err := mxclient.NewBoltRoomStore(config.StorePath)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		client.Store = store
+	}
+
+	a, err := newArchive(config.OutputDir, client)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	pool := workers.NewWorkers(1, client)
+	e := &exporter{
+		ctx:          ctx,
+		pool:         pool,
+		archive:      a,
+		sanitizer:    sanitizer.InitSanitizer(),
+		mediaBaseURL: client.MediaBaseURL,
+	}
+
+	// every room is crawled before any is written so that links between them can point within the archive.
+	for _, roomIDOrAlias := range rooms {
+		roomID := roomIDOrAlias
+		if strings.HasPrefix(roomIDOrAlias, "#") {
+			resp, err := client.GetRoomDirectoryAlias(ctx, roomIDOrAlias)
+			if err != nil {
+				return fmt.Errorf("resolving %s: %v", roomIDOrAlias, err)
+			}
+			roomID = resp.RoomID
+		}
+
+		log.WithField("roomID", roomID).Info("Crawling Room")
+		room, err := crawlRoom(ctx, pool, roomID, since)
+		if err != nil {
+			return fmt.Errorf("crawling %s: %v", roomID, err)
+		}
+		a.rooms[roomID] = room
+	}
+
+	if err = a.copyDir(config.AssetsDir); err != nil {
+		return err
+	}
+	for roomID, room := range a.rooms {
+		log.WithField("roomID", roomID).WithField("numEvents", len(room.events)).Info("Exporting Room")
+		if err = e.exportRoom(room); err != nil {
+			return fmt.Errorf("exporting %s: %v", roomID, err)
+		}
+	}
+	if err = e.exportIndex(); err != nil {
+		return err
+	}
+	log.WithField("numFiles", len(a.written)).WithField("numChanged", a.numChanged).Info("Finished Exporting")
+
+	// persist the rooms so that the next export only has to fetch what happened since.
+	wg := &sync.WaitGroup{}
+	wg.Add(int(pool.NumWorkers))
+	pool.JobForAllWorkers(workers.RoomPersistJob{Wg: wg})
+	wg.Wait()
+	return nil
+}
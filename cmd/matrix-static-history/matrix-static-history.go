@@ -0,0 +1,106 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/history"
+	"github.com/matrix-org/matrix-static/mxclient"
+	"io"
+	"os"
+	"strings"
+)
+
+type configVars struct {
+	ConfigFile string
+	Format     string
+	Output     string
+}
+
+func main() {
+	config := configVars{}
+
+	flag.StringVar(&config.ConfigFile, "config-file", "./config.json", "The path to the desired config file.")
+	flag.StringVar(&config.Format, "format", "jsonl", "Format to write the history in, one of jsonl, csv or txt.")
+	flag.StringVar(&config.Output, "output", "", "File to write the history to, standard output if empty.")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <room ID or alias>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(config, flag.Arg(0)); err != nil {
+		log.WithError(err).Error("Export failed")
+		os.Exit(1)
+	}
+}
+
+// run streams the history of roomIDOrAlias, newest first, to config.Output.
+func run(config configVars, roomIDOrAlias string) error {
+	out := io.Writer(os.Stdout)
+	if config.Output != "" {
+		f, err := os.Create(config.Output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	w, err := history.NewWriter(config.Format, out)
+	if err != nil {
+		return fmt.Errorf("invalid --format %q: %v", config.Format, err)
+	}
+
+	client, err := mxclient.NewClient(config.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	roomID := roomIDOrAlias
+	if strings.HasPrefix(roomIDOrAlias, "#") {
+		resp, err := client.GetRoomDirectoryAlias(ctx, roomIDOrAlias)
+		if err != nil {
+			return fmt.Errorf("resolving %s: %v", roomIDOrAlias, err)
+		}
+		roomID = resp.RoomID
+	}
+
+	numEvents := 0
+	err = client.StreamHistory(ctx, roomID, func(ev *gomatrix.Event, displayName string) error {
+		numEvents++
+		return w.WriteEvent(ev, displayName)
+	})
+	// write out whatever was received even if the stream was cut short.
+	if flushErr := w.Flush(); err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		return fmt.Errorf("exporting %s: %v", roomID, err)
+	}
+
+	log.WithField("roomID", roomID).WithField("numEvents", numEvents).Info("Finished Exporting")
+	return nil
+}
@@ -0,0 +1,429 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/api"
+	"github.com/matrix-org/matrix-static/mxclient"
+	"github.com/matrix-org/matrix-static/utils"
+	"github.com/matrix-org/matrix-static/workers"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// registerAPIRoutes serves the JSON API mirroring the HTML routes under /api/v1/, see the api package for its schemas.
+func registerAPIRoutes(publicRouter *gin.RouterGroup, config configVars, client *mxclient.Client, pool *workers.Workers, worldReadableRooms *mxclient.WorldReadableRooms) {
+	apiRouter := publicRouter.Group("/api/v1")
+
+	apiRouter.GET("/rooms", func(c *gin.Context) {
+		page := utils.StrToIntDefault(c.DefaultQuery("page", "1"), 1)
+		query := c.Query("query")
+
+		rooms := worldReadableRooms.GetPage(page, PublicRoomsPageSize)
+		if query != "" {
+			rooms = worldReadableRooms.GetFilteredPage(page, PublicRoomsPageSize, query)
+		}
+
+		c.JSON(http.StatusOK, api.PublicRoomsResp{
+			Pagination: api.Pagination{Page: page, PageSize: PublicRoomsPageSize},
+			Query:      query,
+			Rooms:      rooms,
+		})
+	})
+
+	apiRouter.GET("/alias/:roomAlias", func(c *gin.Context) {
+		roomAlias := c.Param("roomAlias")
+		if !strings.HasPrefix(roomAlias, "#") {
+			roomAlias = "#" + roomAlias
+		}
+
+		resp, err := client.GetRoomDirectoryAlias(c.Request.Context(), roomAlias)
+		if err != nil {
+			writeAPIError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, api.AliasResp{RoomID: resp.RoomID, Servers: resp.Servers})
+	})
+
+	roomRouter := apiRouter.Group("/room/:roomID/")
+	{
+		// Ensure the room is loaded into its worker before any of the room routes are handled
+		roomRouter.Use(func(c *gin.Context) {
+			if !strings.HasPrefix(c.Param("roomID"), "!") {
+				abortWithAPIError(c, http.StatusBadRequest, api.ErrCodeInvalidParam, "Room ID must start with a '!'")
+				return
+			}
+
+			if err := pool.LoadRoom(c.Request.Context(), c.Param("roomID")); err != nil {
+				writeAPIError(c, err)
+				return
+			}
+			c.Next()
+		})
+
+		roomRouter.GET("/", func(c *gin.Context) {
+			job := workers.RoomEventsJob{
+				RoomID:   c.Param("roomID"),
+				Anchor:   c.Query("anchor"),
+				Offset:   utils.StrToIntDefault(c.DefaultQuery("offset", "0"), 0),
+				PageSize: RoomTimelineSize,
+			}
+
+			var jobResult workers.RoomEventsResp
+			var err error
+			if config.FollowRoomUpgrades {
+				jobResult, err = pool.GetRoomEventsAcrossUpgrades(c.Request.Context(), job)
+			} else {
+				jobResult, err = pool.GetRoomEvents(c.Request.Context(), job)
+			}
+			if err == nil {
+				err = jobResult.Err
+			}
+			if err != nil {
+				writeAPIError(c, err)
+				return
+			}
+
+			c.JSON(http.StatusOK, newAPIEventsResp(jobResult, job.Anchor, job.Offset, job.PageSize))
+		})
+
+		roomRouter.GET("/edits/:eventID", func(c *gin.Context) {
+			jobResp, ok := submitAPIRoomJob(c, pool, workers.RoomEditsJob{
+				RoomID:  c.Param("roomID"),
+				EventID: c.Param("eventID"),
+			})
+			if !ok {
+				return
+			}
+
+			jobResult := jobResp.(workers.RoomEditsResp)
+			if jobResult.Err == workers.ErrRoomNotLoaded {
+				writeAPIError(c, jobResult.Err)
+				return
+			}
+			if jobResult.Err != nil {
+				abortWithAPIError(c, http.StatusNotFound, api.ErrCodeNotFound, jobResult.Err.Error())
+				return
+			}
+
+			c.JSON(http.StatusOK, api.EditsResp{
+				Room:     api.NewRoom(jobResult.RoomInfo),
+				Original: jobResult.Original,
+				Edits:    nonNilEvents(jobResult.Edits),
+				Members:  api.NewMembers(append([]gomatrix.Event{jobResult.Original}, jobResult.Edits...), jobResult.MemberMap),
+			})
+		})
+
+		roomRouter.GET("/thread/:eventID", func(c *gin.Context) {
+			from := c.Query("from")
+			jobResult, err := pool.GetRoomThread(c.Request.Context(), c.Param("roomID"), c.Param("eventID"), from, RoomTimelineSize)
+			if err != nil {
+				writeAPIError(c, err)
+				return
+			}
+
+			c.JSON(http.StatusOK, api.ThreadResp{
+				Room:      api.NewRoom(jobResult.RoomInfo),
+				Root:      jobResult.Root,
+				Replies:   nonNilEvents(jobResult.Replies),
+				From:      from,
+				NextBatch: jobResult.NextBatch,
+				Members:   api.NewMembers(append([]gomatrix.Event{jobResult.Root}, jobResult.Replies...), jobResult.MemberMap),
+				Reactions: api.NewReactions(jobResult.Reactions),
+				InReplyTo: jobResult.InReplyTo,
+			})
+		})
+
+		roomRouter.GET("/search", func(c *gin.Context) {
+			query := c.Query("q")
+			if query == "" {
+				abortWithAPIError(c, http.StatusBadRequest, api.ErrCodeInvalidParam, "Missing search term q")
+				return
+			}
+
+			jobResult, err := pool.SearchRoom(c.Request.Context(), workers.RoomSearchJob{
+				RoomID:     c.Param("roomID"),
+				Query:      query,
+				From:       c.Query("from"),
+				PageSize:   RoomSearchPageSize,
+				AllowLocal: config.EnableSearchIndex,
+			})
+			if err == nil {
+				err = jobResult.Err
+			}
+			if err == workers.ErrSearchUnavailable {
+				abortWithAPIError(c, http.StatusNotImplemented, api.ErrCodeUnrecognized, err.Error())
+				return
+			}
+			if err != nil {
+				writeAPIError(c, err)
+				return
+			}
+
+			results := jobResult.Results
+			highlights := results.Highlights
+			if highlights == nil {
+				highlights = []string{}
+			}
+			c.JSON(http.StatusOK, api.SearchResp{
+				Room:       api.NewRoom(jobResult.RoomInfo),
+				Query:      query,
+				From:       c.Query("from"),
+				Events:     nonNilEvents(results.Events),
+				Highlights: highlights,
+				Count:      results.Count,
+				NextBatch:  results.NextBatch,
+				Members:    api.NewMembers(results.Events, jobResult.MemberMap),
+			})
+		})
+
+		roomRouter.GET("/date/:day", func(c *gin.Context) {
+			day := c.Param("day")
+			date, err := time.ParseInLocation(mxclient.DayFormat, day, time.Local)
+			if err != nil {
+				abortWithAPIError(c, http.StatusBadRequest, api.ErrCodeInvalidParam, "Dates must be given as YYYY-MM-DD")
+				return
+			}
+
+			jobResult, err := pool.FindRoomEventByDate(c.Request.Context(), c.Param("roomID"), mxclient.Timestamp(date))
+			if err != nil {
+				writeAPIError(c, err)
+				return
+			}
+
+			c.JSON(http.StatusOK, api.DateResp{Day: day, EventID: jobResult.EventID, OutsideHistory: jobResult.OutsideHistory})
+		})
+
+		roomRouter.GET("/calendar", func(c *gin.Context) {
+			job := workers.RoomCalendarJob{RoomID: c.Param("roomID")}
+			if since, err := time.ParseInLocation(mxclient.MonthFormat, c.Query("since"), time.Local); err == nil {
+				job.Since = mxclient.Timestamp(since)
+			}
+
+			jobResult, err := pool.GetRoomCalendar(c.Request.Context(), job)
+			if err != nil {
+				writeAPIError(c, err)
+				return
+			}
+
+			c.JSON(http.StatusOK, api.CalendarResp{
+				Room:           api.NewRoom(jobResult.RoomInfo),
+				Days:           jobResult.Days,
+				AtHistoryStart: jobResult.AtHistoryStart,
+			})
+		})
+
+		roomRouter.GET("/servers", func(c *gin.Context) {
+			jobResp, ok := submitAPIRoomJob(c, pool, workers.RoomServersJob{
+				RoomID:   c.Param("roomID"),
+				Page:     utils.StrToIntDefault(c.DefaultQuery("page", "1"), 1),
+				PageSize: RoomServersPageSize,
+			})
+			if !ok {
+				return
+			}
+
+			jobResult := jobResp.(workers.RoomServersResp)
+			if jobResult.Err != nil {
+				writeAPIError(c, jobResult.Err)
+				return
+			}
+			servers := make([]api.ServerUserCount, 0, len(jobResult.Servers))
+			for _, server := range jobResult.Servers {
+				servers = append(servers, api.ServerUserCount{ServerName: server.ServerName, NumUsers: server.NumUsers})
+			}
+
+			c.JSON(http.StatusOK, api.ServersResp{
+				Pagination: api.Pagination{Page: jobResult.Page, PageSize: jobResult.PageSize},
+				Room:       api.NewRoom(jobResult.RoomInfo),
+				Servers:    servers,
+			})
+		})
+
+		roomRouter.GET("/aliases", func(c *gin.Context) {
+			jobResp, ok := submitAPIRoomJob(c, pool, workers.RoomAliasesJob{
+				RoomID:   c.Param("roomID"),
+				Page:     utils.StrToIntDefault(c.DefaultQuery("page", "1"), 1),
+				PageSize: RoomAliasesPageSize,
+			})
+			if !ok {
+				return
+			}
+
+			jobResult := jobResp.(workers.RoomAliasesResp)
+			if jobResult.Err != nil {
+				writeAPIError(c, jobResult.Err)
+				return
+			}
+			aliases := make([]api.ServerAliases, 0, len(jobResult.RoomAliases))
+			for _, server := range jobResult.RoomAliases {
+				aliases = append(aliases, api.ServerAliases{ServerName: server.ServerName, Aliases: server.Aliases})
+			}
+
+			c.JSON(http.StatusOK, api.AliasesResp{
+				Pagination: api.Pagination{Page: jobResult.Page, PageSize: jobResult.PageSize},
+				Room:       api.NewRoom(jobResult.RoomInfo),
+				Aliases:    aliases,
+			})
+		})
+
+		roomRouter.GET("/members", func(c *gin.Context) {
+			jobResp, ok := submitAPIRoomJob(c, pool, workers.RoomMembersJob{
+				RoomID:   c.Param("roomID"),
+				Page:     utils.StrToIntDefault(c.DefaultQuery("page", "1"), 1),
+				PageSize: RoomMembersPageSize,
+			})
+			if !ok {
+				return
+			}
+
+			jobResult := jobResp.(workers.RoomMembersResp)
+			if jobResult.Err != nil {
+				writeAPIError(c, jobResult.Err)
+				return
+			}
+			members := make([]api.Member, 0, len(jobResult.Members))
+			for _, member := range jobResult.Members {
+				members = append(members, api.NewMember(member))
+			}
+
+			c.JSON(http.StatusOK, api.MembersResp{
+				Pagination: api.Pagination{Page: jobResult.Page, PageSize: jobResult.PageSize},
+				Room:       api.NewRoom(jobResult.RoomInfo),
+				Members:    members,
+			})
+		})
+
+		roomRouter.GET("/members/:mxid", func(c *gin.Context) {
+			jobResp, ok := submitAPIRoomJob(c, pool, workers.RoomMemberInfoJob{
+				RoomID: c.Param("roomID"),
+				Mxid:   c.Param("mxid"),
+			})
+			if !ok {
+				return
+			}
+
+			jobResult := jobResp.(workers.RoomMemberInfoResp)
+			if jobResult.Err == workers.ErrRoomNotLoaded {
+				writeAPIError(c, jobResult.Err)
+				return
+			}
+			if jobResult.Err != nil {
+				abortWithAPIError(c, http.StatusNotFound, api.ErrCodeNotFound, jobResult.Err.Error())
+				return
+			}
+
+			c.JSON(http.StatusOK, api.MemberResp{
+				Room:   api.NewRoom(jobResult.RoomInfo),
+				Member: api.NewMember(jobResult.MemberInfo),
+			})
+		})
+
+		roomRouter.GET("/power_levels", func(c *gin.Context) {
+			jobResp, ok := submitAPIRoomJob(c, pool, workers.RoomPowerLevelsJob{RoomID: c.Param("roomID")})
+			if !ok {
+				return
+			}
+
+			jobResult := jobResp.(workers.RoomPowerLevelsResp)
+			if jobResult.Err != nil {
+				writeAPIError(c, jobResult.Err)
+				return
+			}
+			c.JSON(http.StatusOK, api.PowerLevelsResp{
+				Room:        api.NewRoom(jobResult.RoomInfo),
+				PowerLevels: jobResult.PowerLevels,
+			})
+		})
+	}
+}
+
+// newAPIEventsResp converts a page of the timeline as the HTML timeline would show it, defaulting the anchor to the
+// latest event on the page.
+func newAPIEventsResp(resp workers.RoomEventsResp, anchor string, offset, pageSize int) api.EventsResp {
+	if anchor == "" && len(resp.Events) > 0 {
+		anchor = resp.Events[0].ID
+	}
+
+	threads := make(map[string]api.ThreadSummary, len(resp.Threads))
+	for eventID, thread := range resp.Threads {
+		threads[eventID] = api.ThreadSummary{Count: thread.Count}
+	}
+
+	apiResp := api.EventsResp{
+		Room:        api.NewRoom(resp.RoomInfo),
+		Anchor:      anchor,
+		Offset:      offset,
+		PageSize:    pageSize,
+		AtTopEnd:    resp.AtTopEnd,
+		AtBottomEnd: resp.AtBottomEnd,
+
+		Events:    nonNilEvents(resp.Events),
+		Members:   api.NewMembers(resp.Events, resp.MemberMap),
+		Reactions: api.NewReactions(resp.Reactions),
+		Threads:   threads,
+		InReplyTo: resp.InReplyTo,
+	}
+
+	if predecessor := resp.Predecessor; predecessor != nil && len(predecessor.Events) > 0 {
+		predecessorResp := newAPIEventsResp(*predecessor, "", 0, len(predecessor.Events))
+		apiResp.Predecessor = &predecessorResp
+	}
+	return apiResp
+}
+
+// nonNilEvents ensures empty lists of events are encoded as [] rather than null.
+func nonNilEvents(events []gomatrix.Event) []gomatrix.Event {
+	if events == nil {
+		return []gomatrix.Event{}
+	}
+	return events
+}
+
+// writeAPIError responds with the JSON error for err, then aborts the request.
+func writeAPIError(c *gin.Context, err error) {
+	defer c.Abort()
+
+	// if the client went away there is nobody left to respond to.
+	if err == context.Canceled {
+		return
+	}
+	status, apiErr := api.NewError(err)
+	if status == http.StatusServiceUnavailable {
+		c.Header("Retry-After", strconv.Itoa(api.RetryAfter))
+	}
+	c.JSON(status, apiErr)
+}
+
+// abortWithAPIError responds with an error of our own rather than one for a failed request, then aborts the request.
+func abortWithAPIError(c *gin.Context, status int, errCode, message string) {
+	c.JSON(status, api.Error{ErrCode: errCode, Err: message})
+	c.Abort()
+}
+
+// submitAPIRoomJob is submitRoomJob for the JSON API.
+func submitAPIRoomJob(c *gin.Context, pool *workers.Workers, job workers.Job) (resp workers.JobResp, ok bool) {
+	resp, err := pool.Submit(c.Request.Context(), c.Param("roomID"), job)
+	if err != nil {
+		writeAPIError(c, err)
+		return nil, false
+	}
+	return resp, true
+}
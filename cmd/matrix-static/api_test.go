@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/matrix-org/matrix-static/api"
+	"github.com/matrix-org/matrix-static/mxclient"
+	"github.com/matrix-org/matrix-static/workers"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// RoundTripFunc .
+type RoundTripFunc func(req *http.Request) *http.Response
+
+// RoundTrip .
+func (f RoundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req), nil
+}
+
+type fakeResp struct {
+	status int
+	body   string
+}
+
+// newTestClient returns a client of a fake homeserver which answers requests to each client API path from resps.
+func newTestClient(resps map[string]fakeResp) *mxclient.Client {
+	cli, _ := mxclient.NewRawClient("https://example.org", "", "@bot:example.org", "")
+	cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+		resp, exists := resps[strings.TrimPrefix(req.URL.Path, "/_matrix/client/r0")]
+		if !exists {
+			resp = fakeResp{http.StatusNotFound, `{"errcode":"M_NOT_FOUND","error":"Not found"}`}
+		}
+		return &http.Response{
+			StatusCode: resp.status,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(resp.body)),
+			Header:     make(http.Header),
+		}
+	})
+	return cli
+}
+
+// newTestAPIRouter serves the JSON API backed by a homeserver answering with resps by path, and M_NOT_FOUND otherwise.
+func newTestAPIRouter(resps map[string]fakeResp) *gin.Engine {
+	cli := newTestClient(resps)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	registerAPIRoutes(router.Group("/"), configVars{}, cli, workers.NewWorkers(1, cli), cli.NewWorldReadableRooms())
+	return router
+}
+
+func serveAPI(t *testing.T, router *gin.Engine, path string, v interface{}) int {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Errorf("%s: Content-Type = %q, want JSON", path, ct)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Errorf("%s: invalid JSON body %q: %v", path, w.Body.String(), err)
+	}
+	return w.Code
+}
+
+var testRoomResps = map[string]fakeResp{
+	"/publicRooms": {http.StatusOK, `{"chunk":[]}`},
+	"/rooms/!room/state": {http.StatusOK, `[
+		{"type":"m.room.name","state_key":"","content":{"name":"Test Room"}},
+		{"type":"m.room.member","state_key":"@alice:example.org","sender":"@alice:example.org","content":{"membership":"join","displayname":"Alice"}},
+		{"type":"m.room.power_levels","state_key":"","content":{"users":{"@alice:example.org":100}}},
+		{"type":"m.room.aliases","state_key":"example.org","content":{"aliases":["#test:example.org"]}}
+	]`},
+	"/rooms/!room/messages": {http.StatusOK, `{"chunk":[
+		{"event_id":"$2","type":"m.room.message","sender":"@alice:example.org","origin_server_ts":2,"content":{"body":"world"}},
+		{"event_id":"$1","type":"m.room.message","sender":"@alice:example.org","origin_server_ts":1,"content":{"body":"hello"}}
+	],"start":"s1"}`},
+}
+
+func TestAPI_RoomRoutes(t *testing.T) {
+	router := newTestAPIRouter(testRoomResps)
+
+	var events api.EventsResp
+	if status := serveAPI(t, router, "/api/v1/room/!room/", &events); status != http.StatusOK {
+		t.Fatal("Timeline status", status)
+	}
+	if events.Room.Name != "Test Room" || len(events.Events) != 2 || events.Anchor != events.Events[0].ID {
+		t.Error("Timeline mismatch expectation", events)
+	}
+	if member := events.Members["@alice:example.org"]; member.DisplayName != "Alice" {
+		t.Error("Senders should be included as members", events.Members)
+	}
+
+	var members api.MembersResp
+	if status := serveAPI(t, router, "/api/v1/room/!room/members", &members); status != http.StatusOK || len(members.Members) != 1 {
+		t.Error("Members mismatch expectation", status, members)
+	}
+
+	var member api.MemberResp
+	if status := serveAPI(t, router, "/api/v1/room/!room/members/@alice:example.org", &member); status != http.StatusOK || member.Member.PowerLevel != 100 {
+		t.Error("Member mismatch expectation", status, member)
+	}
+
+	var servers api.ServersResp
+	if status := serveAPI(t, router, "/api/v1/room/!room/servers", &servers); status != http.StatusOK || len(servers.Servers) != 1 || servers.Servers[0].ServerName != "example.org" {
+		t.Error("Servers mismatch expectation", status, servers)
+	}
+
+	var aliases api.AliasesResp
+	if status := serveAPI(t, router, "/api/v1/room/!room/aliases", &aliases); status != http.StatusOK || len(aliases.Aliases) != 1 {
+		t.Error("Aliases mismatch expectation", status, aliases)
+	}
+
+	var powerLevels api.PowerLevelsResp
+	if status := serveAPI(t, router, "/api/v1/room/!room/power_levels", &powerLevels); status != http.StatusOK || powerLevels.Room.RoomID != "!room" {
+		t.Error("Power levels mismatch expectation", status, powerLevels)
+	}
+}
+
+func TestAPI_RoomErrors(t *testing.T) {
+	resps := map[string]fakeResp{
+		"/rooms/!forbidden/state": {http.StatusForbidden, `{"errcode":"M_FORBIDDEN","error":"You are not allowed to view this room"}`},
+		"/rooms/!broken/state":    {http.StatusInternalServerError, `<html>Internal Server Error</html>`},
+	}
+	for path, resp := range testRoomResps {
+		resps[path] = resp
+	}
+	router := newTestAPIRouter(resps)
+
+	tests := []struct {
+		path    string
+		status  int
+		errCode string
+	}{
+		{"/api/v1/room/room/", http.StatusBadRequest, api.ErrCodeInvalidParam},
+		{"/api/v1/room/!forbidden/", http.StatusForbidden, "M_FORBIDDEN"},
+		{"/api/v1/room/!broken/members", http.StatusBadGateway, api.ErrCodeUnknown},
+		{"/api/v1/room/!room/members/@bob:example.org", http.StatusNotFound, api.ErrCodeNotFound},
+		{"/api/v1/room/!room/edits/$missing", http.StatusNotFound, api.ErrCodeNotFound},
+		{"/api/v1/room/!room/search", http.StatusBadRequest, api.ErrCodeInvalidParam},
+		{"/api/v1/room/!room/date/yesterday", http.StatusBadRequest, api.ErrCodeInvalidParam},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			var apiErr api.Error
+			status := serveAPI(t, router, tt.path, &apiErr)
+			if status != tt.status || apiErr.ErrCode != tt.errCode || apiErr.Err == "" {
+				t.Errorf("got %d %+v, want %d %s", status, apiErr, tt.status, tt.errCode)
+			}
+		})
+	}
+}
+
+func TestAPI_RoomNotLoaded(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	// the room being evicted between loading it and submitting the job cannot be raced reliably, so fake it.
+	router.GET("/api/v1/room/:roomID/", func(c *gin.Context) {
+		writeAPIError(c, workers.ErrRoomNotLoaded)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/room/!room/", nil))
+	var apiErr api.Error
+	if err := json.Unmarshal(w.Body.Bytes(), &apiErr); err != nil {
+		t.Fatalf("invalid JSON body %q: %v", w.Body.String(), err)
+	}
+	if w.Code != http.StatusServiceUnavailable || apiErr.ErrCode != api.ErrCodeRoomNotLoaded {
+		t.Errorf("got %d %+v, want %d %s", w.Code, apiErr, http.StatusServiceUnavailable, api.ErrCodeRoomNotLoaded)
+	}
+	if retryAfter := w.Header().Get("Retry-After"); retryAfter != "1" {
+		t.Errorf("Retry-After = %q, want 1", retryAfter)
+	}
+}
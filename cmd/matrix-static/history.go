@@ -0,0 +1,128 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	log "github.com/Sirupsen/logrus"
+	"github.com/gin-gonic/gin"
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/history"
+	"github.com/matrix-org/matrix-static/mxclient"
+	"github.com/matrix-org/matrix-static/templates"
+	"net/http"
+	"strings"
+)
+
+// historyFlushInterval is how many events are written between flushes of the response, so that the client sees the
+// export progress without every event costing a write.
+const historyFlushInterval = 100
+
+// registerHistoryRoutes serves the full history of rooms as a download in each of history.Formats. These are registered
+// apart from the public routes as streaming a room back to the start of its history takes far longer than
+// --request-timeout allows, they are bound by --history-export-timeout instead.
+func registerHistoryRoutes(router *gin.Engine, config configVars, client *mxclient.Client) {
+	historyRouter := router.Group(config.PublicServePrefix)
+	historyRouter.Use(gin.Logger(), gin.Recovery())
+	historyRouter.Use(func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), config.HistoryExportTimeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		extendWriteDeadline(c, config.HistoryExportTimeout)
+		c.Next()
+	})
+
+	historyRouter.GET("/room/:roomID/export.:format", func(c *gin.Context) {
+		roomID, format := c.Param("roomID"), c.Param("format")
+		if !strings.HasPrefix(roomID, "!") {
+			c.Status(http.StatusBadRequest)
+			templates.WritePageTemplate(c.Writer, &templates.ErrorPage{
+				ErrType: "Unable to Load Room.",
+				Details: "Room ID must start with a '!'",
+			})
+			return
+		}
+
+		contentType, ok := history.Formats[format]
+		if !ok {
+			c.Status(http.StatusNotFound)
+			templates.WritePageTemplate(c.Writer, &templates.ErrorPage{
+				ErrType: "Unknown Export Format.",
+				Details: "Rooms can be exported as export.jsonl, export.csv or export.txt.",
+			})
+			return
+		}
+
+		setHeaders := func() {
+			c.Writer.Header().Set("Content-Type", contentType)
+			c.Writer.Header().Set("Content-Disposition", `attachment; filename="`+roomID+"."+format+`"`)
+		}
+
+		w, _ := history.NewWriter(format, c.Writer)
+		numEvents := 0
+		err := client.StreamHistory(c.Request.Context(), roomID, func(ev *gomatrix.Event, displayName string) error {
+			// the headers wait for the first event so that an error page may be written if the room cannot be read.
+			if numEvents == 0 {
+				setHeaders()
+			}
+
+			numEvents++
+			if numEvents%historyFlushInterval == 0 {
+				if err := w.Flush(); err != nil {
+					return err
+				}
+				c.Writer.Flush()
+			}
+			return w.WriteEvent(ev, displayName)
+		})
+
+		if numEvents == 0 {
+			if err != nil {
+				writeHistoryErrorPage(c, err)
+				return
+			}
+			setHeaders()
+		}
+		if flushErr := w.Flush(); err == nil {
+			err = flushErr
+		}
+		// the response is already underway so all that can be done is to cut it short.
+		if err != nil && err != context.Canceled {
+			log.WithError(err).WithField("roomID", roomID).WithField("numEvents", numEvents).Error("Failed Exporting Room History")
+		}
+	})
+}
+
+// writeHistoryErrorPage writes the page for a room whose history could not be exported at all.
+func writeHistoryErrorPage(c *gin.Context, err error) {
+	if isContextError(err) {
+		writeContextErrorPage(c, err)
+		return
+	}
+
+	if respErr, ok := mxclient.UnwrapRespError(err); ok {
+		templates.WritePageTemplate(c.Writer, &templates.ErrorPage{
+			ErrType: "Unable to Export Room.",
+			Details: mxclient.TextForRespError(respErr),
+		})
+		return
+	}
+
+	c.Status(http.StatusBadGateway)
+	templates.WritePageTemplate(c.Writer, &templates.ErrorPage{
+		ErrType: "Cannot Export Room.",
+		Error:   err,
+	})
+}
@@ -15,11 +15,10 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	log "github.com/Sirupsen/logrus"
-	"github.com/disintegration/letteravatar"
 	"github.com/gin-contrib/cache"
 	"github.com/gin-contrib/cache/persistence"
 	"github.com/gin-contrib/pprof"
@@ -32,34 +31,61 @@ import (
 	"github.com/matrix-org/matrix-static/utils"
 	"github.com/matrix-org/matrix-static/workers"
 	"github.com/t3chguy/go-gin-prometheus"
-	"image/png"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
-	"unicode"
-	"unicode/utf8"
 )
 
 const PublicRoomsPageSize = 20
 const RoomTimelineSize = 30
 const RoomMembersPageSize = 20
+const RoomServersPageSize = 30
+const RoomAliasesPageSize = 10
+const RoomSearchPageSize = 20
+const RoomEmbedSize = 10
+
+// outsideHistoryNotice marks the redirects of /date to a timeline which does not reach back to the date asked for.
+const outsideHistoryNotice = "outside-history"
 
 type configVars struct {
 	ConfigFile string
 	NumWorkers int
 
 	PublicServePrefix       string
+	PublicURL               string
+	TrustProxyHeaders       bool
 	EnablePrometheusMetrics bool
 	EnablePprof             bool
 
 	LastAccessDiscardDuration time.Duration
 	KeepAtLeastNRooms         int
+	MaxCachedEvents           int
+	PeekedRoomPollPeriod      time.Duration
+
+	RequestTimeout time.Duration
+
+	StorePath            string
+	MemoryStoreMaxEvents int
+
+	FollowRoomUpgrades bool
+	EnableSearchIndex  bool
+
+	EnableHistoryExport  bool
+	HistoryExportTimeout time.Duration
+
+	EnableLiveStream     bool
+	StreamTimeout        time.Duration
+	MaxStreamSubscribers int
+	MaxStreams           int
 
 	LogDir string
 }
@@ -79,12 +105,27 @@ func main() {
 	flag.IntVar(&config.NumWorkers, "num-workers", 32, "Number of Worker goroutines to start.")
 
 	flag.StringVar(&config.PublicServePrefix, "public-serve-prefix", "/", "Prefix for publicly accessible routes.")
+	flag.StringVar(&config.PublicURL, "public-url", "", "Absolute URL the public routes are served at, for links which cannot be relative such as in feeds. Derived from each request if empty.")
+	flag.BoolVar(&config.TrustProxyHeaders, "trust-proxy-headers", false, "Whether to derive the public URL from the X-Forwarded-Proto and X-Forwarded-Host headers of a reverse proxy if there is no --public-url.")
 	flag.BoolVar(&config.EnablePrometheusMetrics, "enable-prometheus-metrics", false, "Whether or not to enable the /metrics endpoint.")
 	flag.BoolVar(&config.EnablePprof, "enable-pprof", false, "Whether or not to enable the /debug/pprof endpoints.")
 	flag.StringVar(&config.LogDir, "logger-directory", "", "Where to write the info, warn and error logs to.")
+	flag.StringVar(&config.StorePath, "store-path", "", "Directory to persist rooms to across evictions and restarts, else they are only kept in memory.")
+	flag.IntVar(&config.MemoryStoreMaxEvents, "memory-store-max-events", 100000, "Maximum number of events of evicted rooms to keep in memory if there is no --store-path, disabled if 0.")
+	flag.BoolVar(&config.EnableSearchIndex, "enable-search-index", false, "Whether to search the events held in memory of rooms which the homeserver cannot search for us.")
+	flag.BoolVar(&config.FollowRoomUpgrades, "follow-room-upgrades", false, "Whether to continue the timeline of upgraded rooms into the room they were upgraded from.")
 
 	flag.DurationVar(&config.LastAccessDiscardDuration, "cache-ttl", 30*time.Minute, "")
 	flag.IntVar(&config.KeepAtLeastNRooms, "cache-min-rooms", 10, "")
+	flag.IntVar(&config.MaxCachedEvents, "cache-max-events", 0, "Maximum number of events to hold in memory across all rooms, unlimited if 0.")
+	flag.DurationVar(&config.PeekedRoomPollPeriod, "peek-poll-period", 2*time.Minute, "How often to forward paginate the loaded rooms which the account has not joined, so /sync does not cover.")
+	flag.DurationVar(&config.RequestTimeout, "request-timeout", 8*time.Second, "How long to wait on the homeserver before showing a timeout page.")
+	flag.BoolVar(&config.EnableHistoryExport, "enable-history-export", false, "Whether to serve the full history of rooms for download at /room/<room ID>/export.{jsonl,csv,txt}.")
+	flag.DurationVar(&config.HistoryExportTimeout, "history-export-timeout", 10*time.Minute, "How long a history export may take.")
+	flag.BoolVar(&config.EnableLiveStream, "enable-live-stream", false, "Whether to stream new events to the latest page of room timelines as they arrive.")
+	flag.DurationVar(&config.StreamTimeout, "stream-timeout", 5*time.Minute, "How long to keep each live stream open before the browser is made to reconnect.")
+	flag.IntVar(&config.MaxStreamSubscribers, "max-stream-subscribers", 100, "Maximum number of live streams open to each room at once, unlimited if 0.")
+	flag.IntVar(&config.MaxStreams, "max-streams", 1000, "Maximum number of live streams open to all rooms at once, unlimited if 0.")
 
 	flag.Parse()
 
@@ -110,8 +151,22 @@ func main() {
 		return
 	}
 
+	if config.StorePath != "" {
+		store, err := mxclient.NewBoltRoomStore(config.StorePath)
+		if err != nil {
+			log.WithError(err).Error("Unable to open Room Store")
+			return
+		}
+		defer store.Close()
+		client.Store = store
+	} else if config.MemoryStoreMaxEvents > 0 {
+		client.Store = mxclient.NewMemoryRoomStore(config.MemoryStoreMaxEvents)
+	}
+
 	worldReadableRooms := client.NewWorldReadableRooms()
 	pool := workers.NewWorkers(uint32(config.NumWorkers), client)
+	pool.MaxRoomSubscribers = config.MaxStreamSubscribers
+	pool.MaxSubscribers = config.MaxStreams
 	sanitizerFn := sanitizer.InitSanitizer()
 
 	router := gin.New()
@@ -127,30 +182,15 @@ func main() {
 	avatarRouter.Use(gin.Recovery())
 	generatedAvatarCache := persistence.NewInMemoryStore(time.Hour)
 	avatarRouter.GET("/avatar/:identifier", cache.CachePage(generatedAvatarCache, time.Hour, func(c *gin.Context) {
-		identifier := c.Param("identifier")
-		if (identifier[0] == '#' || identifier[0] == '!' || identifier[0] == '@') && len(identifier) > 1 {
-			identifier = identifier[1:]
-		}
-
-		avatarChar, _ := utf8.DecodeRuneInString(identifier)
-		img, err := letteravatar.Draw(100, unicode.ToUpper(avatarChar), nil)
-
-		if err != nil {
-			c.Error(err)
-			return
-		}
-
-		buffer := new(bytes.Buffer)
-		err = png.Encode(buffer, img)
-
+		avatar, err := utils.DrawAvatar(utils.AvatarLetter(c.Param("identifier")))
 		if err != nil {
 			c.Error(err)
 			return
 		}
 
 		c.Writer.Header().Set("Content-Type", "image/png")
-		c.Writer.Header().Set("Content-Length", strconv.Itoa(len(buffer.Bytes())))
-		_, err = c.Writer.Write(buffer.Bytes())
+		c.Writer.Header().Set("Content-Length", strconv.Itoa(len(avatar)))
+		_, err = c.Writer.Write(avatar)
 
 		if err != nil {
 			log.WithError(err).Error("Failed to write Image Buffer out.")
@@ -159,6 +199,13 @@ func main() {
 
 	publicRouter := router.Group(config.PublicServePrefix)
 	publicRouter.Use(gin.Logger(), gin.Recovery())
+	// Bound how long a request may wait on its worker and the homeserver, keeping us inside the server's WriteTimeout.
+	publicRouter.Use(func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), config.RequestTimeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	})
 
 	if config.EnablePrometheusMetrics {
 		ginProm := ginprometheus.NewPrometheus("http")
@@ -209,7 +256,12 @@ func main() {
 		if !strings.HasPrefix(roomAlias, "#") {
 			roomAlias = "#" + roomAlias
 		}
-		resp, err := client.GetRoomDirectoryAlias(roomAlias)
+		resp, err := client.GetRoomDirectoryAlias(c.Request.Context(), roomAlias)
+
+		if isContextError(err) {
+			writeContextErrorPage(c, err)
+			return
+		}
 
 		// TODO better error page
 		if err != nil || resp.RoomID == "" {
@@ -234,7 +286,7 @@ func main() {
 			c.Redirect(http.StatusTemporaryRedirect, fmt.Sprintf("/room/%s/?anchor=$%s&offset=-%d&highlight=$%s", roomID, eventID, permalinkOffset, eventID))
 		})
 
-		// Load room worker into request object so that we can do any clean up etc here
+		// Ensure the room is loaded into its worker before any of the room routes are handled
 		roomRouter.Use(func(c *gin.Context) {
 			roomID := c.Param("roomID")
 
@@ -247,15 +299,15 @@ func main() {
 				return
 			}
 
-			worker := pool.GetWorkerForRoomID(roomID)
-
-			worker.Queue <- &workers.RoomInitialSyncJob{RoomID: roomID}
-			resp := (<-worker.Output).(*workers.RoomInitialSyncResp)
+			if err := pool.LoadRoom(c.Request.Context(), roomID); err != nil {
+				if isContextError(err) {
+					writeContextErrorPage(c, err)
+					return
+				}
 
-			if resp.Err != nil {
 				defer c.Abort()
 
-				if respErr, ok := mxclient.UnwrapRespError(resp.Err); ok {
+				if respErr, ok := mxclient.UnwrapRespError(err); ok {
 					templates.WritePageTemplate(c.Writer, &templates.ErrorPage{
 						ErrType: "Unable to Join Room.",
 						Details: mxclient.TextForRespError(respErr),
@@ -263,7 +315,7 @@ func main() {
 					return
 				}
 
-				if err, ok := resp.Err.(gomatrix.HTTPError); ok {
+				if err, ok := err.(gomatrix.HTTPError); ok {
 					templates.WritePageTemplate(c.Writer, &templates.ErrorPage{
 						ErrType: "Cannot Load Room.",
 						Details: err.Message,
@@ -273,29 +325,42 @@ func main() {
 
 				templates.WritePageTemplate(c.Writer, &templates.ErrorPage{
 					ErrType: "Cannot Load Room. Internal Server Error.",
-					Error:   resp.Err,
+					Error:   err,
 				})
 
 				return
 			}
 
-			c.Set("RoomWorker", worker)
 			c.Next()
 		})
 
 		roomRouter.GET("/", func(c *gin.Context) {
-			worker := c.MustGet("RoomWorker").(workers.Worker)
 			offset := utils.StrToIntDefault(c.DefaultQuery("offset", "0"), 0)
 			eventID := c.Query("anchor")
 
-			worker.Queue <- workers.Job(workers.RoomEventsJob{
+			job := workers.RoomEventsJob{
 				RoomID:   c.Param("roomID"),
 				Anchor:   eventID,
 				Offset:   offset,
 				PageSize: RoomTimelineSize,
-			})
+			}
 
-			jobResult := (<-worker.Output).(workers.RoomEventsResp)
+			var jobResult workers.RoomEventsResp
+			var err error
+			if config.FollowRoomUpgrades {
+				jobResult, err = pool.GetRoomEventsAcrossUpgrades(c.Request.Context(), job)
+			} else {
+				jobResult, err = pool.GetRoomEvents(c.Request.Context(), job)
+			}
+			if err != nil {
+				writeContextErrorPage(c, err)
+				return
+			}
+
+			if isContextError(jobResult.Err) {
+				writeContextErrorPage(c, jobResult.Err)
+				return
+			}
 			if jobResult.Err != nil {
 				templates.WritePageTemplate(c.Writer, &templates.RoomErrorPage{
 					Error:    "Some error has occurred. " + jobResult.Err.Error(),
@@ -313,10 +378,33 @@ func main() {
 			events := mxclient.ReverseEventsCopy(jobResult.Events)
 			highlight := c.Query("highlight")
 
+			var predecessorPage *templates.RoomChatPage
+			if predecessor := jobResult.Predecessor; predecessor != nil && len(predecessor.Events) > 0 {
+				predecessorPage = &templates.RoomChatPage{
+					RoomInfo:  predecessor.RoomInfo,
+					MemberMap: predecessor.MemberMap,
+					Events:    mxclient.ReverseEventsCopy(predecessor.Events),
+					Reactions: predecessor.Reactions,
+					Threads:   predecessor.Threads,
+					InReplyTo: predecessor.InReplyTo,
+					PageSize:  len(predecessor.Events),
+					Anchor:    predecessor.Events[0].ID,
+
+					AtTopEnd:    predecessor.AtTopEnd,
+					AtBottomEnd: predecessor.AtBottomEnd,
+
+					Sanitizer:    sanitizerFn,
+					MediaBaseURL: client.MediaBaseURL,
+				}
+			}
+
 			templates.WritePageTemplate(c.Writer, &templates.RoomChatPage{
 				RoomInfo:      jobResult.RoomInfo,
 				MemberMap:     jobResult.MemberMap,
 				Events:        events,
+				Reactions:     jobResult.Reactions,
+				Threads:       jobResult.Threads,
+				InReplyTo:     jobResult.InReplyTo,
 				PageSize:      RoomTimelineSize,
 				CurrentOffset: offset,
 				Anchor:        eventID,
@@ -327,20 +415,89 @@ func main() {
 				Sanitizer:    sanitizerFn,
 				MediaBaseURL: client.MediaBaseURL,
 				Highlight:    highlight,
+
+				OutsideHistory: c.Query("notice") == outsideHistoryNotice,
+
+				Predecessor: predecessorPage,
+				LiveStream:  config.EnableLiveStream,
+				BaseURL:     publicBaseURL(c, config),
 			})
 		})
 
-		const RoomServersPageSize = 30
+		roomRouter.GET("/embed", func(c *gin.Context) {
+			// the count of events is bounded by that of the full timeline.
+			count := utils.StrToIntDefault(c.DefaultQuery("count", "0"), 0)
+			if count < 1 {
+				count = RoomEmbedSize
+			} else if count > RoomTimelineSize {
+				count = RoomTimelineSize
+			}
+
+			theme := templates.EmbedThemeLight
+			if c.Query("theme") == templates.EmbedThemeDark {
+				theme = templates.EmbedThemeDark
+			}
+
+			// an embedded event is shown amongst those around it.
+			eventID := c.Query("anchor")
+			job := workers.RoomEventsJob{
+				RoomID:   c.Param("roomID"),
+				Anchor:   eventID,
+				PageSize: count,
+			}
+			if eventID != "" {
+				job.Offset = -count / 2
+			}
+
+			jobResult, err := pool.GetRoomEvents(c.Request.Context(), job)
+			if err == nil {
+				err = jobResult.Err
+			}
+			if isContextError(err) {
+				writeContextErrorPage(c, err)
+				return
+			}
+			if err != nil {
+				templates.WritePageTemplate(c.Writer, &templates.RoomErrorPage{
+					Error:    "Some error has occurred. " + err.Error(),
+					RoomInfo: jobResult.RoomInfo,
+				})
+				return
+			}
+
+			templates.WriteRoomEmbed(c.Writer, &templates.RoomEmbedPage{
+				Timeline: &templates.RoomChatPage{
+					RoomInfo:  jobResult.RoomInfo,
+					MemberMap: jobResult.MemberMap,
+					Events:    mxclient.ReverseEventsCopy(jobResult.Events),
+					Reactions: jobResult.Reactions,
+					Threads:   jobResult.Threads,
+					InReplyTo: jobResult.InReplyTo,
+					PageSize:  count,
+
+					AtTopEnd:    jobResult.AtTopEnd,
+					AtBottomEnd: jobResult.AtBottomEnd,
+
+					Sanitizer:    sanitizerFn,
+					MediaBaseURL: client.MediaBaseURL,
+					Highlight:    eventID,
+					LiveStream:   config.EnableLiveStream,
+				},
+				Theme: theme,
+			})
+		})
 
 		roomRouter.GET("/servers", func(c *gin.Context) {
-			worker := c.MustGet("RoomWorker").(workers.Worker)
-			worker.Queue <- workers.RoomServersJob{
+			jobResp, ok := submitRoomJob(c, pool, workers.RoomServersJob{
 				RoomID:   c.Param("roomID"),
 				Page:     utils.StrToIntDefault(c.DefaultQuery("page", "1"), 1),
 				PageSize: RoomServersPageSize,
+			})
+			if !ok {
+				return
 			}
 
-			jobResult := templates.RoomServersPage((<-worker.Output).(workers.RoomServersResp))
+			jobResult := templates.RoomServersPage(jobResp.(workers.RoomServersResp))
 			templates.WritePageTemplate(c.Writer, &jobResult)
 
 			/*
@@ -352,60 +509,290 @@ func main() {
 			*/
 		})
 
-		const RoomAliasesPageSize = 10
-
 		roomRouter.GET("/aliases", func(c *gin.Context) {
-			worker := c.MustGet("RoomWorker").(workers.Worker)
-			worker.Queue <- workers.RoomAliasesJob{
+			jobResp, ok := submitRoomJob(c, pool, workers.RoomAliasesJob{
 				RoomID:   c.Param("roomID"),
 				Page:     utils.StrToIntDefault(c.DefaultQuery("page", "1"), 1),
 				PageSize: RoomAliasesPageSize,
+			})
+			if !ok {
+				return
 			}
 
-			jobResult := templates.RoomAliasesPage((<-worker.Output).(workers.RoomAliasesResp))
+			jobResult := templates.RoomAliasesPage(jobResp.(workers.RoomAliasesResp))
 			templates.WritePageTemplate(c.Writer, &jobResult)
 		})
 
 		roomRouter.GET("/members", func(c *gin.Context) {
-			worker := c.MustGet("RoomWorker").(workers.Worker)
-			worker.Queue <- workers.RoomMembersJob{
+			jobResp, ok := submitRoomJob(c, pool, workers.RoomMembersJob{
 				RoomID:   c.Param("roomID"),
 				Page:     utils.StrToIntDefault(c.DefaultQuery("page", "1"), 1),
 				PageSize: RoomMembersPageSize,
+			})
+			if !ok {
+				return
 			}
 
-			jobResult := templates.RoomMembersPage((<-worker.Output).(workers.RoomMembersResp))
+			jobResult := templates.RoomMembersPage(jobResp.(workers.RoomMembersResp))
 			templates.WritePageTemplate(c.Writer, &jobResult)
 		})
 
 		roomRouter.GET("/members/:mxid", func(c *gin.Context) {
-			worker := c.MustGet("RoomWorker").(workers.Worker)
-			worker.Queue <- workers.RoomMemberInfoJob{
+			jobResp, ok := submitRoomJob(c, pool, workers.RoomMemberInfoJob{
 				RoomID: c.Param("roomID"),
 				Mxid:   c.Param("mxid"),
+			})
+			if !ok {
+				return
 			}
 
 			//c.AbortWithStatus(http.StatusNotFound)
 
-			jobResult := templates.RoomMemberInfoPage((<-worker.Output).(workers.RoomMemberInfoResp))
+			jobResult := templates.RoomMemberInfoPage(jobResp.(workers.RoomMemberInfoResp))
 			templates.WritePageTemplate(c.Writer, &jobResult)
 		})
 
+		roomRouter.GET("/edits/:eventID", func(c *gin.Context) {
+			jobResp, ok := submitRoomJob(c, pool, workers.RoomEditsJob{
+				RoomID:  c.Param("roomID"),
+				EventID: c.Param("eventID"),
+			})
+			if !ok {
+				return
+			}
+
+			jobResult := jobResp.(workers.RoomEditsResp)
+			templates.WritePageTemplate(c.Writer, &templates.RoomEditsPage{
+				RoomInfo:  jobResult.RoomInfo,
+				MemberMap: jobResult.MemberMap,
+				Original:  jobResult.Original,
+				Edits:     jobResult.Edits,
+				Err:       jobResult.Err,
+
+				Sanitizer:    sanitizerFn,
+				MediaBaseURL: client.MediaBaseURL,
+			})
+		})
+
+		roomRouter.GET("/thread/:eventID", func(c *gin.Context) {
+			from := c.Query("from")
+			jobResult, err := pool.GetRoomThread(c.Request.Context(), c.Param("roomID"), c.Param("eventID"), from, RoomTimelineSize)
+			if err != nil {
+				if isContextError(err) {
+					writeContextErrorPage(c, err)
+					return
+				}
+
+				if respErr, ok := mxclient.UnwrapRespError(err); ok {
+					templates.WritePageTemplate(c.Writer, &templates.ErrorPage{
+						ErrType: "Unable to Load Thread.",
+						Details: mxclient.TextForRespError(respErr),
+					})
+					return
+				}
+
+				templates.WritePageTemplate(c.Writer, &templates.ErrorPage{
+					ErrType: "Cannot Load Thread.",
+					Error:   err,
+				})
+				return
+			}
+
+			templates.WritePageTemplate(c.Writer, &templates.RoomThreadPage{
+				RoomInfo:  jobResult.RoomInfo,
+				MemberMap: jobResult.MemberMap,
+				Root:      jobResult.Root,
+				Replies:   mxclient.ReverseEventsCopy(jobResult.Replies),
+				Reactions: jobResult.Reactions,
+				InReplyTo: jobResult.InReplyTo,
+				From:      from,
+				NextBatch: jobResult.NextBatch,
+
+				Sanitizer:    sanitizerFn,
+				MediaBaseURL: client.MediaBaseURL,
+			})
+		})
+
+		roomRouter.GET("/search", func(c *gin.Context) {
+			page := &templates.RoomSearchPage{
+				Query: c.Query("q"),
+				From:  c.Query("from"),
+
+				Sanitizer:    sanitizerFn,
+				MediaBaseURL: client.MediaBaseURL,
+			}
+
+			jobResult, err := pool.SearchRoom(c.Request.Context(), workers.RoomSearchJob{
+				RoomID:     c.Param("roomID"),
+				Query:      page.Query,
+				From:       page.From,
+				PageSize:   RoomSearchPageSize,
+				AllowLocal: config.EnableSearchIndex,
+			})
+			if err != nil {
+				writeContextErrorPage(c, err)
+				return
+			}
+
+			page.RoomInfo = jobResult.RoomInfo
+			page.MemberMap = jobResult.MemberMap
+			page.Results = jobResult.Results
+			page.Err = jobResult.Err
+			templates.WritePageTemplate(c.Writer, page)
+		})
+
+		// roomDate redirects to the first event sent on day, or after it if there were none that day.
+		roomDate := func(c *gin.Context, day string) {
+			date, err := time.ParseInLocation(mxclient.DayFormat, day, time.Local)
+			if err != nil {
+				c.Status(http.StatusBadRequest)
+				templates.WritePageTemplate(c.Writer, &templates.ErrorPage{
+					ErrType: "Invalid Date.",
+					Details: "Dates must be given as YYYY-MM-DD.",
+				})
+				return
+			}
+
+			roomID := c.Param("roomID")
+			jobResult, err := pool.FindRoomEventByDate(c.Request.Context(), roomID, mxclient.Timestamp(date))
+			if isContextError(err) {
+				writeContextErrorPage(c, err)
+				return
+			}
+			if err != nil {
+				templates.WritePageTemplate(c.Writer, &templates.ErrorPage{
+					ErrType: "Cannot Find Date.",
+					Error:   err,
+				})
+				return
+			}
+
+			// nothing has been sent since, so the latest messages are the closest there are.
+			eventID := jobResult.EventID
+			if eventID == "" {
+				c.Redirect(http.StatusTemporaryRedirect, "/room/"+roomID+"/")
+				return
+			}
+			// show the day from the top of the page rather than the page leading up to it.
+			location := fmt.Sprintf("/room/%s/?anchor=%s&offset=-%d&highlight=%s", roomID, eventID, RoomTimelineSize-1, eventID)
+			// the oldest event loaded is only the closest we have to the day, so say so rather than pass it off as it.
+			if jobResult.OutsideHistory {
+				location += "&notice=" + outsideHistoryNotice
+			}
+			c.Redirect(http.StatusTemporaryRedirect, location)
+		}
+
+		roomRouter.GET("/date", func(c *gin.Context) {
+			roomDate(c, c.Query("day"))
+		})
+
+		roomRouter.GET("/date/:day", func(c *gin.Context) {
+			roomDate(c, c.Param("day"))
+		})
+
+		roomRouter.GET("/calendar", func(c *gin.Context) {
+			job := workers.RoomCalendarJob{RoomID: c.Param("roomID")}
+			if since, err := time.ParseInLocation(mxclient.MonthFormat, c.Query("since"), time.Local); err == nil {
+				job.Since = mxclient.Timestamp(since)
+			}
+
+			jobResult, err := pool.GetRoomCalendar(c.Request.Context(), job)
+			if isContextError(err) {
+				writeContextErrorPage(c, err)
+				return
+			}
+			if err != nil {
+				templates.WritePageTemplate(c.Writer, &templates.ErrorPage{
+					ErrType: "Cannot Load Calendar.",
+					Error:   err,
+				})
+				return
+			}
+
+			templates.WritePageTemplate(c.Writer, &templates.RoomCalendarPage{
+				RoomInfo:       jobResult.RoomInfo,
+				Days:           jobResult.Days,
+				AtHistoryStart: jobResult.AtHistoryStart,
+			})
+		})
+
+		// roomFeed loads the latest page of the room for its feeds, writing an error page if it cannot.
+		roomFeed := func(c *gin.Context) (*templates.RoomFeed, bool) {
+			jobResult, err := pool.GetRoomEvents(c.Request.Context(), workers.RoomEventsJob{
+				RoomID:   c.Param("roomID"),
+				PageSize: RoomTimelineSize,
+			})
+			if err == nil {
+				err = jobResult.Err
+			}
+			if isContextError(err) {
+				writeContextErrorPage(c, err)
+				return nil, false
+			}
+			if err != nil {
+				templates.WritePageTemplate(c.Writer, &templates.RoomErrorPage{
+					Error:    "Some error has occurred. " + err.Error(),
+					RoomInfo: jobResult.RoomInfo,
+				})
+				return nil, false
+			}
+
+			return &templates.RoomFeed{
+				RoomInfo:  jobResult.RoomInfo,
+				MemberMap: jobResult.MemberMap,
+				Events:    jobResult.Events,
+				BaseURL:   publicBaseURL(c, config),
+
+				Sanitizer:    sanitizerFn,
+				MediaBaseURL: client.MediaBaseURL,
+			}, true
+		}
+
+		roomRouter.GET("/feed.atom", func(c *gin.Context) {
+			if feed, ok := roomFeed(c); ok {
+				c.Writer.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+				feed.WriteAtom(c.Writer)
+			}
+		})
+
+		roomRouter.GET("/feed.rss", func(c *gin.Context) {
+			if feed, ok := roomFeed(c); ok {
+				c.Writer.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+				feed.WriteRSS(c.Writer)
+			}
+		})
+
 		roomRouter.GET("/power_levels", func(c *gin.Context) {
-			worker := c.MustGet("RoomWorker").(workers.Worker)
-			worker.Queue <- workers.RoomPowerLevelsJob{RoomID: c.Param("roomID")}
+			jobResp, ok := submitRoomJob(c, pool, workers.RoomPowerLevelsJob{RoomID: c.Param("roomID")})
+			if !ok {
+				return
+			}
 
-			jobResult := templates.RoomPowerLevelsPage((<-worker.Output).(workers.RoomPowerLevelsResp))
+			jobResult := templates.RoomPowerLevelsPage(jobResp.(workers.RoomPowerLevelsResp))
 			templates.WritePageTemplate(c.Writer, &jobResult)
 		})
 	}
 
+	registerAPIRoutes(publicRouter, config, client, pool, worldReadableRooms)
+	registerOEmbedRoutes(publicRouter, config, pool)
+	if config.EnableHistoryExport {
+		registerHistoryRoutes(router, config, client)
+	}
+	if config.EnableLiveStream {
+		registerStreamRoutes(router, config, pool, sanitizerFn, client.MediaBaseURL)
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8000"
 	}
 
-	go startForwardPaginator(config, pool)
+	go startRoomEvictor(config, pool)
+	if config.MaxCachedEvents > 0 {
+		pool.StartEventBudget(context.Background(), config.MaxCachedEvents, config.KeepAtLeastNRooms)
+	}
+	go startRoomSyncer(client, pool)
+	go startPeekedRoomPoller(config, pool)
 	go startPublicRoomListTimer(worldReadableRooms)
 	log.Info("Listening on port " + port)
 
@@ -415,9 +802,111 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 		Handler:      router,
 		Addr:         ":" + port,
+		ConnContext:  connContext,
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// on being asked to stop, finish the requests in flight then persist the rooms so that a restart can pick them up.
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	log.Info("Shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.WithError(err).Error("Failed to finish serving requests")
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(int(pool.NumWorkers))
+	pool.JobForAllWorkers(workers.RoomPersistJob{Wg: &wg})
+	wg.Wait()
+}
+
+// ShutdownTimeout is how long requests in flight are given to finish on shutdown.
+const ShutdownTimeout = 10 * time.Second
+
+// publicBaseURL returns the absolute URL the public routes are served at, without a trailing slash.
+func publicBaseURL(c *gin.Context, config configVars) string {
+	if config.PublicURL != "" {
+		return strings.TrimSuffix(config.PublicURL, "/")
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	host := c.Request.Host
+	// only a reverse proxy we are known to be behind may tell us how we were reached, else anyone could forge links.
+	if config.TrustProxyHeaders {
+		if proto := c.Request.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwardedHost := c.Request.Header.Get("X-Forwarded-Host"); forwardedHost != "" {
+			host = forwardedHost
+		}
+	}
+	return scheme + "://" + host + strings.TrimSuffix(config.PublicServePrefix, "/")
+}
+
+// connKey holds the net.Conn of each request in its context, as the ResponseWriter of the server cannot be unwrapped
+// to reach it.
+type connKey struct{}
+
+// connContext makes the net.Conn of each connection available to extendWriteDeadline.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// extendWriteDeadline gives the response to c until d from now to be written, in place of the server's WriteTimeout
+// which is kept short for every other route. The server sets the WriteTimeout on reading the request, before the
+// handler runs, so the deadline set here is the one which holds for the rest of the response.
+func extendWriteDeadline(c *gin.Context, d time.Duration) {
+	conn, ok := c.Request.Context().Value(connKey{}).(net.Conn)
+	if !ok {
+		return
+	}
+	if err := conn.SetWriteDeadline(time.Now().Add(d)); err != nil {
+		log.WithError(err).Warn("Failed to extend write deadline")
+	}
+}
+
+// isContextError returns whether err signals that the request's context was cancelled or exceeded its deadline.
+func isContextError(err error) bool {
+	return err == context.Canceled || err == context.DeadlineExceeded
+}
+
+// writeContextErrorPage renders a timeout page if the request's deadline was hit, then aborts the request.
+func writeContextErrorPage(c *gin.Context, err error) {
+	defer c.Abort()
+
+	// if the client went away there is nobody left to render a page for.
+	if err == context.Canceled {
+		return
 	}
 
-	log.Fatal(srv.ListenAndServe())
+	c.Status(http.StatusGatewayTimeout)
+	templates.WritePageTemplate(c.Writer, &templates.ErrorPage{
+		ErrType: "Request Timed Out.",
+		Details: "The Homeserver took too long to respond, please try again later.",
+	})
+}
+
+// submitRoomJob submits the job to the worker of the room in the path, bound to the request's context.
+// If the context is done before the job completes it writes the appropriate page and returns ok=false.
+func submitRoomJob(c *gin.Context, pool *workers.Workers, job workers.Job) (resp workers.JobResp, ok bool) {
+	resp, err := pool.Submit(c.Request.Context(), c.Param("roomID"), job)
+	if err != nil {
+		writeContextErrorPage(c, err)
+		return nil, false
+	}
+	return resp, true
 }
 
 const LoadPublicRoomsPeriod = time.Hour
@@ -431,21 +920,47 @@ func startPublicRoomListTimer(worldReadableRooms *mxclient.WorldReadableRooms) {
 	}
 }
 
-const LazyForwardPaginateRooms = 2 * time.Minute
+const RoomEvictionPeriod = 2 * time.Minute
 
-func startForwardPaginator(config configVars, pool *workers.Workers) {
-	//t := time.NewTicker(LazyForwardPaginateRooms)
+func startRoomEvictor(config configVars, pool *workers.Workers) {
 	wg := sync.WaitGroup{}
 	for {
-		//<-t.C
-		time.Sleep(LazyForwardPaginateRooms)
+		time.Sleep(RoomEvictionPeriod)
 		wg.Add(int(pool.NumWorkers))
-		log.Info("Forward paginating all loaded rooms")
-		pool.JobForAllWorkers(workers.RoomForwardPaginateJob{
+		log.Info("Evicting stale rooms")
+		pool.JobForAllWorkers(workers.RoomEvictJob{
 			Wg:      &wg,
 			TTL:     config.LastAccessDiscardDuration,
 			KeepMin: config.KeepAtLeastNRooms,
 		})
 		wg.Wait()
+
+		if err := pool.EnforceEventBudget(context.Background(), config.MaxCachedEvents, config.KeepAtLeastNRooms); err != nil {
+			log.WithError(err).Error("Failed to enforce event budget")
+		}
+	}
+}
+
+// startRoomSyncer keeps all loaded rooms up to date by dispatching new events from /sync to the worker owning each room.
+func startRoomSyncer(client *mxclient.Client, pool *workers.Workers) {
+	ctx := context.Background()
+	client.SyncLoop(ctx, pool.LoadedRoomIDs, func(roomID string, room mxclient.SyncJoinedRoom, nextBatch string) {
+		// wait for each room to be updated so that the next /sync is never applied ahead of this one.
+		if err := pool.ApplySync(ctx, roomID, room, nextBatch); err != nil {
+			log.WithError(err).WithField("room_id", roomID).Error("Failed to apply Sync to Room")
+		}
+	})
+}
+
+// startPeekedRoomPoller keeps the loaded rooms which the account has not joined, so are not included in /sync, up to
+// date by forward paginating them every PeekedRoomPollPeriod.
+func startPeekedRoomPoller(config configVars, pool *workers.Workers) {
+	for {
+		time.Sleep(config.PeekedRoomPollPeriod)
+		ctx, cancel := context.WithTimeout(context.Background(), config.PeekedRoomPollPeriod)
+		if err := pool.ForwardpaginatePeekedRooms(ctx); err != nil {
+			log.WithError(err).Error("Failed to forward paginate peeked Rooms")
+		}
+		cancel()
 	}
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPublicBaseURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		config configVars
+		want   string
+	}{
+		{"public URL", configVars{PublicURL: "https://view.example.org/", TrustProxyHeaders: true}, "https://view.example.org"},
+		{"untrusted proxy", configVars{PublicServePrefix: "/"}, "http://example.org"},
+		{"trusted proxy", configVars{PublicServePrefix: "/view/", TrustProxyHeaders: true}, "https://proxy.example.org/view"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &gin.Context{Request: httptest.NewRequest("GET", "http://example.org/room/!room/feed.atom", nil)}
+			c.Request.Header.Set("X-Forwarded-Proto", "https")
+			c.Request.Header.Set("X-Forwarded-Host", "proxy.example.org")
+			if got := publicBaseURL(c, tt.config); got != tt.want {
+				t.Errorf("publicBaseURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtendWriteDeadline(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/slow", func(c *gin.Context) {
+		extendWriteDeadline(c, time.Second)
+		time.Sleep(200 * time.Millisecond)
+		c.String(http.StatusOK, "done")
+	})
+	router.GET("/fast", func(c *gin.Context) {
+		time.Sleep(200 * time.Millisecond)
+		c.String(http.StatusOK, "done")
+	})
+
+	srv := httptest.NewUnstartedServer(router)
+	srv.Config.ConnContext = connContext
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	for path, expOk := range map[string]bool{"/slow": true, "/fast": false} {
+		resp, err := http.Get(srv.URL + path)
+		var body []byte
+		if err == nil {
+			body, err = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		if ok := err == nil && string(body) == "done"; ok != expOk {
+			t.Errorf("%s: got %q, %v, want written = %t", path, body, err, expOk)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/matrix-org/matrix-static/api"
+	"github.com/matrix-org/matrix-static/oembed"
+	"github.com/matrix-org/matrix-static/utils"
+	"github.com/matrix-org/matrix-static/workers"
+	"net/http"
+)
+
+// registerOEmbedRoutes serves oEmbed responses for links to rooms and their events, so that sites and chat clients
+// which support oEmbed can embed the compact view of the room served at /room/:roomID/embed.
+func registerOEmbedRoutes(publicRouter *gin.RouterGroup, config configVars, pool *workers.Workers) {
+	publicRouter.GET("/oembed", func(c *gin.Context) {
+		if c.DefaultQuery("format", "json") != "json" {
+			abortWithAPIError(c, http.StatusNotImplemented, api.ErrCodeUnrecognized, "Only the json format is supported")
+			return
+		}
+
+		baseURL := publicBaseURL(c, config)
+		target, ok := oembed.ParseURL(c.Query("url"), baseURL)
+		if !ok {
+			abortWithAPIError(c, http.StatusNotFound, api.ErrCodeNotFound, "Not a link to a room on this server")
+			return
+		}
+
+		err := pool.LoadRoom(c.Request.Context(), target.RoomID)
+		var jobResult workers.RoomInfoResp
+		if err == nil {
+			var jobResp workers.JobResp
+			if jobResp, err = pool.Submit(c.Request.Context(), target.RoomID, workers.RoomInfoJob{RoomID: target.RoomID}); err == nil {
+				jobResult = jobResp.(workers.RoomInfoResp)
+				err = jobResult.Err
+			}
+		}
+		if err != nil {
+			writeAPIError(c, err)
+			return
+		}
+
+		info := jobResult.RoomInfo
+		title := info.Name
+		if title == "" {
+			title = info.CanonicalAlias
+		}
+		if title == "" {
+			title = info.RoomID
+		}
+		maxWidth := utils.StrToIntDefault(c.Query("maxwidth"), 0)
+		maxHeight := utils.StrToIntDefault(c.Query("maxheight"), 0)
+		c.JSON(http.StatusOK, oembed.NewResponse(target, baseURL, title, maxWidth, maxHeight))
+	})
+}
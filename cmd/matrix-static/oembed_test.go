@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/matrix-org/matrix-static/oembed"
+	"github.com/matrix-org/matrix-static/workers"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestOEmbed(t *testing.T) {
+	cli := newTestClient(testRoomResps)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	registerOEmbedRoutes(router.Group("/"), configVars{}, workers.NewWorkers(1, cli))
+
+	tests := []struct {
+		name   string
+		url    string
+		status int
+		title  string
+	}{
+		{"should title the room by its name", "http://example.com/room/!room/", http.StatusOK, "Test Room"},
+		{"should embed events of the room", "http://example.com/room/!room/$2", http.StatusOK, "Test Room"},
+		{"should not embed other sites", "http://example.org/room/!room/", http.StatusNotFound, ""},
+		{"should not embed unknown rooms", "http://example.com/room/!unknown/", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest("GET", "/oembed?url="+url.QueryEscape(tt.url), nil))
+			if w.Code != tt.status {
+				t.Fatal("Status mismatch expectation", w.Code, w.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+
+			var resp oembed.Response
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Title != tt.title || resp.Type != "rich" {
+				t.Error("Response mismatch expectation", err, resp)
+			}
+		})
+	}
+}
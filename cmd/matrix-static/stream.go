@@ -0,0 +1,164 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/matrix-org/matrix-static/api"
+	"github.com/matrix-org/matrix-static/mxclient"
+	"github.com/matrix-org/matrix-static/sanitizer"
+	"github.com/matrix-org/matrix-static/templates"
+	"github.com/matrix-org/matrix-static/workers"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// streamKeepAliveInterval is how often a comment is sent down an idle stream, so that proxies do not time it out.
+const streamKeepAliveInterval = 30 * time.Second
+
+// registerStreamRoutes serves the new events of rooms as Server-Sent Events, for the timeline to append as they arrive.
+// These are registered apart from the public routes as a stream stays open far longer than --request-timeout allows,
+// each is closed after --stream-timeout instead and browsers reconnect from the last event they received.
+func registerStreamRoutes(router *gin.Engine, config configVars, pool *workers.Workers, sanitizerFn *sanitizer.Sanitizer, mediaBaseURL string) {
+	streamRouter := router.Group(config.PublicServePrefix)
+	streamRouter.Use(gin.Logger(), gin.Recovery())
+
+	streamRouter.GET("/room/:roomID/stream", func(c *gin.Context) {
+		roomID := c.Param("roomID")
+		if !strings.HasPrefix(roomID, "!") {
+			c.String(http.StatusBadRequest, "Room ID must start with a '!'")
+			return
+		}
+
+		// browsers send the last event they received when reconnecting, which supersedes the one the page started at.
+		since := c.Request.Header.Get("Last-Event-ID")
+		if since == "" {
+			since = c.Query("since")
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), config.RequestTimeout)
+		var resp workers.RoomSubscribeResp
+		err := pool.LoadRoom(ctx, roomID)
+		if err == nil {
+			if resp, err = pool.SubscribeRoom(ctx, roomID, since); err == nil {
+				err = resp.Err
+			}
+		}
+		cancel()
+
+		if isContextError(err) {
+			writeContextErrorPage(c, err)
+			return
+		}
+		if err == workers.ErrTooManySubscribers {
+			c.String(http.StatusServiceUnavailable, "Too many people are following this room live, please reload later.")
+			return
+		}
+		if err == workers.ErrTooManySubscriptions {
+			c.String(http.StatusServiceUnavailable, "Too many people are following rooms live, please reload later.")
+			return
+		}
+		if err != nil {
+			c.String(http.StatusBadGateway, "Cannot Load Room.")
+			return
+		}
+
+		// leave time for the last update to be written once the stream times out.
+		extendWriteDeadline(c, config.StreamTimeout+10*time.Second)
+
+		header := c.Writer.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		// stop reverse proxies such as nginx from buffering the stream.
+		header.Set("X-Accel-Buffering", "no")
+		c.Writer.WriteHeaderNow()
+
+		if resp.TooFarBehind {
+			io.WriteString(c.Writer, "event: reload\ndata: {}\n\n")
+			return
+		}
+		sub := resp.Subscription
+		defer sub.Close()
+
+		if len(resp.CatchUp.Events) > 0 {
+			if err := writeStreamUpdate(c.Writer, resp.CatchUp, sanitizerFn, mediaBaseURL); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+
+		timeout := time.NewTimer(config.StreamTimeout)
+		defer timeout.Stop()
+		keepAlive := time.NewTicker(streamKeepAliveInterval)
+		defer keepAlive.Stop()
+
+		for {
+			var err error
+			select {
+			case update, ok := <-sub.Updates:
+				// we fell behind or the room was discarded, the browser will reconnect and catch up from the last event it
+				// received.
+				if !ok {
+					return
+				}
+				err = writeStreamUpdate(c.Writer, update, sanitizerFn, mediaBaseURL)
+			case <-keepAlive.C:
+				_, err = io.WriteString(c.Writer, ": keep-alive\n\n")
+			case <-timeout.C:
+				return
+			case <-c.Request.Context().Done():
+				return
+			}
+
+			if err != nil {
+				return
+			}
+			c.Writer.Flush()
+		}
+	})
+}
+
+// writeStreamUpdate writes update out as an "events" message of a Server-Sent Events stream, see api.StreamUpdate.
+func writeStreamUpdate(w io.Writer, update workers.RoomUpdate, sanitizerFn *sanitizer.Sanitizer, mediaBaseURL string) error {
+	page := &templates.RoomChatPage{
+		RoomInfo:  update.RoomInfo,
+		MemberMap: update.MemberMap,
+		Events:    mxclient.ReverseEventsCopy(update.Events),
+		Reactions: update.Reactions,
+		Threads:   update.Threads,
+		InReplyTo: update.InReplyTo,
+
+		Sanitizer:    sanitizerFn,
+		MediaBaseURL: mediaBaseURL,
+	}
+
+	data, err := json.Marshal(api.StreamUpdate{
+		Events:  update.Events,
+		Members: api.NewMembers(update.Events, update.MemberMap),
+		HTML:    page.EventRows(update.Previous),
+	})
+	if err != nil {
+		return err
+	}
+
+	// the ID lets browsers resume from the latest event they received should they need to reconnect.
+	_, err = fmt.Fprintf(w, "id: %s\nevent: events\ndata: %s\n\n", update.Events[0].ID, data)
+	return err
+}
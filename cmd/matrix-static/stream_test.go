@@ -0,0 +1,42 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/matrix-org/matrix-static/workers"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStream_OutlivesWriteTimeout(t *testing.T) {
+	cli := newTestClient(testRoomResps)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	config := configVars{PublicServePrefix: "/", RequestTimeout: time.Second, StreamTimeout: 200 * time.Millisecond}
+	registerStreamRoutes(router, config, workers.NewWorkers(1, cli), nil, "")
+
+	// as in main, but with a WriteTimeout the stream outlives.
+	srv := httptest.NewUnstartedServer(router)
+	srv.Config.ConnContext = connContext
+	srv.Config.WriteTimeout = 50 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/room/!room/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || !strings.HasPrefix(ct, "text/event-stream") {
+		t.Fatalf("got %d %q, want 200 text/event-stream", resp.StatusCode, ct)
+	}
+
+	// the stream is only ended cleanly once it times out if its write deadline was extended past the WriteTimeout.
+	start := time.Now()
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		t.Fatalf("stream cut off after %s: %v", time.Since(start), err)
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/matrix-org/matrix-static
 
-go 1.12
+go 1.13
 
 require (
 	github.com/Sirupsen/logrus v0.0.0-20170821073101-84573d5f03ab
@@ -60,7 +60,7 @@ require (
 	github.com/onsi/gomega v1.8.1 // indirect
 	github.com/peterbourgon/diskv v2.0.1+incompatible // indirect
 	github.com/pkg/sftp v1.11.0 // indirect
-	github.com/prometheus/client_golang v0.0.0-20170724081313-94ff84a9a6eb // indirect
+	github.com/prometheus/client_golang v0.0.0-20170724081313-94ff84a9a6eb
 	github.com/prometheus/common v0.0.0-20170707053319-3e6a7635bac6 // indirect
 	github.com/prometheus/procfs v0.0.0-20170703101242-e645f4e5aaa8 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20190728182440-6a916e37a237 // indirect
@@ -71,6 +71,7 @@ require (
 	github.com/syndtr/goleveldb v1.0.0 // indirect
 	github.com/t3chguy/go-gin-prometheus v0.0.0-20170724180305-438209f97511
 	github.com/valyala/quicktemplate v1.4.1
+	go.etcd.io/bbolt v1.3.6
 	go.opencensus.io v0.22.3 // indirect
 	golang.org/x/build v0.0.0-20200213172154-de8b20fb6686 // indirect
 	golang.org/x/mobile v0.0.0-20200212152714-2b26a4705d24 // indirect
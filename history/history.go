@@ -0,0 +1,225 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package history writes out the timeline of a room, as streamed newest first by mxclient.Client.StreamHistory, in
+// formats meant for other tools: JSON lines of the raw events, CSV of the messages and a plain text IRC style log.
+package history
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/mxclient"
+	"io"
+	"strings"
+	"time"
+)
+
+// Formats maps the name of each supported format, which is also its file extension, to its content type.
+var Formats = map[string]string{
+	"jsonl": "application/x-ndjson; charset=utf-8",
+	"csv":   "text/csv; charset=utf-8",
+	"txt":   "text/plain; charset=utf-8",
+}
+
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// Writer writes out the events of a room one at a time, newest first, as they are streamed.
+type Writer interface {
+	// WriteEvent writes out ev, sent by a member going by displayName at the time.
+	WriteEvent(ev *gomatrix.Event, displayName string) error
+	// Flush writes out anything buffered, to be called once all events are written or periodically in between.
+	Flush() error
+}
+
+// NewWriter returns a Writer of the given format, one of Formats, onto w.
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case "jsonl":
+		buf := bufio.NewWriter(w)
+		return &jsonWriter{buf, json.NewEncoder(buf)}, nil
+	case "csv":
+		return &csvWriter{w: csv.NewWriter(w), edits: make(edits)}, nil
+	case "txt":
+		return &textWriter{w: bufio.NewWriter(w), edits: make(edits)}, nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+type jsonWriter struct {
+	buf *bufio.Writer
+	enc *json.Encoder
+}
+
+func (w *jsonWriter) WriteEvent(ev *gomatrix.Event, _ string) error {
+	return w.enc.Encode(ev)
+}
+
+func (w *jsonWriter) Flush() error {
+	return w.buf.Flush()
+}
+
+// csvWriter writes a row for each message, with a header row first.
+type csvWriter struct {
+	w           *csv.Writer
+	edits       edits
+	wroteHeader bool
+}
+
+func (w *csvWriter) WriteEvent(ev *gomatrix.Event, displayName string) error {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if err := w.w.Write([]string{"timestamp", "sender", "display_name", "body"}); err != nil {
+			return err
+		}
+	}
+
+	body, ok := w.edits.body(ev)
+	if !ok {
+		return nil
+	}
+	return w.w.Write([]string{eventTime(ev).Format(time.RFC3339), ev.Sender, displayName, body})
+}
+
+func (w *csvWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+// textWriter writes messages and membership changes out as an IRC client would log them, with times in UTC.
+type textWriter struct {
+	w     *bufio.Writer
+	edits edits
+}
+
+func (w *textWriter) WriteEvent(ev *gomatrix.Event, displayName string) error {
+	var lines []string
+	if body, ok := w.edits.body(ev); ok {
+		format := "<%s> %s"
+		if msgtype, _ := ev.Content["msgtype"].(string); msgtype == "m.emote" {
+			format = "* %s %s"
+		}
+		for _, line := range strings.Split(body, "\n") {
+			lines = append(lines, fmt.Sprintf(format, displayName, line))
+		}
+	} else if text := stateText(ev, displayName); text != "" {
+		lines = append(lines, "-!- "+text)
+	}
+
+	prefix := eventTime(ev).Format("[2006-01-02 15:04:05] ")
+	for _, line := range lines {
+		if _, err := w.w.WriteString(prefix + line + "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *textWriter) Flush() error {
+	return w.w.Flush()
+}
+
+// stateText describes the changes of membership, name and topic shown in a log, or returns "" for other events.
+func stateText(ev *gomatrix.Event, displayName string) string {
+	if ev.StateKey == nil {
+		return ""
+	}
+
+	switch ev.Type {
+	case "m.room.member":
+		target, _ := ev.Content["displayname"].(string)
+		if target == "" {
+			target = *ev.StateKey
+		}
+		prevContent := mxclient.PrevContent(ev)
+		prevMembership, _ := prevContent["membership"].(string)
+
+		switch membership, _ := ev.Content["membership"].(string); membership {
+		case "join":
+			if prevMembership != "join" {
+				return target + " has joined"
+			}
+			if prevName, _ := prevContent["displayname"].(string); prevName != "" && prevName != target {
+				return prevName + " is now known as " + target
+			}
+		case "leave":
+			if ev.Sender == *ev.StateKey {
+				return displayName + " has left"
+			}
+			if prevMembership == "ban" {
+				return target + " was unbanned by " + displayName
+			}
+			return target + " was kicked by " + displayName
+		case "ban":
+			return target + " was banned by " + displayName
+		case "invite":
+			return displayName + " invited " + target
+		}
+	case "m.room.name":
+		if name, ok := ev.Content["name"].(string); ok {
+			return displayName + " changed the room name to: " + name
+		}
+	case "m.room.topic":
+		if topic, ok := ev.Content["topic"].(string); ok {
+			return displayName + " changed the topic to: " + topic
+		}
+	}
+	return ""
+}
+
+// edits holds the body of the latest edit by each sender of each message not yet written, by the ID of the message
+// then the sender. Only the sender of a message may edit it, so the edits of others are kept apart rather than let
+// them hide those of the sender. Streaming newest first, a message's edits are all seen before it, so only edits of
+// messages which are never reached are left over.
+type edits map[string]map[string]string
+
+// body returns the body ev is to be logged with, that of its latest edit if it has any, or false if ev is not a message.
+// Edits are recorded rather than logged themselves.
+func (e edits) body(ev *gomatrix.Event) (string, bool) {
+	if ev.StateKey != nil || ev.Type != "m.room.message" {
+		return "", false
+	}
+
+	if targetID := mxclient.EditedEventID(*ev); targetID != "" {
+		newContent, _ := ev.Content["m.new_content"].(map[string]interface{})
+		body, ok := newContent["body"].(string)
+		if !ok {
+			return "", false
+		}
+		if e[targetID] == nil {
+			e[targetID] = make(map[string]string)
+		}
+		if _, seen := e[targetID][ev.Sender]; !seen {
+			e[targetID][ev.Sender] = body
+		}
+		return "", false
+	}
+
+	body, ok := ev.Content["body"].(string)
+	if !ok {
+		return "", false
+	}
+	if editedBody, edited := e[ev.ID][ev.Sender]; edited {
+		body = editedBody
+	}
+	delete(e, ev.ID)
+	return body, true
+}
+
+func eventTime(ev *gomatrix.Event) time.Time {
+	return time.Unix(0, ev.Timestamp*int64(time.Millisecond)).UTC()
+}
@@ -0,0 +1,86 @@
+package history
+
+import (
+	"bytes"
+	"github.com/matrix-org/gomatrix"
+	"testing"
+)
+
+func TestNewWriter(t *testing.T) {
+	alice := "@alice:example.org"
+	// newest first, as streamed.
+	events := []struct {
+		ev          gomatrix.Event
+		displayName string
+	}{
+		// only the sender of a message may edit it, however recently others tried to.
+		{gomatrix.Event{ID: "$5", Type: "m.room.message", Sender: "@mallory:example.org", Timestamp: 5000, Content: map[string]interface{}{
+			"msgtype":       "m.text",
+			"body":          "* forged",
+			"m.new_content": map[string]interface{}{"msgtype": "m.text", "body": "forged"},
+			"m.relates_to":  map[string]interface{}{"rel_type": "m.replace", "event_id": "$3"},
+		}}, "Mallory"},
+		{gomatrix.Event{ID: "$4", Type: "m.room.message", Sender: alice, Timestamp: 4000, Content: map[string]interface{}{
+			"msgtype":       "m.text",
+			"body":          "* hello, world",
+			"m.new_content": map[string]interface{}{"msgtype": "m.text", "body": "hello, world"},
+			"m.relates_to":  map[string]interface{}{"rel_type": "m.replace", "event_id": "$3"},
+		}}, "Alice"},
+		{gomatrix.Event{ID: "$3", Type: "m.room.message", Sender: alice, Timestamp: 3000, Content: map[string]interface{}{
+			"msgtype": "m.text",
+			"body":    "hello,\nworld",
+		}}, "Alice"},
+		{gomatrix.Event{ID: "$2", Type: "m.room.message", Sender: alice, Timestamp: 2000, Content: map[string]interface{}{
+			"msgtype": "m.emote",
+			"body":    "waves",
+		}}, "Alice"},
+		{gomatrix.Event{ID: "$1", Type: "m.room.member", Sender: alice, StateKey: &alice, Timestamp: 1000, Content: map[string]interface{}{
+			"membership":  "join",
+			"displayname": "Alice",
+		}}, "Alice"},
+	}
+
+	tests := []struct {
+		format string
+		exp    string
+	}{
+		{"csv", "timestamp,sender,display_name,body\n" +
+			"1970-01-01T00:00:03Z,@alice:example.org,Alice,\"hello, world\"\n" +
+			"1970-01-01T00:00:02Z,@alice:example.org,Alice,waves\n"},
+		{"txt", "[1970-01-01 00:00:03] <Alice> hello, world\n" +
+			"[1970-01-01 00:00:02] * Alice waves\n" +
+			"[1970-01-01 00:00:01] -!- Alice has joined\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(tt.format, &buf)
+			if err != nil {
+				t.Fatal("Failed creating writer", err)
+			}
+			for _, e := range events {
+				if err := w.WriteEvent(&e.ev, e.displayName); err != nil {
+					t.Fatal("Failed writing event", err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal("Failed flushing", err)
+			}
+			if buf.String() != tt.exp {
+				t.Errorf("Output mismatch expectation\n%s", buf.String())
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	w, _ := NewWriter("jsonl", &buf)
+	w.WriteEvent(&events[4].ev, "Alice")
+	w.Flush()
+	if exp := `{"state_key":"@alice:example.org","sender":"@alice:example.org","type":"m.room.member","origin_server_ts":1000,"event_id":"$1","room_id":"","unsigned":null,"content":{"displayname":"Alice","membership":"join"}}` + "\n"; buf.String() != exp {
+		t.Errorf("JSON lines mismatch expectation\n%s", buf.String())
+	}
+
+	if _, err := NewWriter("xml", &buf); err != ErrUnknownFormat {
+		t.Error("Should reject unknown formats", err)
+	}
+}
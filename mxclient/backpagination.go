@@ -0,0 +1,136 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mxclient
+
+import (
+	"context"
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/utils"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Backpagination describes a /messages request which must be made before a page of the timeline can be served from
+// memory. It holds no reference to the Room so may be fetched away from whichever goroutine owns it.
+type Backpagination struct {
+	RoomID string
+	From   string
+	Limit  int
+}
+
+// NeededBackpagination returns the back pagination which GetEventPage would otherwise make for the same arguments,
+// or nil if there is enough in memory already. Anchors which are not yet in memory at all are not considered.
+func (r *Room) NeededBackpagination(anchor string, offset, pageSize int) *Backpagination {
+	if offset < 0 {
+		return nil
+	}
+
+	t, token, reachedStart, anchorIndex := &r.timeline, r.backPaginationToken, r.HasReachedHistoricEndOfTimeline, 0
+	if anchor != "" {
+		fragment, index, found := r.locateAnchor(&anchor)
+		if !found {
+			return nil
+		}
+		if fragment != nil {
+			t, token, reachedStart = &fragment.timeline, fragment.backToken, fragment.reachedStart
+		}
+		anchorIndex = index
+	}
+
+	if reachedStart {
+		return nil
+	}
+	// see Room.backpaginateIfNeeded
+	length := t.Len()
+	if delta := anchorIndex + offset + pageSize + overcompensateBackpaginationBy; delta >= length {
+		return &Backpagination{r.ID, token, utils.Max(delta-length, minimumPagination)}
+	}
+	return nil
+}
+
+// maxPaginationChunk is the most events asked of /messages at once. Servers silently cap the limit themselves so the
+// number of events requested is only ever satisfied by following the end token until enough have been received.
+const maxPaginationChunk = 100
+
+// maxPaginationChunks bounds how many requests a single back pagination may take.
+const maxPaginationChunks = 20
+
+var (
+	backpaginationRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "matrix_static_backpagination_requests_total",
+		Help: "Number of /messages requests made back paginating rooms.",
+	})
+	backpaginatedEvents = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "matrix_static_backpaginated_events_total",
+		Help: "Number of events received back paginating rooms.",
+	})
+	backpaginationHistoryEnds = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "matrix_static_backpagination_history_ends_total",
+		Help: "Number of times back paginating reached the start of a room's history.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(backpaginationRequests, backpaginatedEvents, backpaginationHistoryEnds)
+}
+
+// FetchBackpagination makes the requests described by b, in chunks the server will honour, the response is to be
+// handed to Room.ApplyBackpagination. The end token of the response is empty if the start of history was reached.
+// If a request fails after some events have been received then those are returned rather than the error.
+func (m *Client) FetchBackpagination(ctx context.Context, b *Backpagination) (*gomatrix.RespMessages, error) {
+	resp := &gomatrix.RespMessages{Start: b.From, End: b.From}
+
+	for i := 0; len(resp.Chunk) < b.Limit && i < maxPaginationChunks; i++ {
+		limit := utils.Min(b.Limit-len(resp.Chunk), maxPaginationChunk)
+		backpaginationRequests.Inc()
+
+		chunk, err := m.RoomMessages(ctx, b.RoomID, resp.End, "", 'b', limit)
+		if err != nil {
+			if len(resp.Chunk) > 0 && ctx.Err() == nil {
+				break
+			}
+			return nil, err
+		}
+		backpaginatedEvents.Add(float64(len(chunk.Chunk)))
+
+		resp.Chunk = append(resp.Chunk, chunk.Chunk...)
+		// an empty chunk or missing end token is the only reliable sign of there being nothing further back.
+		if len(chunk.Chunk) == 0 || chunk.End == "" {
+			backpaginationHistoryEnds.Inc()
+			resp.End = ""
+			break
+		}
+		resp.End = chunk.End
+	}
+	return resp, nil
+}
+
+// ApplyBackpagination adds resp, the result of b, onto whichever part of the timeline b continues.
+// It is ignored if that has since moved on, for example if another request already applied the same pagination.
+func (r *Room) ApplyBackpagination(b *Backpagination, resp *gomatrix.RespMessages) {
+	if r.backPaginationToken == b.From && !r.HasReachedHistoricEndOfTimeline {
+		r.concatBackpagination(resp.Chunk, resp.End)
+		if len(resp.Chunk) == 0 {
+			r.HasReachedHistoricEndOfTimeline = true
+		}
+		return
+	}
+
+	for _, f := range r.fragments {
+		if f.backToken == b.From && !f.reachedStart {
+			r.concatFragmentBackpagination(f, resp)
+			return
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package mxclient
+
+import (
+	"context"
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/utils"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRoom_ApplyBackpagination(t *testing.T) {
+	room := &Room{ID: "!room", latestRoomState: *NewRoomState(nil)}
+	room.concatForwardPagination(messageEvents(91, 100), "forward")
+	room.setBackPaginationToken("before91")
+
+	b := room.NeededBackpagination("", 0, 5)
+	if b == nil || b.From != "before91" || b.RoomID != "!room" {
+		t.Fatal("Backpagination mismatch expectation", b)
+	}
+
+	resp := &gomatrix.RespMessages{Chunk: messageEvents(90, 51), End: "before51"}
+	room.ApplyBackpagination(b, resp)
+	// applying the same back pagination again, as a concurrent request might, must be ignored.
+	room.ApplyBackpagination(b, resp)
+
+	if ids := eventIDs(room.timeline.Range(0, room.timeline.Len())); !reflect.DeepEqual(ids, eventIDs(messageEvents(100, 51))) {
+		t.Error("Timeline mismatch expectation", ids)
+	}
+	if room.backPaginationToken != "before51" {
+		t.Error("Back pagination token mismatch expectation", room.backPaginationToken)
+	}
+	if b := room.NeededBackpagination("", 0, 5); b != nil {
+		t.Error("Should have enough events in memory", b)
+	}
+
+	room.ApplyBackpagination(room.NeededBackpagination("", 40, 5), &gomatrix.RespMessages{End: "before51"})
+	if !room.HasReachedHistoricEndOfTimeline {
+		t.Error("An empty back pagination should reach the end of the timeline")
+	}
+}
+
+// cappedHomeserver serves /messages back paginations of a history of events $1 to $numEvents, returning at most pageCap
+// events per request whatever the limit asked for, as Synapse does. Tokens are of the form beforeN.
+type cappedHomeserver struct {
+	numEvents   int
+	pageCap     int
+	omitEnd     bool
+	numRequests int
+}
+
+func (hs *cappedHomeserver) RoundTrip(req *http.Request) *http.Response {
+	hs.numRequests++
+	query := req.URL.Query()
+	before, _ := strconv.Atoi(strings.TrimPrefix(query.Get("from"), "before"))
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	limit = utils.Min(limit, hs.pageCap)
+
+	resp := map[string]interface{}{"start": query.Get("from")}
+	if oldest := utils.Max(before-limit, 1); oldest < before {
+		resp["chunk"] = messageEvents(before-1, oldest)
+		if oldest > 1 || !hs.omitEnd {
+			resp["end"] = "before" + strconv.Itoa(oldest)
+		}
+	} else {
+		resp["chunk"] = []gomatrix.Event{}
+	}
+	return jsonResponse(resp)
+}
+
+func TestClient_backpaginateRoom(t *testing.T) {
+	tests := []struct {
+		name           string
+		homeserver     *cappedHomeserver
+		amount         int
+		expNumRequests int
+		expEvents      []gomatrix.Event
+		expReachedEnd  bool
+	}{
+		{
+			"should satisfy the amount over several capped requests",
+			&cappedHomeserver{numEvents: 500, pageCap: 10},
+			64,
+			7,
+			messageEvents(500, 387),
+			false,
+		}, {
+			"should detect the start of history from an empty chunk",
+			&cappedHomeserver{numEvents: 100, pageCap: 30},
+			200,
+			3,
+			messageEvents(100, 1),
+			true,
+		}, {
+			"should detect the start of history from a missing end token",
+			&cappedHomeserver{numEvents: 100, pageCap: 30, omitEnd: true},
+			200,
+			2,
+			messageEvents(100, 1),
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli, _ := NewRawClient("https://example.org", "", "", "")
+			cli.Client.Client.Transport = RoundTripFunc(tt.homeserver.RoundTrip)
+
+			// the room starts out holding the 50 latest events.
+			latest, oldest := tt.homeserver.numEvents, tt.homeserver.numEvents-49
+			room := &Room{Client: cli, ID: "!room", latestRoomState: *NewRoomState(nil)}
+			room.concatForwardPagination(messageEvents(oldest, latest), "forward")
+			room.setBackPaginationToken("before" + strconv.Itoa(oldest))
+
+			if _, err := cli.backpaginateRoom(context.Background(), room, tt.amount); err != nil {
+				t.Fatal("Failed back paginating", err)
+			}
+			if tt.homeserver.numRequests != tt.expNumRequests {
+				t.Error("Number of requests mismatch expectation", tt.homeserver.numRequests)
+			}
+			if ids := eventIDs(room.timeline.Range(0, room.timeline.Len())); !reflect.DeepEqual(ids, eventIDs(tt.expEvents)) {
+				t.Error("Timeline mismatch expectation", ids)
+			}
+			if room.HasReachedHistoricEndOfTimeline != tt.expReachedEnd {
+				t.Error("Reached end mismatch expectation", room.HasReachedHistoricEndOfTimeline)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mxclient
+
+import (
+	"github.com/matrix-org/gomatrix"
+	"sort"
+	"time"
+)
+
+const (
+	// DayFormat is how days are written in URLs and keyed by MessageDays.
+	DayFormat = "2006-01-02"
+	// MonthFormat is how months are written in URLs.
+	MonthFormat = "2006-01"
+)
+
+// Timestamp returns t as a Matrix timestamp, in milliseconds since the epoch.
+func Timestamp(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// dateBackpaginationLimit is how many events are back paginated at once when looking for the events of a day.
+const dateBackpaginationLimit = 500
+
+// BackpaginationSince returns the back pagination needed for the live timeline to reach back past ts, or nil if it
+// does already or there is no more history to be had.
+func (r *Room) BackpaginationSince(ts int64) *Backpagination {
+	if r.HasReachedHistoricEndOfTimeline {
+		return nil
+	}
+	if length := r.timeline.Len(); length > 0 && r.timeline.At(length-1).Timestamp < ts {
+		return nil
+	}
+	return &Backpagination{r.ID, r.backPaginationToken, dateBackpaginationLimit}
+}
+
+// EventAtOrAfter bisects the live timeline for the oldest event sent at or after ts, returning "" if there is none.
+// It can only be relied upon once BackpaginationSince(ts) returns nil, until then the oldest event held is returned.
+func (r *Room) EventAtOrAfter(ts int64) string {
+	length := r.timeline.Len()
+	// sort.Search wants the events oldest first, which is the reverse of their positions.
+	i := sort.Search(length, func(i int) bool {
+		return r.timeline.At(length-1-i).Timestamp >= ts
+	})
+	if i == length {
+		return ""
+	}
+	return r.timeline.At(length - 1 - i).ID
+}
+
+// MessageDays counts the messages in the live timeline by the day they were sent on, see MessageDays.
+func (r *Room) MessageDays() map[string]int {
+	return MessageDays(r.timeline.Range(0, r.timeline.Len()))
+}
+
+// MessageDays counts the messages amongst events by the day they were sent on, in local time as the timeline is shown
+// in, keyed by DayFormat.
+func MessageDays(events []gomatrix.Event) map[string]int {
+	days := make(map[string]int)
+	for _, ev := range events {
+		if ev.StateKey != nil {
+			continue
+		}
+		days[time.Unix(0, ev.Timestamp*int64(time.Millisecond)).Format(DayFormat)]++
+	}
+	return days
+}
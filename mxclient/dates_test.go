@@ -0,0 +1,66 @@
+package mxclient
+
+import (
+	"github.com/matrix-org/gomatrix"
+	"testing"
+	"time"
+)
+
+func TestRoom_EventAtOrAfter(t *testing.T) {
+	room := &Room{ID: "!room", latestRoomState: *NewRoomState(nil), backPaginationToken: "back"}
+	if b := room.BackpaginationSince(10); b == nil || b.From != "back" {
+		t.Error("An empty timeline should need back paginating", b)
+	}
+
+	room.concatForwardPagination([]gomatrix.Event{
+		{ID: "$1", Type: "m.room.message", Timestamp: 10},
+		{ID: "$2", Type: "m.room.message", Timestamp: 20},
+		{ID: "$3", Type: "m.room.message", Timestamp: 20},
+		{ID: "$4", Type: "m.room.message", Timestamp: 30},
+	}, "forward")
+
+	tests := []struct {
+		ts              int64
+		expEventID      string
+		expBackpaginate bool
+	}{
+		{5, "$1", true},
+		{10, "$1", true},
+		{11, "$2", false},
+		{20, "$2", false},
+		{30, "$4", false},
+		{31, "", false},
+	}
+	for _, tt := range tests {
+		if eventID := room.EventAtOrAfter(tt.ts); eventID != tt.expEventID {
+			t.Errorf("EventAtOrAfter(%d) = %q, want %q", tt.ts, eventID, tt.expEventID)
+		}
+		if b := room.BackpaginationSince(tt.ts); (b != nil) != tt.expBackpaginate {
+			t.Errorf("BackpaginationSince(%d) = %v", tt.ts, b)
+		}
+	}
+
+	room.HasReachedHistoricEndOfTimeline = true
+	if b := room.BackpaginationSince(5); b != nil {
+		t.Error("There is nothing further back to paginate", b)
+	}
+}
+
+func TestRoom_MessageDays(t *testing.T) {
+	day1 := Timestamp(time.Date(2020, 1, 1, 12, 0, 0, 0, time.Local))
+	day2 := Timestamp(time.Date(2020, 1, 3, 0, 0, 0, 0, time.Local))
+	stateKey := ""
+
+	room := &Room{latestRoomState: *NewRoomState(nil)}
+	room.concatForwardPagination([]gomatrix.Event{
+		{ID: "$1", Type: "m.room.message", Timestamp: day1},
+		{ID: "$2", Type: "m.room.topic", StateKey: &stateKey, Content: map[string]interface{}{"topic": "t"}, Timestamp: day1},
+		{ID: "$3", Type: "m.room.message", Timestamp: day1 + 1},
+		{ID: "$4", Type: "m.room.message", Timestamp: day2},
+	}, "forward")
+
+	days := room.MessageDays()
+	if len(days) != 2 || days["2020-01-01"] != 2 || days["2020-01-03"] != 1 {
+		t.Error("Messages counted incorrectly", days)
+	}
+}
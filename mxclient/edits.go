@@ -0,0 +1,142 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mxclient
+
+import "github.com/matrix-org/gomatrix"
+
+// IsEdit returns whether ev replaces the content of another event.
+func IsEdit(ev gomatrix.Event) bool {
+	relType, eventID := relation(ev)
+	return relType == "m.replace" && eventID != "" && ev.StateKey == nil
+}
+
+// EditedEventID returns the ID of the event whose content ev replaces, or "" if ev is not an edit.
+func EditedEventID(ev gomatrix.Event) string {
+	if !IsEdit(ev) {
+		return ""
+	}
+	_, eventID := relation(ev)
+	return eventID
+}
+
+// validEdits returns the edits of original which are permitted, oldest first, only its sender may edit an event.
+func (r *Room) validEdits(original gomatrix.Event) []gomatrix.Event {
+	var valid []gomatrix.Event
+	for _, edit := range r.relatedEvents(original.ID, "m.replace") {
+		if edit.Sender == original.Sender && edit.Type == original.Type {
+			if _, ok := edit.Content["m.new_content"].(map[string]interface{}); ok {
+				valid = append(valid, edit)
+			}
+		}
+	}
+	return valid
+}
+
+// applyEdits replaces the content of each of events with that of its latest edit, in place, marking it as edited by
+// setting unsigned.m.relations.m.replace to the edit as a server aggregating relations would.
+// Edits already aggregated by the server are applied as well.
+func (r *Room) applyEdits(events []gomatrix.Event) {
+	for i, ev := range events {
+		if IsRedacted(&ev) {
+			continue
+		}
+
+		if edits := r.validEdits(ev); len(edits) > 0 {
+			latest := edits[len(edits)-1]
+			events[i] = withReplacement(ev, latest, latest.Content["m.new_content"].(map[string]interface{}))
+		} else if edit, ok := serverAggregatedEdit(&ev); ok {
+			content, _ := edit["content"].(map[string]interface{})
+			if newContent, ok := content["m.new_content"].(map[string]interface{}); ok {
+				events[i] = withReplacement(ev, edit, newContent)
+			}
+		}
+	}
+}
+
+// WithEdits returns a copy of events showing the latest edit of each, as GetEventPage does, for events which were not
+// taken from the timeline such as those of a thread.
+func (r *Room) WithEdits(events []gomatrix.Event) []gomatrix.Event {
+	edited := make([]gomatrix.Event, len(events))
+	copy(edited, events)
+	r.applyEdits(edited)
+	return edited
+}
+
+// withReplacement returns a copy of ev showing newContent from edit, the maps of ev are left untouched.
+func withReplacement(ev gomatrix.Event, edit interface{}, newContent map[string]interface{}) gomatrix.Event {
+	relations := make(map[string]interface{})
+	if existing, ok := ev.Unsigned["m.relations"].(map[string]interface{}); ok {
+		for k, v := range existing {
+			relations[k] = v
+		}
+	}
+	relations["m.replace"] = edit
+
+	unsigned := make(map[string]interface{}, len(ev.Unsigned)+1)
+	for k, v := range ev.Unsigned {
+		unsigned[k] = v
+	}
+	unsigned["m.relations"] = relations
+
+	ev.Content = newContent
+	ev.Unsigned = unsigned
+	return ev
+}
+
+func serverAggregatedEdit(ev *gomatrix.Event) (map[string]interface{}, bool) {
+	relations, _ := ev.Unsigned["m.relations"].(map[string]interface{})
+	edit, ok := relations["m.replace"].(map[string]interface{})
+	return edit, ok
+}
+
+// IsEdited returns whether ev has been edited, in which case its content is already that of the latest edit.
+func IsEdited(ev *gomatrix.Event) bool {
+	relations, _ := ev.Unsigned["m.relations"].(map[string]interface{})
+	_, ok := relations["m.replace"]
+	return ok
+}
+
+// EditedAt returns when ev was last edited in milliseconds since the epoch, or when it was sent if it was not.
+func EditedAt(ev *gomatrix.Event) int64 {
+	relations, _ := ev.Unsigned["m.relations"].(map[string]interface{})
+	switch edit := relations["m.replace"].(type) {
+	case gomatrix.Event:
+		return edit.Timestamp
+	case map[string]interface{}:
+		// edits aggregated by the server are JSON objects.
+		if ts, ok := edit["origin_server_ts"].(float64); ok {
+			return int64(ts)
+		}
+	}
+	return ev.Timestamp
+}
+
+// EditHistory returns the event with the given ID as it was originally sent alongside its edits, oldest first.
+func (r *Room) EditHistory(eventID string) (original gomatrix.Event, edits []gomatrix.Event, found bool) {
+	fragment, index, found := r.locateEvent(eventID)
+	if !found {
+		return
+	}
+
+	t := &r.timeline
+	if fragment != nil {
+		t = &fragment.timeline
+	}
+	original = t.At(index)
+	if !IsRedacted(&original) {
+		edits = r.validEdits(original)
+	}
+	return original, edits, true
+}
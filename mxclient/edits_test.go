@@ -0,0 +1,104 @@
+package mxclient
+
+import (
+	"context"
+	"github.com/matrix-org/gomatrix"
+	"reflect"
+	"testing"
+)
+
+func makeEdit(id, sender, targetID, body string, ts int64) gomatrix.Event {
+	return gomatrix.Event{
+		ID:        id,
+		Type:      "m.room.message",
+		Sender:    sender,
+		Timestamp: ts,
+		Content: map[string]interface{}{
+			"msgtype":       "m.text",
+			"body":          "* " + body,
+			"m.new_content": map[string]interface{}{"msgtype": "m.text", "body": body},
+			"m.relates_to":  map[string]interface{}{"rel_type": "m.replace", "event_id": targetID},
+		},
+	}
+}
+
+func TestRoom_Edits(t *testing.T) {
+	original := gomatrix.Event{ID: "$1", Type: "m.room.message", Sender: "@alice:example.org", Timestamp: 1,
+		Content: map[string]interface{}{"msgtype": "m.text", "body": "helo"}}
+
+	room := &Room{latestRoomState: *NewRoomState(nil)}
+	// the first edit arrives whilst back paginating, the others once the room is live.
+	room.concatForwardPagination([]gomatrix.Event{{ID: "$2", Type: "m.room.message", Timestamp: 2}}, "forward")
+	room.setBackPaginationToken("before2")
+	room.concatBackpagination([]gomatrix.Event{makeEdit("$e1", "@alice:example.org", "$1", "hello", 3), original}, "before1")
+	room.HasReachedHistoricEndOfTimeline = true
+	room.concatForwardPagination([]gomatrix.Event{
+		makeEdit("$e2", "@alice:example.org", "$1", "hello world", 5),
+		makeEdit("$e3", "@mallory:example.org", "$1", "pwned", 6),
+		makeEdit("$e1", "@alice:example.org", "$1", "hello", 3),
+	}, "forward")
+
+	events, _, _, err := room.GetEventPage(context.Background(), "", 0, 10)
+	if err != nil {
+		t.Fatal("Failed getting page", err)
+	}
+	if ids := eventIDs(events); !reflect.DeepEqual(ids, []string{"$2", "$1"}) {
+		t.Fatal("Edits should be kept out of the timeline", ids)
+	}
+	if !IsEdited(&events[1]) || events[1].Content["body"] != "hello world" {
+		t.Error("Latest edit by the sender should be shown", events[1].Content)
+	}
+	if IsEdited(&events[0]) {
+		t.Error("Unedited event should not be marked as edited")
+	}
+	if EditedAt(&events[1]) != 5 || EditedAt(&events[0]) != 2 {
+		t.Error("Edit timestamps mismatch expectation", EditedAt(&events[1]), EditedAt(&events[0]))
+	}
+	if original, _, _ := room.EditHistory("$1"); original.Content["body"] != "helo" {
+		t.Error("Cached event should be left untouched", original.Content)
+	}
+
+	// permalinks to an edit lead to the event it edits.
+	if events, _, _, err := room.GetEventPage(context.Background(), "$e2", 0, 1); err != nil || events[0].ID != "$1" {
+		t.Error("Anchoring on an edit should anchor on its event", events, err)
+	}
+
+	room.concatForwardPagination([]gomatrix.Event{{ID: "$r", Type: "m.room.redaction", Redacts: "$e2"}}, "forward")
+	_, edits, _ := room.EditHistory("$1")
+	if ids := eventIDs(edits); !reflect.DeepEqual(ids, []string{"$e1"}) {
+		t.Error("Edit history mismatch expectation", ids)
+	}
+
+	restored := storedRoundTrip(room)
+	events, _, _, _ = restored.GetEventPage(context.Background(), "", 0, 10)
+	if events[1].Content["body"] != "hello" {
+		t.Error("Edits should survive being stored", events[1].Content)
+	}
+}
+
+func TestRoom_RelationTargets(t *testing.T) {
+	room := &Room{latestRoomState: *NewRoomState(nil)}
+	room.concatForwardPagination([]gomatrix.Event{
+		textMessage("$1", "hello"),
+		makeEdit("$e1", "@alice:example.org", "$1", "hi", 2),
+		makeEdit("$e2", "@alice:example.org", "$gone", "hi", 3),
+	}, "forward")
+
+	if targetID, found := room.relationTarget("$e1"); !found || targetID != "$1" {
+		t.Error("Relation target mismatch expectation", targetID, found)
+	}
+
+	// redacting the edit forgets it.
+	if !room.removeRelation("$e1") || room.removeRelation("$e1") {
+		t.Error("Edit should be removed exactly once")
+	}
+	if _, found := room.relationTarget("$e1"); found || len(room.relations["$1"]) != 0 {
+		t.Error("Removed edit should no longer be indexed")
+	}
+
+	// edits of events which are not held are pruned along with their index.
+	room.pruneRelations()
+	if _, found := room.relationTarget("$e2"); found || len(room.relationTargets) != 0 {
+		t.Error("Pruned edit should no longer be indexed", room.relationTargets)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mxclient
+
+import (
+	"context"
+	log "github.com/Sirupsen/logrus"
+	"github.com/matrix-org/gomatrix"
+)
+
+// Forwardpagination describes a /messages request bringing a room up to date which /sync does not, as our account
+// only peeks it rather than being joined. Like Backpagination it may be fetched away from whichever goroutine owns
+// the Room.
+type Forwardpagination struct {
+	RoomID string
+	From   string
+}
+
+// NeededForwardpagination returns the forward pagination which would bring the room up to date, or nil if /sync
+// keeps it up to date already.
+func (r *Room) NeededForwardpagination() *Forwardpagination {
+	if r.IsJoined() || r.forwardPaginationToken == "" {
+		return nil
+	}
+	return &Forwardpagination{r.ID, r.forwardPaginationToken}
+}
+
+// FetchForwardpagination makes the request described by f, following on from each response until caught up with the
+// server or maxGapFillPaginations requests were made, so that busy rooms do not fall further behind with every poll.
+// The responses are combined into one, which is to be handed to Room.ApplyForwardpagination.
+func (m *Client) FetchForwardpagination(ctx context.Context, f *Forwardpagination) (*gomatrix.RespMessages, error) {
+	combined := &gomatrix.RespMessages{Start: f.From, End: f.From}
+	for i := 0; i < maxGapFillPaginations; i++ {
+		resp, err := m.RoomMessages(ctx, f.RoomID, combined.End, "", 'f', maxPaginationChunk)
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			// keep what was fetched, the rest will be caught up on next time.
+			log.WithField("roomID", f.RoomID).WithError(err).Warn("Failed Forward paginating Room further")
+			break
+		}
+
+		combined.Chunk = append(combined.Chunk, resp.Chunk...)
+		// the server omits the end token once there is nothing further.
+		if len(resp.Chunk) == 0 || resp.End == "" || resp.End == combined.End {
+			break
+		}
+		combined.End = resp.End
+	}
+	return combined, nil
+}
+
+// ApplyForwardpagination adds resp, the result of f, onto the newest end of the timeline, returning whether it did.
+// It is ignored if the room has since moved on, for example if /sync or another request brought it up to date.
+func (r *Room) ApplyForwardpagination(f *Forwardpagination, resp *gomatrix.RespMessages) bool {
+	if r.forwardPaginationToken != f.From {
+		return false
+	}
+
+	newEvents := make([]gomatrix.Event, 0, len(resp.Chunk))
+	for _, event := range resp.Chunk {
+		if !r.hasEvent(event.ID) {
+			newEvents = append(newEvents, event)
+		}
+	}
+	if len(newEvents) == 0 {
+		if resp.End != "" {
+			r.forwardPaginationToken = resp.End
+		}
+		return false
+	}
+
+	r.concatForwardPagination(newEvents, resp.End)
+	return true
+}
@@ -0,0 +1,89 @@
+package mxclient
+
+import (
+	"context"
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/utils"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// forwardHomeserver serves /messages forward paginations of a history of events $1 to $numEvents, returning at most
+// pageCap events per request. Tokens are of the form afterN.
+type forwardHomeserver struct {
+	numEvents   int
+	pageCap     int
+	numRequests int
+}
+
+func (hs *forwardHomeserver) RoundTrip(req *http.Request) *http.Response {
+	hs.numRequests++
+	query := req.URL.Query()
+	after, _ := strconv.Atoi(strings.TrimPrefix(query.Get("from"), "after"))
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	limit = utils.Min(limit, hs.pageCap)
+
+	resp := map[string]interface{}{"start": query.Get("from"), "chunk": []gomatrix.Event{}}
+	if newest := utils.Min(after+limit, hs.numEvents); newest > after {
+		resp["chunk"] = messageEvents(after+1, newest)
+		resp["end"] = "after" + strconv.Itoa(newest)
+	}
+	return jsonResponse(resp)
+}
+
+func TestClient_FetchForwardpagination(t *testing.T) {
+	tests := []struct {
+		name           string
+		homeserver     *forwardHomeserver
+		expNumRequests int
+		expEvents      []gomatrix.Event
+	}{
+		{
+			"should follow on until caught up",
+			&forwardHomeserver{numEvents: 250, pageCap: 100},
+			4,
+			messageEvents(11, 250),
+		}, {
+			"should make no more than maxGapFillPaginations requests",
+			&forwardHomeserver{numEvents: 1000, pageCap: 10},
+			maxGapFillPaginations,
+			messageEvents(11, 10+10*maxGapFillPaginations),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cli, _ := NewRawClient("https://example.org", "", "", "")
+			cli.Client.Client.Transport = RoundTripFunc(tt.homeserver.RoundTrip)
+
+			// the room starts out holding the 10 oldest events.
+			room := &Room{Client: cli, ID: "!room", latestRoomState: *NewRoomState(nil)}
+			room.concatForwardPagination(messageEvents(1, 10), "after10")
+
+			f := room.NeededForwardpagination()
+			if f == nil || f.From != "after10" {
+				t.Fatal("Forwardpagination mismatch expectation", f)
+			}
+			resp, err := cli.FetchForwardpagination(context.Background(), f)
+			if err != nil {
+				t.Fatal("Failed forward paginating", err)
+			}
+			if tt.homeserver.numRequests != tt.expNumRequests {
+				t.Error("Number of requests mismatch expectation", tt.homeserver.numRequests)
+			}
+			if ids := eventIDs(resp.Chunk); !reflect.DeepEqual(ids, eventIDs(tt.expEvents)) {
+				t.Error("Chunk mismatch expectation", ids)
+			}
+
+			if !room.ApplyForwardpagination(f, resp) || room.LatestEventID() != tt.expEvents[len(tt.expEvents)-1].ID {
+				t.Error("Should have caught up to the latest event fetched", room.LatestEventID())
+			}
+			// applying the same forward pagination again, as a concurrent request might, must be ignored.
+			if room.ApplyForwardpagination(f, resp) {
+				t.Error("Should ignore a forward pagination the room has moved on from")
+			}
+		})
+	}
+}
@@ -0,0 +1,285 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mxclient
+
+import (
+	"context"
+	log "github.com/Sirupsen/logrus"
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/utils"
+)
+
+// eventFragment is a contiguous piece of room history which is detached from the live timeline, loaded around an
+// event via /context so that permalinks deep in history don't require back paginating all the way to them.
+// Once a fragment is found to meet the live timeline it is merged into it, see Room.mergeFragment.
+type eventFragment struct {
+	timeline timeline
+
+	backToken    string
+	forwardToken string
+	reachedStart bool
+}
+
+// ContextLimit is the number of events we ask /context for around a permalinked event.
+const ContextLimit = 64
+
+// MaxFragments bounds how many fragments a room holds, beyond which the least recently used is discarded so that
+// following permalinks all over a room's history cannot grow it without limit.
+const MaxFragments = 8
+
+// loadEventContext fetches the events surrounding eventID as a new fragment, merging it straight into the live
+// timeline if the two overlap.
+func (r *Room) loadEventContext(ctx context.Context, eventID string) error {
+	loggerWithFields := log.WithField("roomID", r.ID).WithField("eventID", eventID)
+	loggerWithFields.Info("Loading Event Context")
+
+	resp, err := r.Client.RoomContext(ctx, r.ID, eventID, ContextLimit)
+	if err != nil {
+		loggerWithFields.WithError(err).Error("Failed Loading Event Context")
+		return err
+	}
+
+	f := &eventFragment{
+		backToken:    resp.Start,
+		forwardToken: resp.End,
+	}
+
+	if r.acceptEvent(resp.Event) {
+		f.timeline.PushNewest(resp.Event)
+	}
+	// events_before is in reverse chronological order, events_after in chronological order.
+	for _, event := range resp.EventsBefore {
+		if r.acceptEvent(event) {
+			f.timeline.PushOldest(event)
+		}
+	}
+
+	r.fragments = append(r.fragments, f)
+	if r.appendToFragment(f, resp.EventsAfter) {
+		r.mergeFragment(f)
+		return nil
+	}
+	r.mergeOverlappingFragments(f)
+
+	if len(r.fragments) > MaxFragments {
+		loggerWithFields.Info("Discarding least recently used Fragment")
+		r.fragments = r.fragments[1:]
+		r.pruneRelations()
+	}
+	return nil
+}
+
+// touchFragment marks f as the most recently used fragment, r.fragments being ordered by when they were last used.
+func (r *Room) touchFragment(f *eventFragment) {
+	for i, fragment := range r.fragments {
+		if fragment == f {
+			r.fragments = append(append(r.fragments[:i:i], r.fragments[i+1:]...), f)
+			return
+		}
+	}
+}
+
+// mergeOverlappingFragments merges every other fragment sharing events with f into f, as the history they hold is
+// contiguous they overlap if either holds an end of the other.
+func (r *Room) mergeOverlappingFragments(f *eventFragment) {
+	if f.timeline.Len() == 0 {
+		return
+	}
+
+	for i := 0; i < len(r.fragments); i++ {
+		g := r.fragments[i]
+		if g == f || g.timeline.Len() == 0 {
+			continue
+		}
+
+		newestIndex, holdsNewest := g.timeline.IndexOf(f.timeline.At(0).ID)
+		oldestIndex, holdsOldest := g.timeline.IndexOf(f.timeline.At(f.timeline.Len() - 1).ID)
+		if _, withinF := f.timeline.IndexOf(g.timeline.At(0).ID); !holdsNewest && !holdsOldest && !withinF {
+			continue
+		}
+
+		// g extends further forward than f, take on its newer events oldest first.
+		if holdsNewest {
+			for pos := newestIndex - 1; pos >= 0; pos-- {
+				f.timeline.PushNewest(g.timeline.At(pos))
+			}
+			f.forwardToken = g.forwardToken
+		}
+		// g extends further back than f.
+		if holdsOldest {
+			for pos := oldestIndex + 1; pos < g.timeline.Len(); pos++ {
+				f.timeline.PushOldest(g.timeline.At(pos))
+			}
+			f.backToken = g.backToken
+			f.reachedStart = g.reachedStart
+		}
+
+		r.fragments = append(r.fragments[:i], r.fragments[i+1:]...)
+		i--
+	}
+}
+
+// appendToFragment adds events newer than those in f to it, returning true as soon as
+// one of them is found to be in the live timeline, in which case f should be merged.
+func (r *Room) appendToFragment(f *eventFragment, newEvents []gomatrix.Event) (overlapsLive bool) {
+	for _, event := range newEvents {
+		if event.Type == "m.room.redaction" {
+			r.applyRedaction(event)
+		}
+		if !r.acceptEvent(event) {
+			continue
+		}
+		if _, found := r.timeline.IndexOf(event.ID); found {
+			return true
+		}
+		f.timeline.PushNewest(event)
+	}
+	return false
+}
+
+// mergeFragment moves the events of f which are older than the live timeline onto its end and forgets about f.
+// It must only be called once f is known to meet the live timeline.
+func (r *Room) mergeFragment(f *eventFragment) {
+	log.WithField("roomID", r.ID).Info("Merging Fragment into Timeline")
+
+	// skip over the events both have in common, everything after is older than the live timeline holds.
+	merging := false
+	for pos := 0; pos < f.timeline.Len(); pos++ {
+		event := f.timeline.At(pos)
+		if !merging {
+			if _, found := r.timeline.IndexOf(event.ID); found {
+				continue
+			}
+			merging = true
+		}
+		r.timeline.PushOldest(event)
+		r.reindexSearch(event.ID)
+	}
+
+	// the fragment extended further back than the live timeline so continue back paginating from where it left off.
+	if merging {
+		r.setBackPaginationToken(f.backToken)
+		r.HasReachedHistoricEndOfTimeline = f.reachedStart
+		r.markDirty()
+	}
+
+	for i, fragment := range r.fragments {
+		if fragment == f {
+			r.fragments = append(r.fragments[:i], r.fragments[i+1:]...)
+			break
+		}
+	}
+}
+
+// mergeMetFragments merges every fragment which the live timeline has back paginated into.
+func (r *Room) mergeMetFragments() {
+	var met []*eventFragment
+	for _, f := range r.fragments {
+		if f.timeline.Len() == 0 {
+			continue
+		}
+		if _, found := r.timeline.IndexOf(f.timeline.At(0).ID); found {
+			met = append(met, f)
+		}
+	}
+	for _, f := range met {
+		r.mergeFragment(f)
+	}
+}
+
+func (r *Room) backpaginateFragmentIfNeeded(ctx context.Context, f *eventFragment, anchorIndex, offset, number int) {
+	if f.reachedStart {
+		return
+	}
+
+	// see Room.backpaginateIfNeeded
+	length := f.timeline.Len()
+	if delta := anchorIndex + offset + number + overcompensateBackpaginationBy; delta >= length {
+		b := &Backpagination{r.ID, f.backToken, utils.Max(delta-length, minimumPagination)}
+		resp, err := r.Client.FetchBackpagination(ctx, b)
+		if err != nil {
+			log.WithField("roomID", r.ID).WithError(err).Error("Failed Backpaginating Fragment")
+			return
+		}
+		r.concatFragmentBackpagination(f, resp)
+	}
+}
+
+func (r *Room) concatFragmentBackpagination(f *eventFragment, resp *gomatrix.RespMessages) {
+	for _, event := range resp.Chunk {
+		if r.acceptEvent(event) {
+			f.timeline.PushOldest(event)
+		}
+	}
+	if len(resp.Chunk) == 0 || resp.End == "" {
+		f.reachedStart = true
+	} else {
+		f.backToken = resp.End
+	}
+	r.mergeOverlappingFragments(f)
+}
+
+// forwardpaginateFragmentIfNeeded ensures f holds offset events newer than position anchorIndex, returning true if f
+// met the live timeline whilst doing so, in which case it has been merged and is no longer valid.
+func (r *Room) forwardpaginateFragmentIfNeeded(ctx context.Context, f *eventFragment, anchorIndex, offset int) (merged bool) {
+	anchorID := f.timeline.At(anchorIndex).ID
+	for i := 0; anchorIndex < offset && i < maxGapFillPaginations; i++ {
+		amount := utils.Max(offset-anchorIndex, minimumPagination)
+		resp, err := r.Client.RoomMessages(ctx, r.ID, f.forwardToken, "", 'f', amount)
+		if err != nil {
+			log.WithField("roomID", r.ID).WithError(err).Error("Failed Forward paginating Fragment")
+			return false
+		}
+
+		if r.appendToFragment(f, resp.Chunk) {
+			r.mergeFragment(f)
+			return true
+		}
+		if resp.End != "" {
+			f.forwardToken = resp.End
+		}
+		if len(resp.Chunk) == 0 {
+			return false
+		}
+		// merging may have added events at either end so find the anchor afresh.
+		r.mergeOverlappingFragments(f)
+		anchorIndex, _ = f.timeline.IndexOf(anchorID)
+	}
+	return false
+}
+
+// getFragmentEventPage is the GetEventPage counterpart for an anchor within the fragment f.
+func (r *Room) getFragmentEventPage(ctx context.Context, f *eventFragment, anchor string, offset, pageSize int) (events []gomatrix.Event, atTopEnd, atBottomEnd bool, err error) {
+	r.touchFragment(f)
+	anchorIndex, _ := f.timeline.IndexOf(anchor)
+
+	if offset >= 0 {
+		r.backpaginateFragmentIfNeeded(ctx, f, anchorIndex, offset, pageSize)
+		events = f.timeline.backwardRange(anchorIndex, offset, pageSize)
+	} else {
+		if r.forwardpaginateFragmentIfNeeded(ctx, f, anchorIndex, -offset) {
+			// the anchor now lives in the live timeline.
+			return r.getEventPage(ctx, anchor, offset, pageSize)
+		}
+		anchorIndex, _ = f.timeline.IndexOf(anchor)
+		events = f.timeline.forwardRange(anchorIndex, -offset, pageSize)
+	}
+
+	if numEvents := len(events); numEvents > 0 {
+		atTopEnd = events[numEvents-1].ID == f.timeline.At(f.timeline.Len()-1).ID
+	}
+	// a fragment never rests at the bottom end as it has yet to meet the live timeline.
+	return events, atTopEnd, false, nil
+}
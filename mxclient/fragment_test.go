@@ -0,0 +1,149 @@
+package mxclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/matrix-org/gomatrix"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type RoundTripFunc func(req *http.Request) *http.Response
+
+func (f RoundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req), nil
+}
+
+func jsonResponse(body interface{}) *http.Response {
+	data, _ := json.Marshal(body)
+	return &http.Response{
+		StatusCode: 200,
+		Body:       ioutil.NopCloser(bytes.NewBuffer(data)),
+		Header:     make(http.Header),
+	}
+}
+
+func messageEvents(from, to int) []gomatrix.Event {
+	var events []gomatrix.Event
+	step := 1
+	if from > to {
+		step = -1
+	}
+	for i := from; i != to+step; i += step {
+		events = append(events, gomatrix.Event{ID: "$" + strconv.Itoa(i), Type: "m.room.message"})
+	}
+	return events
+}
+
+func TestRoom_GetEventPageLoadsContext(t *testing.T) {
+	cli, _ := NewRawClient("https://example.org", "", "", "")
+	cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+		switch {
+		case strings.Contains(req.URL.Path, "/context/"):
+			return jsonResponse(RespContext{
+				Start:        "before3",
+				End:          "after5",
+				EventsBefore: messageEvents(2, 1),
+				Event:        messageEvents(3, 3)[0],
+				EventsAfter:  messageEvents(4, 5),
+			})
+		case strings.HasSuffix(req.URL.Path, "/messages") && req.URL.Query().Get("from") == "after5":
+			return jsonResponse(gomatrix.RespMessages{Chunk: messageEvents(6, 12), End: "after12"})
+		case strings.HasSuffix(req.URL.Path, "/messages") && req.URL.Query().Get("from") == "before3":
+			return jsonResponse(gomatrix.RespMessages{})
+		}
+		t.Fatal("Unexpected request", req.URL)
+		return nil
+	})
+
+	room := &Room{Client: cli, ID: "!room:example.org", latestRoomState: *NewRoomState(cli), backPaginationToken: "before10"}
+	for _, ev := range messageEvents(10, 20) {
+		room.timeline.PushNewest(ev)
+	}
+
+	events, _, atBottomEnd, err := room.GetEventPage(context.Background(), "$3", 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := eventIDs(events); !reflect.DeepEqual(ids, []string{"$3", "$2"}) {
+		t.Error("Page mismatch expectation", ids)
+	}
+	if atBottomEnd {
+		t.Error("Page within a fragment should never be at the bottom end")
+	}
+	if len(room.fragments) != 1 {
+		t.Fatal("Expected a fragment to have been loaded", room.fragments)
+	}
+
+	// paginating forwards from the fragment meets the live timeline at $10 and merges the two.
+	if _, _, _, err = room.GetEventPage(context.Background(), "$3", -6, 2); err != nil {
+		t.Fatal(err)
+	}
+	if len(room.fragments) != 0 {
+		t.Error("Fragment should have been merged into the timeline", room.fragments)
+	}
+	if ids := eventIDs(room.timeline.Range(0, room.timeline.Len())); !reflect.DeepEqual(ids, eventIDs(messageEvents(20, 1))) {
+		t.Error("Timeline mismatch expectation", ids)
+	}
+	if !room.HasReachedHistoricEndOfTimeline {
+		t.Error("Timeline should have inherited the fragment having reached the start of the room")
+	}
+}
+
+func TestRoom_FragmentsMergeAndAreBounded(t *testing.T) {
+	cli, _ := NewRawClient("https://example.org", "", "", "")
+	cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+		n, _ := strconv.Atoi(strings.TrimPrefix(req.URL.Path[strings.LastIndex(req.URL.Path, "/")+1:], "$"))
+		return jsonResponse(RespContext{
+			Start:        "before" + strconv.Itoa(n-2),
+			End:          "after" + strconv.Itoa(n+2),
+			EventsBefore: messageEvents(n-1, n-2),
+			Event:        messageEvents(n, n)[0],
+			EventsAfter:  messageEvents(n+1, n+2),
+		})
+	})
+
+	room := &Room{Client: cli, ID: "!room:example.org", latestRoomState: *NewRoomState(cli)}
+	for _, ev := range messageEvents(1000, 1010) {
+		room.timeline.PushNewest(ev)
+	}
+	ctx := context.Background()
+
+	// the events around $14 and $6 overlap those around $10 so all three are merged.
+	for _, anchor := range []string{"$10", "$14", "$6"} {
+		if err := room.loadEventContext(ctx, anchor); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(room.fragments) != 1 {
+		t.Fatal("Overlapping fragments should have been merged", len(room.fragments))
+	}
+	f := room.fragments[0]
+	if ids := eventIDs(f.timeline.Range(0, f.timeline.Len())); !reflect.DeepEqual(ids, eventIDs(messageEvents(16, 4))) {
+		t.Error("Merged fragment mismatch expectation", ids)
+	}
+	if f.backToken != "before4" || f.forwardToken != "after16" {
+		t.Error("Merged fragment should continue from the outermost tokens", f.backToken, f.forwardToken)
+	}
+
+	for i := 1; i <= MaxFragments; i++ {
+		// using the first fragment again leaves the one after it as the least recently used.
+		if i == MaxFragments {
+			room.touchFragment(f)
+		}
+		if err := room.loadEventContext(ctx, "$"+strconv.Itoa(100*i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(room.fragments) != MaxFragments {
+		t.Error("Fragments should be bounded", len(room.fragments))
+	}
+	if !room.hasEvent("$10") || room.hasEvent("$100") || !room.hasEvent("$200") {
+		t.Error("The least recently used fragment should have been discarded")
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mxclient
+
+import (
+	"context"
+	"github.com/matrix-org/gomatrix"
+)
+
+// historyChunkSize is how many events StreamHistory fetches at once, each chunk is handed on before the next is fetched.
+const historyChunkSize = 500
+
+// StreamHistory hands fn each event of a room from the latest back to the start of its history, newest first, along with
+// the display name its sender had at the time. Events are fetched a chunk at a time by back paginating as
+// backpaginateRoom does but are never added to a Room, so the history is never held in memory as a whole.
+// It stops at the first error returned by fn or by the homeserver, fn is not called if the room cannot be read at all.
+func (m *Client) StreamHistory(ctx context.Context, roomID string, fn func(ev *gomatrix.Event, displayName string) error) error {
+	state, err := m.RoomState(ctx, roomID)
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]string)
+	for _, ev := range state {
+		if ev.Type == "m.room.member" && ev.StateKey != nil {
+			names[*ev.StateKey], _ = ev.Content["displayname"].(string)
+		}
+	}
+
+	// without a from token the first request starts at the latest event.
+	b := &Backpagination{RoomID: roomID, Limit: historyChunkSize}
+	for {
+		resp, err := m.FetchBackpagination(ctx, b)
+		if err != nil {
+			return err
+		}
+
+		for i := range resp.Chunk {
+			ev := &resp.Chunk[i]
+			name := names[ev.Sender]
+			if name == "" {
+				name = ev.Sender
+			}
+			if err := fn(ev, name); err != nil {
+				return err
+			}
+
+			// anything older was sent under whichever name the member had before this event.
+			if ev.Type == "m.room.member" && ev.StateKey != nil {
+				names[*ev.StateKey], _ = PrevContent(ev)["displayname"].(string)
+			}
+		}
+
+		if resp.End == "" {
+			return nil
+		}
+		b.From = resp.End
+	}
+}
+
+// PrevContent returns the content of the state ev replaced, which servers give either at the top level or in unsigned.
+func PrevContent(ev *gomatrix.Event) map[string]interface{} {
+	if ev.PrevContent != nil {
+		return ev.PrevContent
+	}
+	prevContent, _ := ev.Unsigned["prev_content"].(map[string]interface{})
+	return prevContent
+}
@@ -0,0 +1,51 @@
+package mxclient
+
+import (
+	"context"
+	"github.com/matrix-org/gomatrix"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClient_StreamHistory(t *testing.T) {
+	alice := "@alice:example.org"
+	pages := map[string]map[string]interface{}{
+		"": {"chunk": []gomatrix.Event{
+			{ID: "$3", Type: "m.room.message", Sender: alice},
+			{ID: "$2", Type: "m.room.member", Sender: alice, StateKey: &alice,
+				Content:  map[string]interface{}{"membership": "join", "displayname": "Alice"},
+				Unsigned: map[string]interface{}{"prev_content": map[string]interface{}{"membership": "join", "displayname": "Al"}},
+			},
+		}, "end": "t1"},
+		"t1": {"chunk": []gomatrix.Event{{ID: "$1", Type: "m.room.message", Sender: alice}}, "end": "t2"},
+		"t2": {"chunk": []gomatrix.Event{}},
+	}
+
+	cli, _ := NewRawClient("https://example.org", "", "", "")
+	cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+		if strings.HasSuffix(req.URL.Path, "/state") {
+			return jsonResponse([]gomatrix.Event{{Type: "m.room.member", StateKey: &alice,
+				Content: map[string]interface{}{"membership": "join", "displayname": "Alice"}}})
+		}
+		return jsonResponse(pages[req.URL.Query().Get("from")])
+	})
+
+	var ids, names []string
+	err := cli.StreamHistory(context.Background(), "!room", func(ev *gomatrix.Event, displayName string) error {
+		ids = append(ids, ev.ID)
+		names = append(names, displayName)
+		return nil
+	})
+	if err != nil {
+		t.Fatal("Failed streaming history", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"$3", "$2", "$1"}) {
+		t.Error("Events mismatch expectation", ids)
+	}
+	// events from before the rename are attributed to the old name.
+	if !reflect.DeepEqual(names, []string{"Alice", "Alice", "Al"}) {
+		t.Error("Display names mismatch expectation", names)
+	}
+}
@@ -40,12 +40,23 @@ func NewMXCURL(url string, baseUrl string) *MXCURL {
 	return &MXCURL{url, baseUrl}
 }
 
+// String returns the mxc:// URI itself.
+func (m *MXCURL) String() string {
+	return m.string
+}
+
 // IsValid returns a boolean of whether or not this MXCURL appears valid.
 func (m *MXCURL) IsValid() bool {
 	ok, _, _ := m.split()
 	return ok
 }
 
+// ServerNameAndMediaID returns the server name and media ID the MXCURL is made of, ok being false if it is invalid.
+func (m *MXCURL) ServerNameAndMediaID() (serverName, mediaID string, ok bool) {
+	ok, serverName, mediaID = m.split()
+	return
+}
+
 func (m *MXCURL) split() (ok bool, serverName string, mediaId string) {
 	mxc := m.string
 	matches := mxcRegex.FindStringSubmatch(mxc)
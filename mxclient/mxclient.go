@@ -15,11 +15,14 @@
 package mxclient
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"time"
@@ -46,14 +49,182 @@ type RespInitialSync struct {
 type Client struct {
 	*gomatrix.Client
 	MediaBaseURL string
+
+	// Store, if set, is where rooms are persisted to and rehydrated from.
+	Store RoomStore
+}
+
+// MakeRequestWithContext behaves like gomatrix.Client.MakeRequest but binds the HTTP request to ctx,
+// so the request is abandoned as soon as ctx is cancelled or its deadline passes.
+func (m *Client) MakeRequestWithContext(ctx context.Context, method, httpURL string, reqBody, resBody interface{}) error {
+	var buf *bytes.Buffer
+	if reqBody != nil {
+		buf = new(bytes.Buffer)
+		if err := json.NewEncoder(buf).Encode(reqBody); err != nil {
+			return err
+		}
+	}
+
+	var req *http.Request
+	var err error
+	if buf != nil {
+		req, err = http.NewRequest(method, httpURL, buf)
+	} else {
+		req, err = http.NewRequest(method, httpURL, nil)
+	}
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := m.Client.Client.Do(req)
+	if res != nil {
+		defer res.Body.Close()
+	}
+	if err != nil {
+		// prefer the context error so callers can tell a timeout apart from a transport failure.
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+
+	if res.StatusCode/100 != 2 { // not 2xx
+		contents, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return err
+		}
+
+		var wrap error
+		var respErr gomatrix.RespError
+		if _ = json.Unmarshal(contents, &respErr); respErr.ErrCode != "" {
+			wrap = respErr
+		}
+
+		msg := "Failed to " + method + " JSON to " + req.URL.Path
+		if wrap == nil {
+			msg = msg + ": " + string(contents)
+		}
+
+		return gomatrix.HTTPError{
+			Contents:     contents,
+			Code:         res.StatusCode,
+			Message:      msg,
+			WrappedError: wrap,
+		}
+	}
+
+	if resBody != nil && res.Body != nil {
+		return json.NewDecoder(res.Body).Decode(&resBody)
+	}
+	return nil
 }
 
-// Register makes an HTTP request according to http://matrix.org/docs/spec/client_server/r0.2.0.html#get-matrix-client-r0-rooms-roomid-initialsync
-func (m *Client) RoomInitialSync(roomID string, limit int) (resp *RespInitialSync, err error) {
+// RoomInitialSync makes an HTTP request according to http://matrix.org/docs/spec/client_server/r0.2.0.html#get-matrix-client-r0-rooms-roomid-initialsync
+// The endpoint is deprecated and missing from some servers so is only used as a fallback, see Client.NewRoom.
+func (m *Client) RoomInitialSync(ctx context.Context, roomID string, limit int) (resp *RespInitialSync, err error) {
 	urlPath := m.BuildURLWithQuery([]string{"rooms", roomID, "initialSync"}, map[string]string{
 		"limit": strconv.Itoa(limit),
 	})
-	err = m.MakeRequest("GET", urlPath, nil, &resp)
+	err = m.MakeRequestWithContext(ctx, "GET", urlPath, nil, &resp)
+	return
+}
+
+// RoomMessages makes an HTTP request according to http://matrix.org/docs/spec/client_server/r0.2.0.html#get-matrix-client-r0-rooms-roomid-messages
+func (m *Client) RoomMessages(ctx context.Context, roomID, from, to string, dir rune, limit int) (resp *gomatrix.RespMessages, err error) {
+	query := map[string]string{
+		"dir": string(dir),
+	}
+	// without a from token the server paginates from the start or end of the timeline, depending on dir.
+	if from != "" {
+		query["from"] = from
+	}
+	if to != "" {
+		query["to"] = to
+	}
+	if limit != 0 {
+		query["limit"] = strconv.Itoa(limit)
+	}
+
+	urlPath := m.BuildURLWithQuery([]string{"rooms", roomID, "messages"}, query)
+	err = m.MakeRequestWithContext(ctx, "GET", urlPath, nil, &resp)
+	return
+}
+
+// RoomState makes an HTTP request according to https://matrix.org/docs/spec/client_server/r0.6.0#get-matrix-client-r0-rooms-roomid-state
+func (m *Client) RoomState(ctx context.Context, roomID string) (resp []gomatrix.Event, err error) {
+	urlPath := m.BuildURL("rooms", roomID, "state")
+	err = m.MakeRequestWithContext(ctx, "GET", urlPath, nil, &resp)
+	return
+}
+
+// This is a Truncated RespContext as we only need SOME information from it.
+type RespContext struct {
+	Start        string           `json:"start"`
+	End          string           `json:"end"`
+	EventsBefore []gomatrix.Event `json:"events_before"`
+	Event        gomatrix.Event   `json:"event"`
+	EventsAfter  []gomatrix.Event `json:"events_after"`
+	// State []gomatrix.Event `json:"state"`
+}
+
+// RoomContext makes an HTTP request according to https://matrix.org/docs/spec/client_server/r0.6.0#get-matrix-client-r0-rooms-roomid-context-eventid
+func (m *Client) RoomContext(ctx context.Context, roomID, eventID string, limit int) (resp *RespContext, err error) {
+	urlPath := m.BuildURLWithQuery([]string{"rooms", roomID, "context", eventID}, map[string]string{
+		"limit": strconv.Itoa(limit),
+	})
+	err = m.MakeRequestWithContext(ctx, "GET", urlPath, nil, &resp)
+	return
+}
+
+// RoomEvent makes an HTTP request according to https://matrix.org/docs/spec/client_server/r0.6.0#get-matrix-client-r0-rooms-roomid-event-eventid
+func (m *Client) RoomEvent(ctx context.Context, roomID, eventID string) (resp *gomatrix.Event, err error) {
+	urlPath := m.BuildURL("rooms", roomID, "event", eventID)
+	err = m.MakeRequestWithContext(ctx, "GET", urlPath, nil, &resp)
+	return
+}
+
+type RespRelations struct {
+	Chunk     []gomatrix.Event `json:"chunk"`
+	NextBatch string           `json:"next_batch"`
+	PrevBatch string           `json:"prev_batch"`
+}
+
+// RoomRelations makes an HTTP request according to https://spec.matrix.org/v1.4/client-server-api/#get_matrixclientv1roomsroomidrelationseventidreltype
+// The events are returned newest first, next_batch continuing on to older ones.
+func (m *Client) RoomRelations(ctx context.Context, roomID, eventID, relType, from string, limit int) (resp *RespRelations, err error) {
+	// the endpoint only exists under the v1 prefix so cannot be built with BuildURLWithQuery.
+	u, _ := url.Parse(m.BuildBaseURL("_matrix", "client", "v1", "rooms", roomID, "relations", eventID, relType))
+	query := u.Query()
+	if from != "" {
+		query.Set("from", from)
+	}
+	if limit != 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	u.RawQuery = query.Encode()
+
+	err = m.MakeRequestWithContext(ctx, "GET", u.String(), nil, &resp)
+	return
+}
+
+type RespTimestampToEvent struct {
+	EventID        string `json:"event_id"`
+	OriginServerTS int64  `json:"origin_server_ts"`
+}
+
+// TimestampToEvent makes an HTTP request according to https://spec.matrix.org/v1.6/client-server-api/#get_matrixclientv1roomsroomidtimestamp_to_event
+// dir is 'f' for the closest event at or after ts, 'b' for the closest at or before it.
+func (m *Client) TimestampToEvent(ctx context.Context, roomID string, ts int64, dir rune) (resp *RespTimestampToEvent, err error) {
+	// the endpoint only exists under the v1 prefix so cannot be built with BuildURLWithQuery.
+	u, _ := url.Parse(m.BuildBaseURL("_matrix", "client", "v1", "rooms", roomID, "timestamp_to_event"))
+	query := u.Query()
+	query.Set("ts", strconv.FormatInt(ts, 10))
+	query.Set("dir", string(dir))
+	u.RawQuery = query.Encode()
+
+	err = m.MakeRequestWithContext(ctx, "GET", u.String(), nil, &resp)
 	return
 }
 
@@ -62,35 +233,34 @@ type RespRoomDirectoryAlias struct {
 	Servers []string `json:"servers"`
 }
 
-func (m *Client) GetRoomDirectoryAlias(roomAlias string) (resp *RespRoomDirectoryAlias, err error) {
+func (m *Client) GetRoomDirectoryAlias(ctx context.Context, roomAlias string) (resp *RespRoomDirectoryAlias, err error) {
 	urlPath := m.BuildURL("directory", "room", roomAlias)
-	err = m.MakeRequest("GET", urlPath, nil, &resp)
+	err = m.MakeRequestWithContext(ctx, "GET", urlPath, nil, &resp)
 	return
 }
 
 const minimumPagination = 64
 
-// TODO split into runs of max size recursively otherwise synapse may enforce its own limit (999?)
-func (m *Client) backpaginateRoom(room *Room, amount int) (int, error) {
+func (m *Client) backpaginateRoom(ctx context.Context, room *Room, amount int) (int, error) {
 	loggerWithFields := log.WithField("roomID", room.ID).WithField("amount", amount)
 	loggerWithFields.Info("Backpaginating Room")
 
-	amount = utils.Max(amount, minimumPagination)
-	resp, err := m.Messages(room.ID, room.backPaginationToken, "", 'b', amount)
+	b := &Backpagination{room.ID, room.backPaginationToken, utils.Max(amount, minimumPagination)}
+	resp, err := m.FetchBackpagination(ctx, b)
 
 	if err != nil {
 		loggerWithFields.WithError(err).Error("Failed Backpaginating Room")
 		return -1, err
 	}
 
-	room.concatBackpagination(resp.Chunk, resp.End)
+	room.ApplyBackpagination(b, resp)
 	loggerWithFields.Info("Finished Backpaginating Room")
 	return len(resp.Chunk), nil
 }
 
-func (m *Client) forwardpaginateRoom(room *Room, amount int) (int, error) {
+func (m *Client) forwardpaginateRoom(ctx context.Context, room *Room, amount int) (int, error) {
 	amount = utils.Max(amount, minimumPagination)
-	resp, err := m.Messages(room.ID, room.forwardPaginationToken, "", 'f', amount)
+	resp, err := m.RoomMessages(ctx, room.ID, room.forwardPaginationToken, "", 'f', amount)
 
 	if err != nil {
 		return -1, err
@@ -107,7 +277,7 @@ func NewRawClient(homeserverURL, mediaBaseURL, userID, accessToken string) (*Cli
 	cli.Client = &http.Client{
 		Timeout: 30 * time.Second,
 	}
-	return &Client{cli, mediaBaseURL}, err
+	return &Client{Client: cli, MediaBaseURL: mediaBaseURL}, err
 }
 
 // The struct representing the json config file format.
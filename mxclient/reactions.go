@@ -0,0 +1,71 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mxclient
+
+import "github.com/matrix-org/gomatrix"
+
+// IsReaction returns whether ev annotates another event with a key, typically an emoji.
+func IsReaction(ev gomatrix.Event) bool {
+	relType, eventID := relation(ev)
+	return ev.Type == "m.reaction" && relType == "m.annotation" && eventID != "" && reactionKey(ev) != ""
+}
+
+func reactionKey(ev gomatrix.Event) string {
+	relatesTo, _ := ev.Content["m.relates_to"].(map[string]interface{})
+	key, _ := relatesTo["key"].(string)
+	return key
+}
+
+// ReactionGroup is the aggregation of all reactions to an event with the same key.
+type ReactionGroup struct {
+	Key   string
+	Count int
+	// Senders are the MXIDs of the members who reacted, in the order they did so.
+	Senders []string
+}
+
+// Reactions aggregates the reactions to each of events by key, in the order each key was first used.
+// Events without reactions are omitted.
+func (r *Room) Reactions(events []gomatrix.Event) map[string][]ReactionGroup {
+	reactions := make(map[string][]ReactionGroup)
+	for _, ev := range events {
+		var groups []ReactionGroup
+		// members may only react with each key once.
+		seen := make(map[[2]string]bool)
+
+		for _, reaction := range r.relatedEvents(ev.ID, "m.annotation") {
+			key := reactionKey(reaction)
+			if seen[[2]string{key, reaction.Sender}] {
+				continue
+			}
+			seen[[2]string{key, reaction.Sender}] = true
+
+			i := 0
+			for i < len(groups) && groups[i].Key != key {
+				i++
+			}
+			if i == len(groups) {
+				groups = append(groups, ReactionGroup{Key: key})
+			}
+			groups[i].Count++
+			groups[i].Senders = append(groups[i].Senders, reaction.Sender)
+		}
+
+		if len(groups) > 0 {
+			reactions[ev.ID] = groups
+		}
+	}
+	return reactions
+}
@@ -0,0 +1,51 @@
+package mxclient
+
+import (
+	"github.com/matrix-org/gomatrix"
+	"reflect"
+	"testing"
+)
+
+func makeReaction(id, sender, targetID, key string) gomatrix.Event {
+	return gomatrix.Event{
+		ID:     id,
+		Type:   "m.reaction",
+		Sender: sender,
+		Content: map[string]interface{}{
+			"m.relates_to": map[string]interface{}{"rel_type": "m.annotation", "event_id": targetID, "key": key},
+		},
+	}
+}
+
+func TestRoom_Reactions(t *testing.T) {
+	message := gomatrix.Event{ID: "$1", Type: "m.room.message", Content: map[string]interface{}{"msgtype": "m.text", "body": "hi"}}
+
+	room := &Room{latestRoomState: *NewRoomState(nil)}
+	room.concatForwardPagination([]gomatrix.Event{
+		message,
+		makeReaction("$r1", "@alice:example.org", "$1", "👍"),
+		makeReaction("$r2", "@bob:example.org", "$1", "🎉"),
+		makeReaction("$r3", "@bob:example.org", "$1", "👍"),
+		makeReaction("$r4", "@bob:example.org", "$1", "👍"),
+	}, "forward")
+
+	if room.timeline.Len() != 1 {
+		t.Fatal("Reactions should be kept out of the timeline", room.timeline.Len())
+	}
+
+	expected := map[string][]ReactionGroup{"$1": {
+		{Key: "👍", Count: 2, Senders: []string{"@alice:example.org", "@bob:example.org"}},
+		{Key: "🎉", Count: 1, Senders: []string{"@bob:example.org"}},
+	}}
+	if reactions := room.Reactions([]gomatrix.Event{message}); !reflect.DeepEqual(reactions, expected) {
+		t.Error("Reactions mismatch expectation", reactions)
+	}
+
+	room.concatForwardPagination([]gomatrix.Event{{ID: "$x", Type: "m.room.redaction", Redacts: "$r2"}}, "forward")
+	expected = map[string][]ReactionGroup{"$1": {
+		{Key: "👍", Count: 2, Senders: []string{"@alice:example.org", "@bob:example.org"}},
+	}}
+	if reactions := room.Reactions([]gomatrix.Event{message}); !reflect.DeepEqual(reactions, expected) {
+		t.Error("Redacted reactions should be subtracted", reactions)
+	}
+}
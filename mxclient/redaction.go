@@ -0,0 +1,128 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mxclient
+
+import (
+	"github.com/matrix-org/gomatrix"
+	"strconv"
+)
+
+// redactionVersion returns which room version's redaction algorithm applies to roomVersion. Room versions are opaque
+// strings, anything unrecognised is treated as the newest algorithm we know.
+func redactionVersion(roomVersion string) int {
+	version, err := strconv.Atoi(roomVersion)
+	if roomVersion == "" {
+		return 1
+	} else if err != nil {
+		return 11
+	}
+	return version
+}
+
+// preservedContentKeys returns which content keys of an event of type evType survive redaction in the given room version.
+// Implements https://spec.matrix.org/v1.8/rooms/v11/#redactions and its predecessors, bar the signed part of a
+// third_party_invite which RedactEvent preserves itself.
+func preservedContentKeys(evType, roomVersion string) []string {
+	version := redactionVersion(roomVersion)
+
+	switch evType {
+	case "m.room.member":
+		if version >= 9 {
+			return []string{"membership", "join_authorised_via_users_server"}
+		}
+		return []string{"membership"}
+	case "m.room.create":
+		if version >= 11 {
+			return nil // the entire content is preserved
+		}
+		return []string{"creator"}
+	case "m.room.join_rules":
+		if version >= 8 {
+			return []string{"join_rule", "allow"}
+		}
+		return []string{"join_rule"}
+	case "m.room.power_levels":
+		keys := []string{"ban", "events", "events_default", "kick", "redact", "state_default", "users", "users_default"}
+		if version >= 11 {
+			keys = append(keys, "invite")
+		}
+		return keys
+	case "m.room.aliases":
+		if version <= 5 {
+			return []string{"aliases"}
+		}
+	case "m.room.history_visibility":
+		return []string{"history_visibility"}
+	case "m.room.redaction":
+		if version >= 11 {
+			return []string{"redacts"}
+		}
+	}
+	return []string{}
+}
+
+// RedactEvent returns a copy of ev stripped according to the redaction algorithm of roomVersion,
+// with unsigned.redacted_because set to the redaction event as a server would.
+func RedactEvent(ev gomatrix.Event, redaction gomatrix.Event, roomVersion string) gomatrix.Event {
+	if keys := preservedContentKeys(ev.Type, roomVersion); keys != nil {
+		content := make(map[string]interface{}, len(keys))
+		for _, key := range keys {
+			if value, ok := ev.Content[key]; ok {
+				content[key] = value
+			}
+		}
+		// from room version 11 only the signed part of a member's third party invite survives.
+		if ev.Type == "m.room.member" && redactionVersion(roomVersion) >= 11 {
+			if invite, ok := ev.Content["third_party_invite"].(map[string]interface{}); ok {
+				if signed, ok := invite["signed"]; ok {
+					content["third_party_invite"] = map[string]interface{}{"signed": signed}
+				}
+			}
+		}
+		ev.Content = content
+	}
+
+	// replace rather than modify the map as it may be shared with copies of the event handed out already.
+	unsigned := make(map[string]interface{}, len(ev.Unsigned)+1)
+	for key, value := range ev.Unsigned {
+		unsigned[key] = value
+	}
+	unsigned["redacted_because"] = redaction
+	ev.Unsigned = unsigned
+
+	return ev
+}
+
+// IsRedacted returns whether the event has been redacted, either by the server before we received it or by us since.
+func IsRedacted(ev *gomatrix.Event) bool {
+	_, ok := ev.Unsigned["redacted_because"]
+	return ok
+}
+
+// RedactionReason returns the reason given for redacting ev, if any.
+func RedactionReason(ev *gomatrix.Event) string {
+	switch because := ev.Unsigned["redacted_because"].(type) {
+	case gomatrix.Event:
+		reason, _ := because.Content["reason"].(string)
+		return reason
+	case map[string]interface{}:
+		// redacted_because of events redacted by the server is plain JSON.
+		if content, ok := because["content"].(map[string]interface{}); ok {
+			reason, _ := content["reason"].(string)
+			return reason
+		}
+	}
+	return ""
+}
@@ -0,0 +1,148 @@
+package mxclient
+
+import (
+	"github.com/matrix-org/gomatrix"
+	"reflect"
+	"testing"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestRedactEvent(t *testing.T) {
+	redaction := gomatrix.Event{ID: "$redaction", Type: "m.room.redaction", Content: map[string]interface{}{"reason": "spam"}}
+
+	tests := []struct {
+		name        string
+		ev          gomatrix.Event
+		roomVersion string
+		exp         map[string]interface{}
+	}{
+		{
+			"message content is stripped entirely",
+			gomatrix.Event{Type: "m.room.message", Content: map[string]interface{}{"msgtype": "m.text", "body": "secret"}},
+			"5",
+			map[string]interface{}{},
+		}, {
+			"membership survives but profile does not",
+			gomatrix.Event{Type: "m.room.member", StateKey: strPtr("@a:b"), Content: map[string]interface{}{"membership": "join", "displayname": "A"}},
+			"",
+			map[string]interface{}{"membership": "join"},
+		}, {
+			"aliases are only preserved up to room version 5",
+			gomatrix.Event{Type: "m.room.aliases", StateKey: strPtr("b"), Content: map[string]interface{}{"aliases": []interface{}{"#a:b"}}},
+			"6",
+			map[string]interface{}{},
+		}, {
+			"join rule allow list is preserved from room version 8",
+			gomatrix.Event{Type: "m.room.join_rules", StateKey: strPtr(""), Content: map[string]interface{}{"join_rule": "restricted", "allow": "x", "other": "y"}},
+			"8",
+			map[string]interface{}{"join_rule": "restricted", "allow": "x"},
+		}, {
+			"only the signed part of a third party invite is preserved from room version 11",
+			gomatrix.Event{Type: "m.room.member", StateKey: strPtr("@a:b"), Content: map[string]interface{}{
+				"membership":         "invite",
+				"third_party_invite": map[string]interface{}{"display_name": "A", "signed": map[string]interface{}{"token": "t"}},
+			}},
+			"11",
+			map[string]interface{}{"membership": "invite", "third_party_invite": map[string]interface{}{"signed": map[string]interface{}{"token": "t"}}},
+		}, {
+			"third party invites are not preserved before room version 11",
+			gomatrix.Event{Type: "m.room.member", StateKey: strPtr("@a:b"), Content: map[string]interface{}{
+				"membership":         "invite",
+				"third_party_invite": map[string]interface{}{"display_name": "A", "signed": map[string]interface{}{"token": "t"}},
+			}},
+			"10",
+			map[string]interface{}{"membership": "invite"},
+		}, {
+			"create content is entirely preserved from room version 11",
+			gomatrix.Event{Type: "m.room.create", StateKey: strPtr(""), Content: map[string]interface{}{"creator": "@a:b", "m.federate": false}},
+			"11",
+			map[string]interface{}{"creator": "@a:b", "m.federate": false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			redacted := RedactEvent(tt.ev, redaction, tt.roomVersion)
+			if !reflect.DeepEqual(redacted.Content, tt.exp) {
+				t.Error("Content mismatch expectation", redacted.Content, tt.exp)
+			}
+			if !IsRedacted(&redacted) {
+				t.Error("Redacted event should be marked as such")
+			}
+			if reason := RedactionReason(&redacted); reason != "spam" {
+				t.Error("Redaction reason mismatch expectation", reason)
+			}
+			if IsRedacted(&tt.ev) {
+				t.Error("Original event should not have been modified")
+			}
+		})
+	}
+}
+
+func TestRoom_concatForwardPaginationRedacts(t *testing.T) {
+	room := &Room{latestRoomState: *NewRoomState(nil)}
+	room.concatForwardPagination([]gomatrix.Event{
+		{ID: "$msg", Type: "m.room.message", Content: map[string]interface{}{"msgtype": "m.text", "body": "secret"}},
+		{ID: "$other", Type: "m.room.message", Content: map[string]interface{}{"msgtype": "m.text", "body": "fine"}},
+	}, "token1")
+	room.concatForwardPagination([]gomatrix.Event{
+		{ID: "$redaction", Type: "m.room.redaction", Redacts: "$msg"},
+	}, "token2")
+
+	if room.timeline.Len() != 2 {
+		t.Fatal("Redaction should not be added to the timeline", room.timeline.Range(0, room.timeline.Len()))
+	}
+	if ev := room.timeline.At(1); !IsRedacted(&ev) || ev.Content["body"] != nil {
+		t.Error("Targeted event should have been redacted", ev)
+	}
+	if ev := room.timeline.At(0); IsRedacted(&ev) {
+		t.Error("Other events should not have been redacted", ev)
+	}
+}
+
+func TestRoom_applyRedactionUpdatesState(t *testing.T) {
+	cli, _ := NewRawClient("https://example.org", "https://example.org", "", "")
+	room := &Room{Client: cli, latestRoomState: *NewRoomState(cli)}
+	withID := func(ev gomatrix.Event, id string) gomatrix.Event {
+		ev.ID = id
+		return ev
+	}
+	room.concatForwardPagination([]gomatrix.Event{
+		withID(stateEvent("m.room.name", "", map[string]interface{}{"name": "Older"}), "$oldName"),
+		withID(stateEvent("m.room.name", "", map[string]interface{}{"name": "Name"}), "$name"),
+		withID(stateEvent("m.room.topic", "", map[string]interface{}{"topic": "Topic"}), "$topic"),
+		withID(stateEvent("m.room.avatar", "", map[string]interface{}{"url": "mxc://example.org/avatar"}), "$avatar"),
+		withID(stateEvent("m.room.member", "@alice:example.org", map[string]interface{}{
+			"membership": "join", "displayname": "Alice", "avatar_url": "mxc://example.org/alice",
+		}), "$alice"),
+	}, "token1")
+
+	// a redaction of state which has since been replaced changes nothing.
+	room.concatForwardPagination([]gomatrix.Event{{ID: "$r1", Type: "m.room.redaction", Redacts: "$oldName"}}, "token2")
+	if state := room.GetState(); state.Name != "Name" {
+		t.Fatal("Replaced state should not be affected by its redaction", state.Name)
+	}
+
+	// the state survives persisting the room.
+	room.latestRoomState = newRoomStateFromStored(cli, room.latestRoomState.toStored())
+	room.concatForwardPagination([]gomatrix.Event{
+		{ID: "$r2", Type: "m.room.redaction", Redacts: "$name"},
+		{ID: "$r3", Type: "m.room.redaction", Redacts: "$topic"},
+		{ID: "$r4", Type: "m.room.redaction", Redacts: "$avatar"},
+		{ID: "$r5", Type: "m.room.redaction", Redacts: "$alice"},
+	}, "token3")
+
+	state := room.GetState()
+	if state.Name != "" || state.Topic != "" || state.AvatarURL.IsValid() {
+		t.Error("Redacted state should have been forgotten", state.Name, state.Topic, state.AvatarURL)
+	}
+	member := state.MemberMap["@alice:example.org"]
+	if member.DisplayName != "" || member.AvatarURL.IsValid() || member.Membership != "join" {
+		t.Error("Only the membership of a redacted member event should survive", member)
+	}
+	if members := state.Members(); len(members) != 1 {
+		t.Error("Member should remain joined", members)
+	}
+}
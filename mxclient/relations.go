@@ -0,0 +1,140 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mxclient
+
+import (
+	"github.com/matrix-org/gomatrix"
+	"sort"
+)
+
+// relation returns the type of relation ev has to another event and the ID of that event, if any.
+func relation(ev gomatrix.Event) (relType, eventID string) {
+	relatesTo, _ := ev.Content["m.relates_to"].(map[string]interface{})
+	relType, _ = relatesTo["rel_type"].(string)
+	eventID, _ = relatesTo["event_id"].(string)
+	return
+}
+
+// acceptEvent records ev if it is shown alongside the event it relates to rather than in the timeline, as edits,
+// reactions and thread replies are, returning whether it belongs in the timeline.
+func (r *Room) acceptEvent(ev gomatrix.Event) bool {
+	if IsEdit(ev) || IsReaction(ev) || IsThreadReply(ev) {
+		r.addRelation(ev)
+		return false
+	}
+	return !ShouldHideEvent(ev)
+}
+
+// addRelation records ev against the event it relates to, unless it is already known.
+func (r *Room) addRelation(ev gomatrix.Event) {
+	if _, known := r.relationTargets[ev.ID]; known {
+		return
+	}
+
+	_, targetID := relation(ev)
+	if r.relations == nil {
+		r.relations = make(map[string][]gomatrix.Event)
+		r.relationTargets = make(map[string]string)
+	}
+	// keep the relations in the order they were sent, so that the latest edit comes last.
+	related := append(r.relations[targetID], ev)
+	sort.SliceStable(related, func(i, j int) bool {
+		if related[i].Timestamp != related[j].Timestamp {
+			return related[i].Timestamp < related[j].Timestamp
+		}
+		return related[i].ID < related[j].ID
+	})
+	r.relations[targetID] = related
+	r.relationTargets[ev.ID] = targetID
+	r.changedRelation(ev.ID, true)
+	if IsEdit(ev) {
+		r.reindexSearch(targetID)
+	}
+}
+
+// removeRelation forgets the related event with the given ID, for when it is redacted, returning whether it was known.
+func (r *Room) removeRelation(eventID string) bool {
+	targetID, found := r.relationTargets[eventID]
+	if !found {
+		return false
+	}
+
+	related := r.relations[targetID]
+	for i, ev := range related {
+		if ev.ID != eventID {
+			continue
+		}
+		if len(related) == 1 {
+			delete(r.relations, targetID)
+		} else {
+			r.relations[targetID] = append(related[:i:i], related[i+1:]...)
+		}
+		break
+	}
+	delete(r.relationTargets, eventID)
+	r.changedRelation(eventID, false)
+	// the event may have been an edit, which no longer applies.
+	r.reindexSearch(targetID)
+	return true
+}
+
+// relationTarget returns the ID of the event which the known related event with the given ID relates to.
+func (r *Room) relationTarget(eventID string) (targetID string, found bool) {
+	targetID, found = r.relationTargets[eventID]
+	return
+}
+
+// relatedEvent returns the known related event with the given ID.
+func (r *Room) relatedEvent(eventID string) (gomatrix.Event, bool) {
+	for _, ev := range r.relations[r.relationTargets[eventID]] {
+		if ev.ID == eventID {
+			return ev, true
+		}
+	}
+	return gomatrix.Event{}, false
+}
+
+// relatedEvents returns the events with the given type of relation to targetID, oldest first.
+func (r *Room) relatedEvents(targetID, relType string) []gomatrix.Event {
+	var related []gomatrix.Event
+	for _, ev := range r.relations[targetID] {
+		if t, _ := relation(ev); t == relType {
+			related = append(related, ev)
+		}
+	}
+	return related
+}
+
+// locateAnchor is locateEvent except that as related events are kept out of the timeline, the event they relate to is
+// located in their place, updating eventID to match.
+func (r *Room) locateAnchor(eventID *string) (fragment *eventFragment, index int, found bool) {
+	if targetID, isRelated := r.relationTarget(*eventID); isRelated {
+		*eventID = targetID
+	}
+	return r.locateEvent(*eventID)
+}
+
+// pruneRelations forgets the related events of events which are no longer held in memory.
+func (r *Room) pruneRelations() {
+	for targetID, related := range r.relations {
+		if !r.hasEvent(targetID) {
+			delete(r.relations, targetID)
+			for _, ev := range related {
+				delete(r.relationTargets, ev.ID)
+				r.changedRelation(ev.ID, false)
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mxclient
+
+import "github.com/matrix-org/gomatrix"
+
+// InReplyTo returns the ID of the event which ev is a reply to, if any.
+// Thread replies quote the previous reply only for clients without thread support, so are not treated as replies.
+func InReplyTo(ev *gomatrix.Event) string {
+	relatesTo, _ := ev.Content["m.relates_to"].(map[string]interface{})
+	if fallingBack, _ := relatesTo["is_falling_back"].(bool); fallingBack && relatesTo["rel_type"] == "m.thread" {
+		return ""
+	}
+	inReplyTo, _ := relatesTo["m.in_reply_to"].(map[string]interface{})
+	eventID, _ := inReplyTo["event_id"].(string)
+	return eventID
+}
+
+// findEvent returns the event with the given ID from anywhere in memory, as it was sent.
+func (r *Room) findEvent(eventID string) (gomatrix.Event, bool) {
+	if fragment, index, found := r.locateEvent(eventID); found {
+		if fragment != nil {
+			return fragment.timeline.At(index), true
+		}
+		return r.timeline.At(index), true
+	}
+	if ev, found := r.relatedEvent(eventID); found {
+		return ev, true
+	}
+	// events which could not be fetched are cached without an ID, see CacheReplyParents.
+	ev, found := r.replyParents[eventID]
+	return ev, found && ev.ID != ""
+}
+
+// ReplyParents returns the events which the replies among events are replies to by their ID, edited as they would be
+// in the timeline, as well as the IDs of those which are not held in memory, see CacheReplyParents. Those which could
+// not be fetched before are neither returned nor reported missing.
+func (r *Room) ReplyParents(events []gomatrix.Event) (parents map[string]gomatrix.Event, missing []string) {
+	parents = make(map[string]gomatrix.Event)
+	for _, ev := range events {
+		parentID := InReplyTo(&ev)
+		if parentID == "" {
+			continue
+		}
+		if _, seen := parents[parentID]; seen {
+			continue
+		}
+
+		// replies within a page often quote another event on it, which need not be in memory, e.g. in threads.
+		parent, found := r.findEvent(parentID)
+		for i := 0; !found && i < len(events); i++ {
+			parent, found = events[i], events[i].ID == parentID
+		}
+		if _, failed := r.replyParents[parentID]; !found && failed {
+			continue
+		}
+		if !found {
+			// record the missing ID as seen so that it is only reported once.
+			parents[parentID] = gomatrix.Event{}
+			missing = append(missing, parentID)
+			continue
+		}
+		parents[parentID] = r.WithEdits([]gomatrix.Event{parent})[0]
+	}
+
+	for _, parentID := range missing {
+		delete(parents, parentID)
+	}
+	return
+}
+
+// CacheReplyParents remembers events fetched as the parents of replies, as well as the IDs of those which could not be
+// fetched so that they are not asked for again, until the room is next trimmed.
+func (r *Room) CacheReplyParents(fetched []gomatrix.Event, failed []string) {
+	if r.replyParents == nil {
+		r.replyParents = make(map[string]gomatrix.Event)
+	}
+	for _, ev := range fetched {
+		r.replyParents[ev.ID] = ev
+	}
+	for _, eventID := range failed {
+		r.replyParents[eventID] = gomatrix.Event{}
+	}
+}
+
+// CachedReplyParents returns those of the given events which are held in memory by their ID, edited as they would be in
+// the timeline.
+func (r *Room) CachedReplyParents(eventIDs []string) map[string]gomatrix.Event {
+	var events []gomatrix.Event
+	for _, eventID := range eventIDs {
+		if ev, found := r.findEvent(eventID); found {
+			events = append(events, ev)
+		}
+	}
+
+	parents := make(map[string]gomatrix.Event)
+	for _, ev := range r.WithEdits(events) {
+		parents[ev.ID] = ev
+	}
+	return parents
+}
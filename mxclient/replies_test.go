@@ -0,0 +1,65 @@
+package mxclient
+
+import (
+	"github.com/matrix-org/gomatrix"
+	"reflect"
+	"testing"
+)
+
+func makeReply(id, parentID string) gomatrix.Event {
+	return gomatrix.Event{
+		ID:   id,
+		Type: "m.room.message",
+		Content: map[string]interface{}{
+			"msgtype":      "m.text",
+			"body":         "> <@alice:example.org> hi\n\nreply",
+			"m.relates_to": map[string]interface{}{"m.in_reply_to": map[string]interface{}{"event_id": parentID}},
+		},
+	}
+}
+
+func TestRoom_ReplyParents(t *testing.T) {
+	room := &Room{latestRoomState: *NewRoomState(nil)}
+	room.concatForwardPagination([]gomatrix.Event{
+		{ID: "$1", Type: "m.room.message", Sender: "@alice:example.org", Content: map[string]interface{}{"msgtype": "m.text", "body": "hi"}},
+		makeEdit("$e1", "@alice:example.org", "$1", "hello", 1),
+		makeReply("$2", "$1"),
+		makeReply("$3", "$missing"),
+		makeReply("$4", "$missing"),
+	}, "forward")
+
+	// a thread reply quotes the previous reply only for clients without thread support.
+	threadReply := makeThreadReply("$t1", "$1")
+	threadReply.Content["m.relates_to"].(map[string]interface{})["is_falling_back"] = true
+	threadReply.Content["m.relates_to"].(map[string]interface{})["m.in_reply_to"] = map[string]interface{}{"event_id": "$2"}
+
+	events := []gomatrix.Event{room.timeline.At(2), room.timeline.At(1), room.timeline.At(0), threadReply, makeReply("$5", "$t1")}
+	parents, missing := room.ReplyParents(events)
+	if ids := []string{parents["$1"].ID, parents["$t1"].ID}; len(parents) != 2 || !reflect.DeepEqual(ids, []string{"$1", "$t1"}) {
+		t.Error("Parents mismatch expectation", parents)
+	}
+	if parents["$1"].Content["body"] != "hello" {
+		t.Error("Parents should be edited as they would be in the timeline", parents["$1"].Content)
+	}
+	if !reflect.DeepEqual(missing, []string{"$missing"}) {
+		t.Error("Missing parents mismatch expectation", missing)
+	}
+
+	room.CacheReplyParents(nil, []string{"$missing"})
+	if parents, missing := room.ReplyParents(events); len(parents) != 2 || len(missing) != 0 {
+		t.Error("Parents which could not be fetched should be neither found nor missing", parents, missing)
+	}
+	if parents := room.CachedReplyParents([]string{"$missing", "$1"}); len(parents) != 1 || parents["$1"].Content["body"] != "hello" {
+		t.Error("Cached parents mismatch expectation", parents)
+	}
+
+	room.CacheReplyParents([]gomatrix.Event{{ID: "$missing", Type: "m.room.message"}}, nil)
+	if parents, missing := room.ReplyParents(events); len(parents) != 3 || len(missing) != 0 {
+		t.Error("Cached parents should be found", parents, missing)
+	}
+
+	room.concatForwardPagination([]gomatrix.Event{{ID: "$r", Type: "m.room.redaction", Redacts: "$missing"}}, "forward")
+	if _, missing := room.ReplyParents(events); len(missing) != 1 {
+		t.Error("Redacted parents should be fetched again", missing)
+	}
+}
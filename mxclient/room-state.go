@@ -45,10 +45,25 @@ type RoomState struct {
 	aliasMap       map[string][]string
 	Aliases        RoomAliases
 
+	// the room this one was upgraded from and the last event in it, and the room this one was upgraded to.
+	predecessorRoomID  string
+	predecessorEventID string
+	successorRoomID    string
+
 	PowerLevels PowerLevels
 	serverList  []ServerUserCount
 	memberList  []*MemberInfo
 	MemberMap   map[string]*MemberInfo
+
+	// eventIDs holds the ID of the event setting each piece of current state by its type and state key, so that the
+	// state can be updated should that event be redacted, see UpdateOnRedaction.
+	eventIDs map[stateKeyTuple]string
+}
+
+// stateKeyTuple identifies a piece of room state.
+type stateKeyTuple struct {
+	Type     string
+	StateKey string
 }
 
 // NewRoomState creates a RoomState with defaults applied.
@@ -57,6 +72,7 @@ func NewRoomState(client *Client) *RoomState {
 		client:    client,
 		MemberMap: make(map[string]*MemberInfo),
 		aliasMap:  make(map[string][]string),
+		eventIDs:  make(map[stateKeyTuple]string),
 	}
 }
 
@@ -77,6 +93,9 @@ func (rs *RoomState) UpdateOnEvent(event *gomatrix.Event, usePrevContent bool) {
 	}
 
 	stateKey := *event.StateKey
+	if event.ID != "" {
+		rs.eventIDs[stateKeyTuple{event.Type, stateKey}] = event.ID
+	}
 
 	switch event.Type {
 	case "m.room.aliases":
@@ -105,6 +124,10 @@ func (rs *RoomState) UpdateOnEvent(event *gomatrix.Event, usePrevContent bool) {
 		if roomVer, ok := event.Content["room_version"].(string); ok {
 			rs.roomVersion = roomVer
 		}
+		if predecessor, ok := event.Content["predecessor"].(map[string]interface{}); ok {
+			rs.predecessorRoomID, _ = predecessor["room_id"].(string)
+			rs.predecessorEventID, _ = predecessor["event_id"].(string)
+		}
 	case "m.room.join_rules": // We do not (yet) care about m.room.join_rules
 	case "m.room.member":
 		var currentMemberState *MemberInfo
@@ -157,7 +180,50 @@ func (rs *RoomState) UpdateOnEvent(event *gomatrix.Event, usePrevContent bool) {
 		if url, ok := event.Content["url"].(string); ok {
 			rs.AvatarURL = *NewMXCURL(url, rs.client.MediaBaseURL)
 		}
+	case "m.room.tombstone":
+		// the tombstone may be replaced by one without a replacement room to undo an upgrade.
+		rs.successorRoomID, _ = event.Content["replacement_room"].(string)
+	}
+}
+
+// UpdateOnRedaction forgets what the event with ID eventID set of the room state and does not survive its redaction,
+// returning whether it did so. Nothing changes unless the event sets the current state of its type and state key.
+func (rs *RoomState) UpdateOnRedaction(eventID string) bool {
+	for key, id := range rs.eventIDs {
+		if id != eventID {
+			continue
+		}
+
+		version := redactionVersion(rs.roomVersion)
+		switch key.Type {
+		case "m.room.aliases":
+			if version > 5 {
+				delete(rs.aliasMap, key.StateKey)
+			}
+		case "m.room.canonical_alias":
+			rs.canonicalAlias = ""
+		case "m.room.member":
+			// only the membership survives.
+			if member := rs.MemberMap[key.StateKey]; member != nil {
+				member.DisplayName = ""
+				member.AvatarURL = MXCURL{}
+			}
+		case "m.room.power_levels":
+			if version < 11 {
+				rs.PowerLevels.Invite = 0
+			}
+		case "m.room.name":
+			rs.Name = ""
+		case "m.room.topic":
+			rs.Topic = ""
+		case "m.room.avatar":
+			rs.AvatarURL = MXCURL{}
+		case "m.room.tombstone":
+			rs.successorRoomID = ""
+		}
+		return true
 	}
+	return false
 }
 
 // RecalculateMemberListAndServers does member list calculation, sorting and server calculations.
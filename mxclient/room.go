@@ -15,7 +15,9 @@
 package mxclient
 
 import (
+	"context"
 	"errors"
+	log "github.com/Sirupsen/logrus"
 	"github.com/matrix-org/gomatrix"
 	"github.com/matrix-org/matrix-static/utils"
 	"time"
@@ -31,6 +33,12 @@ type RoomInfo struct {
 	NumMemberEvents int
 	NumMembers      int
 	NumServers      int
+
+	// PredecessorRoomID is the room this one was upgraded from, and PredecessorEventID the last event in it.
+	PredecessorRoomID  string
+	PredecessorEventID string
+	// SuccessorRoomID is the room this one was upgraded to.
+	SuccessorRoomID string
 }
 
 type Room struct {
@@ -41,29 +49,102 @@ type Room struct {
 
 	backPaginationToken    string
 	forwardPaginationToken string
+	// backTokens records each back pagination token we were given alongside which event it continues from, so that
+	// the timeline can later be trimmed back to one of them.
+	backTokens []backToken
+
+	// position 0 of the timeline is the latest event we know
+	timeline timeline
+	// fragments of older history which have been loaded around permalinks but are yet to meet the timeline.
+	fragments []*eventFragment
+	// relations holds the events kept out of the timeline by the ID of the event they relate to, see acceptEvent.
+	relations map[string][]gomatrix.Event
+	// relationTargets maps the ID of each event in relations to the ID of the event it relates to.
+	relationTargets map[string]string
+	// replyParents holds events fetched for being replied to which are not otherwise in memory, see CacheReplyParents.
+	replyParents map[string]gomatrix.Event
 
-	// eventList[0] is the latest event we know
-	eventList []gomatrix.Event
-	//eventMap        map[string]*gomatrix.Event
 	latestRoomState RoomState
 
 	HasReachedHistoricEndOfTimeline bool
 
+	// whether this room has been brought up to date by /sync at least once, see ApplySync.
+	synced bool
+	// whether this room has changed since it was last persisted, see Persist.
+	dirty bool
+	saved savedRoom
+	// searchIndex is built from the timeline when first searched then kept up to date with it, see SearchLocal.
+	searchIndex *searchIndex
+
 	LastAccess time.Time
 }
 
+// markDirty records that the room has changed, so needs persisting again.
+func (r *Room) markDirty() {
+	r.dirty = true
+}
+
 func (r *Room) Access() {
 	r.LastAccess = time.Now()
 }
 
-// ForwardPaginateRoom queries the API for any events newer than the latest one currently in the timeline and appends them.
-func (r *Room) ForwardPaginateRoom() {
-	r.Client.forwardpaginateRoom(r, 0)
+// maxGapFillPaginations bounds how many /messages requests filling a single gap in the timeline may take.
+const maxGapFillPaginations = 10
+
+// fillForwardGap forward paginates until we have caught up with the server or it stops responding,
+// returning whether we did catch up.
+func (r *Room) fillForwardGap(ctx context.Context) (caughtUp bool) {
+	for i := 0; i < maxGapFillPaginations; i++ {
+		numNew, err := r.Client.forwardpaginateRoom(ctx, r, 0)
+		if err != nil {
+			return false
+		}
+		if numNew == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// NeededSyncForwardpagination returns the forward pagination filling the gap the server left before the events of a
+// /sync response, either because its timeline was limited or because this is the first sync since the room was
+// loaded, or nil if there is none. It is to be fetched and applied before the response, see ApplySync.
+func (r *Room) NeededSyncForwardpagination(limited bool) *Forwardpagination {
+	if (!limited && r.synced) || r.forwardPaginationToken == "" {
+		return nil
+	}
+	return &Forwardpagination{r.ID, r.forwardPaginationToken}
+}
+
+// ApplySync brings the room up to date with its portion of a /sync response, any gap before which must have been
+// filled beforehand, see NeededSyncForwardpagination.
+func (r *Room) ApplySync(sync SyncJoinedRoom, nextBatch string) {
+	r.synced = true
+
+	for _, event := range sync.State.Events {
+		r.latestRoomState.UpdateOnEvent(&event, false)
+		r.saved.state = true
+	}
+
+	// events may already be known to us if gap filling raced ahead of the sync position.
+	newEvents := make([]gomatrix.Event, 0, len(sync.Timeline.Events))
+	for _, event := range sync.Timeline.Events {
+		if !r.hasEvent(event.ID) {
+			newEvents = append(newEvents, event)
+		}
+	}
+
+	r.concatForwardPagination(newEvents, nextBatch)
+}
+
+func (r *Room) hasEvent(eventID string) bool {
+	_, _, found := r.locateEvent(eventID)
+	return found
 }
 
 func (r *Room) concatBackpagination(oldEvents []gomatrix.Event, newToken string) {
 	for _, event := range oldEvents {
-		if ShouldHideEvent(event) {
+		if !r.acceptEvent(event) {
 			continue
 		}
 		//if event.Type == "m.room.redaction" {
@@ -72,78 +153,113 @@ func (r *Room) concatBackpagination(oldEvents []gomatrix.Event, newToken string)
 		//continue
 		//}
 
-		r.eventList = append(r.eventList, event)
+		r.timeline.PushOldest(event)
+		r.reindexSearch(event.ID)
+	}
+	r.setBackPaginationToken(newToken)
+	// newer servers omit the token once there is nothing further back.
+	if newToken == "" {
+		r.HasReachedHistoricEndOfTimeline = true
 	}
-	r.backPaginationToken = newToken
+	r.markDirty()
+	r.mergeMetFragments()
 	r.latestRoomState.RecalculateMemberListAndServers()
 }
 
 func (r *Room) concatForwardPagination(newEvents []gomatrix.Event, newToken string) {
 	for _, event := range newEvents {
-		// TODO Handle redaction and skip adding to TL
-		//if event.Type == "m.room.redaction" {
-		// Might want an Event Map->*Event so we can skip an O(n) task
-		//}
+		if event.Type == "m.room.redaction" {
+			r.applyRedaction(event)
+		}
 
-		if ShouldHideEvent(event) {
+		if !r.acceptEvent(event) {
 			continue
 		}
 
-		r.latestRoomState.UpdateOnEvent(&event, false)
-		r.eventList = append([]gomatrix.Event{event}, r.eventList...)
+		if event.StateKey != nil {
+			r.latestRoomState.UpdateOnEvent(&event, false)
+			r.saved.state = true
+		}
+		r.timeline.PushNewest(event)
+		r.reindexSearch(event.ID)
+	}
+	// the server omits the end token once there is nothing further, keep our position rather than restarting.
+	if newToken != "" {
+		r.forwardPaginationToken = newToken
 	}
-	r.forwardPaginationToken = newToken
+	r.markDirty()
 	r.latestRoomState.RecalculateMemberListAndServers()
 }
 
-func (r *Room) findEventIndex(anchor string, backpaginate bool) (int, bool) {
-	for index, event := range r.eventList {
-		if event.ID == anchor {
-			return index, true
+// applyRedaction redacts the event targeted by redaction in our timeline, if we hold it.
+func (r *Room) applyRedaction(redaction gomatrix.Event) {
+	redacts := redaction.Redacts
+	if redacts == "" {
+		// from room version 11 the target moved into the content.
+		redacts, _ = redaction.Content["redacts"].(string)
+	}
+	// the parent will be fetched again, redacted, when it is next quoted.
+	delete(r.replyParents, redacts)
+	if r.latestRoomState.UpdateOnRedaction(redacts) {
+		r.latestRoomState.RecalculateMemberListAndServers()
+		r.saved.state = true
+		r.markDirty()
+	}
+
+	if fragment, index, found := r.locateEvent(redacts); found {
+		t := &r.timeline
+		if fragment != nil {
+			t = &fragment.timeline
 		}
+		t.Set(index, RedactEvent(t.At(index), redaction, r.latestRoomState.roomVersion))
+		if fragment == nil {
+			r.changedEvent(index)
+			r.reindexSearch(redacts)
+		}
+		r.markDirty()
+	} else if r.removeRelation(redacts) {
+		r.markDirty()
 	}
+}
 
-	if backpaginate {
-		if numNew, _ := r.Client.backpaginateRoom(r, 100); numNew > 0 {
-			return r.findEventIndex(anchor, false)
+// locateEvent finds the event in memory, returning its index and the fragment holding it, or nil for the live timeline.
+func (r *Room) locateEvent(eventID string) (fragment *eventFragment, index int, found bool) {
+	if index, found = r.timeline.IndexOf(eventID); found {
+		return nil, index, true
+	}
+	for _, fragment = range r.fragments {
+		if index, found = fragment.timeline.IndexOf(eventID); found {
+			return fragment, index, true
 		}
 	}
-	return 0, false
+	return nil, 0, false
 }
 
 // overcompenesatePaginationBy, number to try and keep as a buffer at the end of our in-memory timeline so we don't
 // backpaginate on every single call.
 const overcompensateBackpaginationBy = 32
 
-func (r *Room) backpaginateIfNeeded(anchorIndex, offset, number int) {
+func (r *Room) backpaginateIfNeeded(ctx context.Context, anchorIndex, offset, number int) {
 	if r.HasReachedHistoricEndOfTimeline {
 		return
 	}
 
 	// delta is the number of events we should have, to comfortably handle this request, if we do not have this many
 	// then ask the mxclient to backpaginate this room by at least delta-length events.
-	length := len(r.eventList)
+	length := r.timeline.Len()
 	if delta := anchorIndex + offset + number + overcompensateBackpaginationBy; delta >= length {
-		// if no error encountered and zero events then we are likely at the last historical event.
-		if numNew, err := r.Client.backpaginateRoom(r, delta-length); err == nil {
-			if numNew == 0 {
-				r.HasReachedHistoricEndOfTimeline = true
-			}
-		}
+		// reaching the last historical event is detected whilst back paginating.
+		r.Client.backpaginateRoom(ctx, r, delta-length)
 	}
 }
 
-func (r *Room) getBackwardEventRange(anchorIndex, offset, number int) []gomatrix.Event {
-	r.backpaginateIfNeeded(anchorIndex, offset, number)
-
-	length := len(r.eventList)
-	startIndex := utils.Min(anchorIndex+offset, length)
-	return r.eventList[startIndex:utils.Min(startIndex+number, length)]
+func (r *Room) getBackwardEventRange(ctx context.Context, anchorIndex, offset, number int) []gomatrix.Event {
+	r.backpaginateIfNeeded(ctx, anchorIndex, offset, number)
+	return r.timeline.backwardRange(anchorIndex, offset, number)
 }
 
 func (r *Room) getForwardEventRange(index, offset, number int) []gomatrix.Event {
-	topIndex := utils.Bound(0, index+number-offset, len(r.eventList))
-	return r.eventList[utils.Max(topIndex-number, 0):topIndex]
+	return r.timeline.forwardRange(index, offset, number)
 }
 
 // GetState returns an instance of RoomState believed to represent the current state of the room.
@@ -151,70 +267,188 @@ func (r *Room) GetState() RoomState {
 	return r.latestRoomState
 }
 
+// IsJoined returns whether our account is joined to the room, so that /sync keeps it up to date and homeservers let
+// us search it.
+func (r *Room) IsJoined() bool {
+	member := r.latestRoomState.MemberMap[r.Client.UserID]
+	return member != nil && member.Membership == "join"
+}
+
 // GetEventPage returns a paginated slice of events, as well as whether this slice rests at either/both ends of the timeline.
-func (r *Room) GetEventPage(anchor string, offset int, pageSize int) (events []gomatrix.Event, atTopEnd, atBottomEnd bool, err error) {
+// If the anchor is not yet in memory the events around it are loaded via /context.
+// Edited events are returned with the content of their latest edit, see IsEdited.
+func (r *Room) GetEventPage(ctx context.Context, anchor string, offset int, pageSize int) (events []gomatrix.Event, atTopEnd, atBottomEnd bool, err error) {
+	events, atTopEnd, atBottomEnd, err = r.getEventPage(ctx, anchor, offset, pageSize)
+	r.applyEdits(events)
+	return
+}
+
+// LatestEventID returns the ID of the newest event of the live timeline, or "" if it holds none.
+func (r *Room) LatestEventID() string {
+	if r.timeline.Len() == 0 {
+		return ""
+	}
+	return r.timeline.At(0).ID
+}
+
+// EventsSince returns up to limit of the newest events of the live timeline which are newer than the one with the given
+// ID, latest first with edits applied, along with the event preceding the oldest of them if it is held in memory.
+// found is false if there is no such event in the live timeline, in which case the newest events are returned.
+func (r *Room) EventsSince(eventID string, limit int) (events []gomatrix.Event, previous *gomatrix.Event, found bool) {
+	end, found := r.timeline.IndexOf(eventID)
+	if !found {
+		end = r.timeline.Len()
+	}
+
+	events = r.timeline.Range(0, utils.Min(end, limit))
+	r.applyEdits(events)
+	if len(events) < r.timeline.Len() {
+		prev := r.timeline.At(len(events))
+		previous = &prev
+	}
+	return events, previous, found
+}
+
+func (r *Room) getEventPage(ctx context.Context, anchor string, offset int, pageSize int) (events []gomatrix.Event, atTopEnd, atBottomEnd bool, err error) {
 	var anchorIndex int
 	if anchor != "" {
-		if index, found := r.findEventIndex(anchor, false); found {
-			anchorIndex = index
-		} else {
+		fragment, index, found := r.locateAnchor(&anchor)
+		if !found {
+			if err = r.loadEventContext(ctx, anchor); err != nil {
+				return
+			}
+			fragment, index, found = r.locateAnchor(&anchor)
+		}
+
+		if !found {
 			err = errors.New("Could not find event")
 			return
 		}
+		if fragment != nil {
+			return r.getFragmentEventPage(ctx, fragment, anchor, offset, pageSize)
+		}
+		anchorIndex = index
 	}
 
 	if offset >= 0 {
-		events = r.getBackwardEventRange(anchorIndex, offset, pageSize)
+		events = r.getBackwardEventRange(ctx, anchorIndex, offset, pageSize)
 	} else {
 		events = r.getForwardEventRange(anchorIndex, -offset, pageSize)
 	}
 
 	// Consider ourselves at end if the ID matches the respective end of the stored event list.
-	numEvents, totalNumEvents := len(events), len(r.eventList)
+	numEvents, totalNumEvents := len(events), r.timeline.Len()
 	if numEvents > 0 {
-		atTopEnd = events[numEvents-1].ID == r.eventList[totalNumEvents-1].ID
-		atBottomEnd = events[0].ID == r.eventList[0].ID
+		atTopEnd = events[numEvents-1].ID == r.timeline.At(totalNumEvents-1).ID
+		atBottomEnd = events[0].ID == r.timeline.At(0).ID
 	}
 	return
 }
 
 const RoomInitialSyncLimit = 256
 
-// NewRoom fetches :roomId/initialSync for a room and instantiates a room to represent it.
-func (m *Client) NewRoom(roomID string) (*Room, error) {
-	resp, err := m.RoomInitialSync(roomID, RoomInitialSyncLimit)
+// NewRoom instantiates a room to represent roomID, rehydrating it from the Client's RoomStore if it holds the room and
+// only fetching what happened since, else by fetching its state and latest messages.
+func (m *Client) NewRoom(ctx context.Context, roomID string) (*Room, error) {
+	if m.Store != nil {
+		stored, err := m.Store.LoadRoom(roomID)
+		if err != nil {
+			log.WithField("roomID", roomID).WithError(err).Error("Failed Loading Room from Store")
+		} else if stored != nil {
+			room := m.newRoomFromStored(stored)
+			room.LastAccess = time.Now()
+			if room.fillForwardGap(ctx) {
+				return room, nil
+			}
+			// too much happened whilst the room was not loaded (or the server is not cooperating), start afresh.
+			log.WithField("roomID", roomID).Warn("Discarding stored Room as unable to catch up")
+		}
+	}
+
+	newRoom, err := m.newRoomFromMessages(ctx, roomID)
+	if needsInitialSync(err) && ctx.Err() == nil {
+		// servers may only let guests peek rooms via the deprecated initialSync, so try that too.
+		log.WithField("roomID", roomID).WithError(err).Warn("Falling back to initialSync")
+		fallbackRoom, fallbackErr := m.newRoomFromInitialSync(ctx, roomID)
+		// report the original error if the server does not know initialSync at all.
+		if !isUnsupportedEndpoint(fallbackErr) {
+			newRoom, err = fallbackRoom, fallbackErr
+		}
+	}
+	return newRoom, err
+}
 
+// needsInitialSync returns whether err, from loading a room via /state and /messages, indicates that the server only
+// lets us peek the room via initialSync, as opposed to the room not existing or not being peekable at all.
+func needsInitialSync(err error) bool {
+	if err == nil {
+		return false
+	}
+	if respErr, ok := UnwrapRespError(err); ok && respErr.ErrCode == "M_GUEST_ACCESS_FORBIDDEN" {
+		return true
+	}
+	return isUnsupportedEndpoint(err)
+}
+
+// newRoomFromMessages loads the room from its current state and a page of /messages back from the latest event.
+func (m *Client) newRoomFromMessages(ctx context.Context, roomID string) (*Room, error) {
+	state, err := m.RoomState(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := m.RoomMessages(ctx, roomID, "", "", 'b', RoomInitialSyncLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	// back paginating from the latest event, start is where to forward paginate from and end where to continue back.
+	newRoom := m.newLoadedRoom(roomID, state, resp.Start)
+	newRoom.concatBackpagination(resp.Chunk, resp.End)
+	if len(resp.Chunk) == 0 {
+		newRoom.HasReachedHistoricEndOfTimeline = true
+	}
+	return newRoom, nil
+}
+
+// newRoomFromInitialSync loads the room from :roomId/initialSync.
+func (m *Client) newRoomFromInitialSync(ctx context.Context, roomID string) (*Room, error) {
+	resp, err := m.RoomInitialSync(ctx, roomID, RoomInitialSyncLimit)
 	if err != nil {
 		return nil, err
 	}
 
-	// filter out m.room.redactions and reverse ordering at once.
-	var filteredEventList []gomatrix.Event
+	newRoom := m.newLoadedRoom(roomID, resp.State, resp.Messages.End)
+
+	// filter out m.room.redactions, the chunk is in chronological order so each event is the newest yet.
 	for _, event := range resp.Messages.Chunk {
-		if ShouldHideEvent(event) {
+		if !newRoom.acceptEvent(event) {
 			continue
 		}
 
-		filteredEventList = append([]gomatrix.Event{event}, filteredEventList...)
+		newRoom.timeline.PushNewest(event)
 	}
+	newRoom.setBackPaginationToken(resp.Messages.Start)
+	return newRoom, nil
+}
 
+// newLoadedRoom instantiates a room with the given state, which is to forward paginate from forwardToken.
+func (m *Client) newLoadedRoom(roomID string, state []gomatrix.Event, forwardToken string) *Room {
 	newRoom := &Room{
 		Client:                 m,
 		ID:                     roomID,
-		forwardPaginationToken: resp.Messages.End,
-		backPaginationToken:    resp.Messages.Start,
-		eventList:              filteredEventList,
+		forwardPaginationToken: forwardToken,
 		latestRoomState:        *NewRoomState(m),
 		LastAccess:             time.Now(),
+		dirty:                  true,
 	}
 
-	for _, event := range resp.State {
+	for _, event := range state {
 		newRoom.latestRoomState.UpdateOnEvent(&event, true)
 	}
 
 	newRoom.latestRoomState.RecalculateMemberListAndServers()
-
-	return newRoom, nil
+	return newRoom
 }
 
 // RoomInfo summates basic currentState parameters
@@ -229,5 +463,8 @@ func (r *Room) RoomInfo() RoomInfo {
 		r.latestRoomState.GetNumMemberEvents(),
 		r.latestRoomState.NumMembers(),
 		len(r.latestRoomState.Servers()),
+		r.latestRoomState.predecessorRoomID,
+		r.latestRoomState.predecessorEventID,
+		r.latestRoomState.successorRoomID,
 	}
 }
@@ -0,0 +1,161 @@
+package mxclient
+
+import (
+	"context"
+	"github.com/matrix-org/gomatrix"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func stateEvent(eventType, stateKey string, content map[string]interface{}) gomatrix.Event {
+	return gomatrix.Event{Type: eventType, StateKey: &stateKey, Content: content}
+}
+
+func TestClient_NewRoom(t *testing.T) {
+	state := []gomatrix.Event{
+		stateEvent("m.room.name", "", map[string]interface{}{"name": "Test Room"}),
+	}
+
+	tests := []struct {
+		name          string
+		stateErrCode  string
+		expPaths      []string
+		expBackToken  string
+		expEventRange []gomatrix.Event
+	}{
+		{
+			"should load from state and messages",
+			"",
+			[]string{"/state", "/messages"},
+			"before11",
+			messageEvents(20, 11),
+		}, {
+			"should fall back to initialSync if the server does not support peeking otherwise",
+			"M_UNRECOGNIZED",
+			[]string{"/state", "/initialSync"},
+			"before1",
+			messageEvents(10, 1),
+		}, {
+			"should fall back to initialSync if the server only lets guests peek that way",
+			"M_GUEST_ACCESS_FORBIDDEN",
+			[]string{"/state", "/initialSync"},
+			"before1",
+			messageEvents(10, 1),
+		}, {
+			"should not fall back to initialSync if the room cannot be peeked",
+			"M_FORBIDDEN",
+			[]string{"/state"},
+			"",
+			nil,
+		}, {
+			"should not fall back to initialSync if the room does not exist",
+			"M_NOT_FOUND",
+			[]string{"/state"},
+			"",
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var paths []string
+			cli, _ := NewRawClient("https://example.org", "", "", "")
+			cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+				path := req.URL.Path[strings.LastIndex(req.URL.Path, "/"):]
+				paths = append(paths, path)
+
+				switch {
+				case path == "/state" && tt.stateErrCode != "":
+					resp := jsonResponse(map[string]string{"errcode": tt.stateErrCode, "error": "Failed"})
+					resp.StatusCode = 404
+					if tt.stateErrCode != "M_UNRECOGNIZED" && tt.stateErrCode != "M_NOT_FOUND" {
+						resp.StatusCode = 403
+					}
+					return resp
+				case path == "/state":
+					return jsonResponse(state)
+				case path == "/messages":
+					if req.URL.Query().Get("from") != "" || req.URL.Query().Get("dir") != "b" {
+						t.Error("Should back paginate from the latest event", req.URL.RawQuery)
+					}
+					return jsonResponse(map[string]interface{}{"chunk": messageEvents(20, 11), "start": "latest", "end": "before11"})
+				default:
+					return jsonResponse(map[string]interface{}{
+						"messages": map[string]interface{}{"chunk": messageEvents(1, 10), "start": "before1", "end": "latest"},
+						"state":    state,
+					})
+				}
+			})
+
+			room, err := cli.NewRoom(context.Background(), "!room")
+			if !reflect.DeepEqual(paths, tt.expPaths) {
+				t.Error("Requests mismatch expectation", paths)
+			}
+			if tt.expEventRange == nil {
+				if err == nil {
+					t.Error("Loading room should have failed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("Failed loading room", err)
+			}
+			if room.RoomInfo().Name != "Test Room" {
+				t.Error("State mismatch expectation", room.RoomInfo())
+			}
+			if room.forwardPaginationToken != "latest" || room.backPaginationToken != tt.expBackToken {
+				t.Error("Tokens mismatch expectation", room.forwardPaginationToken, room.backPaginationToken)
+			}
+			if ids := eventIDs(room.timeline.Range(0, room.timeline.Len())); !reflect.DeepEqual(ids, eventIDs(tt.expEventRange)) {
+				t.Error("Timeline mismatch expectation", ids)
+			}
+		})
+	}
+}
+
+func TestRoomState_UpgradeLinks(t *testing.T) {
+	room := &Room{ID: "!new", latestRoomState: *NewRoomState(nil)}
+	for _, event := range []gomatrix.Event{
+		stateEvent("m.room.create", "", map[string]interface{}{
+			"predecessor": map[string]interface{}{"room_id": "!old", "event_id": "$tombstone"},
+		}),
+		stateEvent("m.room.tombstone", "", map[string]interface{}{"replacement_room": "!newer"}),
+	} {
+		room.latestRoomState.UpdateOnEvent(&event, false)
+	}
+
+	info := room.RoomInfo()
+	if info.PredecessorRoomID != "!old" || info.PredecessorEventID != "$tombstone" || info.SuccessorRoomID != "!newer" {
+		t.Error("Upgrade links mismatch expectation", info)
+	}
+
+	if restored := newRoomStateFromStored(nil, room.latestRoomState.toStored()); restored.successorRoomID != "!newer" || restored.predecessorRoomID != "!old" {
+		t.Error("Upgrade links should survive being stored", restored)
+	}
+}
+
+func TestRoom_EventsSince(t *testing.T) {
+	room := &Room{ID: "!room", latestRoomState: *NewRoomState(nil)}
+	room.concatForwardPagination(messageEvents(1, 10), "forward")
+
+	if id := room.LatestEventID(); id != "$10" {
+		t.Error("Latest event mismatch expectation", id)
+	}
+
+	events, previous, found := room.EventsSince("$7", 5)
+	if !found || !reflect.DeepEqual(eventIDs(events), []string{"$10", "$9", "$8"}) || previous == nil || previous.ID != "$7" {
+		t.Error("Events since mismatch expectation", found, eventIDs(events), previous)
+	}
+
+	// only the newest are returned if there are more than the limit, following on from the one before them.
+	events, previous, _ = room.EventsSince("$2", 2)
+	if !reflect.DeepEqual(eventIDs(events), []string{"$10", "$9"}) || previous == nil || previous.ID != "$8" {
+		t.Error("Limited events since mismatch expectation", eventIDs(events), previous)
+	}
+
+	events, previous, found = room.EventsSince("$unknown", 20)
+	if found || len(events) != 10 || previous != nil {
+		t.Error("Unknown event should return the newest events", found, len(events), previous)
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mxclient
+
+import (
+	"context"
+	"github.com/matrix-org/gomatrix"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// SearchResults is a page of the messages matching a search, newest first.
+type SearchResults struct {
+	Events []gomatrix.Event
+	// Highlights are the words which matched, to be highlighted in Events.
+	Highlights []string
+	// Count is the total number of matching messages, which may be an estimate.
+	Count int
+	// NextBatch is the token to fetch the next page of results with, empty if there are none.
+	NextBatch string
+}
+
+type reqSearch struct {
+	SearchCategories struct {
+		RoomEvents struct {
+			SearchTerm string `json:"search_term"`
+			OrderBy    string `json:"order_by"`
+			Filter     struct {
+				Rooms []string `json:"rooms"`
+				Limit int      `json:"limit,omitempty"`
+			} `json:"filter"`
+		} `json:"room_events"`
+	} `json:"search_categories"`
+}
+
+type respSearch struct {
+	SearchCategories struct {
+		RoomEvents struct {
+			Results []struct {
+				Result gomatrix.Event `json:"result"`
+			} `json:"results"`
+			Count      int      `json:"count"`
+			Highlights []string `json:"highlights"`
+			NextBatch  string   `json:"next_batch"`
+		} `json:"room_events"`
+	} `json:"search_categories"`
+}
+
+// SearchRoom searches the messages of a room using the homeserver according to
+// https://matrix.org/docs/spec/client_server/r0.6.0#post-matrix-client-r0-search, starting at the latest unless
+// from is the NextBatch of a previous page. Homeservers only search rooms our account is joined to, see IsJoined.
+func (m *Client) SearchRoom(ctx context.Context, roomID, term, from string, limit int) (*SearchResults, error) {
+	var req reqSearch
+	req.SearchCategories.RoomEvents.SearchTerm = term
+	req.SearchCategories.RoomEvents.OrderBy = "recent"
+	req.SearchCategories.RoomEvents.Filter.Rooms = []string{roomID}
+	req.SearchCategories.RoomEvents.Filter.Limit = limit
+
+	query := map[string]string{}
+	if from != "" {
+		query["next_batch"] = from
+	}
+
+	var resp respSearch
+	urlPath := m.BuildURLWithQuery([]string{"search"}, query)
+	if err := m.MakeRequestWithContext(ctx, "POST", urlPath, req, &resp); err != nil {
+		return nil, err
+	}
+
+	roomEvents := resp.SearchCategories.RoomEvents
+	results := &SearchResults{
+		Highlights: roomEvents.Highlights,
+		Count:      roomEvents.Count,
+		NextBatch:  roomEvents.NextBatch,
+	}
+	for _, result := range roomEvents.Results {
+		// edits match as well as what they edit, which is shown with the edit applied already.
+		if !IsEdit(result.Result) {
+			results.Events = append(results.Events, result.Result)
+		}
+	}
+	return results, nil
+}
+
+// searchTokens splits text into the lower cased words it is searched by.
+func searchTokens(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// searchIndex is an inverted index of the messages in the live timeline of a room, for rooms the homeserver cannot
+// search. Once built it is kept up to date as the timeline changes, until the room is trimmed.
+type searchIndex struct {
+	// postings holds the IDs of the messages containing each word.
+	postings map[string]map[string]bool
+	// tokens holds the words each message is indexed by, to remove it again.
+	tokens map[string][]string
+}
+
+// add indexes ev, which is edited as it would be in the timeline.
+func (index *searchIndex) add(ev gomatrix.Event) {
+	body, _ := ev.Content["body"].(string)
+	for _, token := range searchTokens(body) {
+		if index.postings[token] == nil {
+			index.postings[token] = make(map[string]bool)
+		}
+		if !index.postings[token][ev.ID] {
+			index.postings[token][ev.ID] = true
+			index.tokens[ev.ID] = append(index.tokens[ev.ID], token)
+		}
+	}
+}
+
+func (index *searchIndex) remove(eventID string) {
+	for _, token := range index.tokens[eventID] {
+		delete(index.postings[token], eventID)
+		if len(index.postings[token]) == 0 {
+			delete(index.postings, token)
+		}
+	}
+	delete(index.tokens, eventID)
+}
+
+func (r *Room) buildSearchIndex() *searchIndex {
+	index := &searchIndex{
+		postings: make(map[string]map[string]bool),
+		tokens:   make(map[string][]string),
+	}
+	for _, ev := range r.WithEdits(r.timeline.Range(0, r.timeline.Len())) {
+		if ev.Type == "m.room.message" && !IsRedacted(&ev) {
+			index.add(ev)
+		}
+	}
+	return index
+}
+
+// reindexSearch brings the search index, if it has been built, up to date with the events with the given IDs having
+// been added to the live timeline, edited or redacted.
+func (r *Room) reindexSearch(eventIDs ...string) {
+	if r.searchIndex == nil {
+		return
+	}
+	for _, eventID := range eventIDs {
+		r.searchIndex.remove(eventID)
+		pos, found := r.timeline.IndexOf(eventID)
+		if !found {
+			continue
+		}
+		if ev := r.timeline.At(pos); ev.Type == "m.room.message" && !IsRedacted(&ev) {
+			r.searchIndex.add(r.WithEdits([]gomatrix.Event{ev})[0])
+		}
+	}
+}
+
+// SearchLocal searches the messages in the timeline held in memory for those containing every word of term, starting
+// at the latest unless from is the NextBatch of a previous page. Older history which has not been loaded is not found.
+func (r *Room) SearchLocal(term, from string, limit int) SearchResults {
+	if r.searchIndex == nil {
+		r.searchIndex = r.buildSearchIndex()
+	}
+	index := r.searchIndex
+
+	tokens := searchTokens(term)
+	results := SearchResults{Highlights: tokens}
+	if len(tokens) == 0 {
+		return results
+	}
+
+	// walk the postings of the rarest word, keeping the messages which contain every other word too.
+	sort.Slice(tokens, func(i, j int) bool {
+		return len(index.postings[tokens[i]]) < len(index.postings[tokens[j]])
+	})
+
+	// positions in the live timeline count from the newest event, so sorting by them orders the matches newest first.
+	var matches []int
+	for eventID := range index.postings[tokens[0]] {
+		matchesAll := true
+		for _, token := range tokens[1:] {
+			matchesAll = matchesAll && index.postings[token][eventID]
+		}
+		if pos, found := r.timeline.IndexOf(eventID); matchesAll && found {
+			matches = append(matches, pos)
+		}
+	}
+	sort.Ints(matches)
+
+	start, _ := strconv.Atoi(from)
+	if start < 0 || start > len(matches) {
+		start = 0
+	}
+	end := len(matches)
+	if limit > 0 && start+limit < end {
+		end = start + limit
+		results.NextBatch = strconv.Itoa(end)
+	}
+
+	results.Count = len(matches)
+	for _, pos := range matches[start:end] {
+		results.Events = append(results.Events, r.timeline.At(pos))
+	}
+	results.Events = r.WithEdits(results.Events)
+	return results
+}
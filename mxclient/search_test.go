@@ -0,0 +1,70 @@
+package mxclient
+
+import (
+	"github.com/matrix-org/gomatrix"
+	"reflect"
+	"testing"
+)
+
+func textMessage(id, body string) gomatrix.Event {
+	return gomatrix.Event{ID: id, Type: "m.room.message", Sender: "@alice:example.org",
+		Content: map[string]interface{}{"msgtype": "m.text", "body": body}}
+}
+
+func TestRoom_SearchLocal(t *testing.T) {
+	room := &Room{latestRoomState: *NewRoomState(nil)}
+	room.concatForwardPagination([]gomatrix.Event{
+		textMessage("$1", "Where was the release discussed?"),
+		textMessage("$2", "the RELEASE is out"),
+		textMessage("$3", "nothing to see here"),
+		makeEdit("$e3", "@alice:example.org", "$3", "release notes are here", 4),
+		textMessage("$4", "release, release, release"),
+	}, "forward")
+
+	tests := []struct {
+		term       string
+		from       string
+		expIDs     []string
+		expNext    string
+		expMatches int
+	}{
+		{"release", "", []string{"$4", "$3"}, "2", 4},
+		{"release", "2", []string{"$2", "$1"}, "", 4},
+		{"Release discussed", "", []string{"$1"}, "", 1},
+		{"missing", "", []string{}, "", 0},
+		{"?!", "", []string{}, "", 0},
+	}
+	for _, tt := range tests {
+		results := room.SearchLocal(tt.term, tt.from, 2)
+		if ids := eventIDs(results.Events); !reflect.DeepEqual(ids, tt.expIDs) || results.NextBatch != tt.expNext || results.Count != tt.expMatches {
+			t.Errorf("SearchLocal(%q, %q) = %v, %q, %d", tt.term, tt.from, ids, results.NextBatch, results.Count)
+		}
+	}
+
+	// the index is updated as the timeline changes.
+	room.concatForwardPagination([]gomatrix.Event{
+		{ID: "$r", Type: "m.room.redaction", Redacts: "$4"},
+		textMessage("$5", "another release"),
+		makeEdit("$e2", "@alice:example.org", "$2", "the changelog is out", 6),
+	}, "forward")
+	if ids := eventIDs(room.SearchLocal("release", "", 2).Events); !reflect.DeepEqual(ids, []string{"$5", "$3"}) {
+		t.Error("Search should follow changes to the timeline", ids)
+	}
+	if results := room.SearchLocal("changelog", "", 2); len(results.Events) != 1 || results.Events[0].Content["body"] != "the changelog is out" {
+		t.Error("Search should follow edits", results.Events)
+	}
+
+	// redacting an edit reverts the message to what it was before.
+	room.concatForwardPagination([]gomatrix.Event{{ID: "$r2", Type: "m.room.redaction", Redacts: "$e2"}}, "forward")
+	if ids := eventIDs(room.SearchLocal("release", "", 3).Events); !reflect.DeepEqual(ids, []string{"$5", "$3", "$2"}) {
+		t.Error("Search should follow redacted edits", ids)
+	}
+	if !reflect.DeepEqual(room.searchIndex.postings, room.buildSearchIndex().postings) {
+		t.Error("Updated index should match one built afresh", room.searchIndex.postings)
+	}
+
+	room.Trim(0)
+	if room.searchIndex != nil {
+		t.Error("Trimming should discard the index")
+	}
+}
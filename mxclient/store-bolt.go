@@ -0,0 +1,183 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mxclient
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/matrix-org/gomatrix"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Each room is a bucket keyed by its ID, holding the meta and state under their own keys and the timeline events and
+// related events in nested buckets, keyed by sequence number and event ID respectively.
+var (
+	boltMetaKey         = []byte("meta")
+	boltStateKey        = []byte("state")
+	boltEventsBucket    = []byte("events")
+	boltRelationsBucket = []byte("relations")
+)
+
+// boltSeqKey encodes a timeline sequence number such that the keys sort in the same order as the numbers do.
+func boltSeqKey(seq int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(seq)^(1<<63))
+	return key
+}
+
+func boltKeySeq(key []byte) int {
+	return int(binary.BigEndian.Uint64(key) ^ (1 << 63))
+}
+
+// BoltRoomStore is a RoomStore keeping rooms in a BoltDB file, only writing what changed of a room on each save.
+type BoltRoomStore struct {
+	db *bolt.DB
+}
+
+// NewBoltRoomStore opens the BoltRoomStore within dir, creating it if needed.
+func NewBoltRoomStore(dir string) (*BoltRoomStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	// fail rather than wait forever should another process have the store open.
+	db, err := bolt.Open(filepath.Join(dir, "rooms.db"), 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltRoomStore{db}, nil
+}
+
+// Close closes the underlying file, the store must not be used afterwards.
+func (s *BoltRoomStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltRoomStore) LoadRoom(roomID string) (*StoredRoom, error) {
+	var stored *StoredRoom
+	err := s.db.View(func(tx *bolt.Tx) error {
+		room := tx.Bucket([]byte(roomID))
+		if room == nil {
+			return nil
+		}
+
+		stored = &StoredRoom{}
+		if err := json.Unmarshal(room.Get(boltMetaKey), &stored.StoredRoomMeta); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(room.Get(boltStateKey), &stored.State); err != nil {
+			return err
+		}
+
+		if events := room.Bucket(boltEventsBucket); events != nil {
+			c := events.Cursor()
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				if len(stored.Events) == 0 {
+					stored.FirstSeq = boltKeySeq(k)
+				}
+				var ev gomatrix.Event
+				if err := json.Unmarshal(v, &ev); err != nil {
+					return err
+				}
+				stored.Events = append(stored.Events, ev)
+			}
+		}
+
+		if relations := room.Bucket(boltRelationsBucket); relations != nil {
+			return relations.ForEach(func(k, v []byte) error {
+				var ev gomatrix.Event
+				if err := json.Unmarshal(v, &ev); err != nil {
+					return err
+				}
+				stored.Relations = append(stored.Relations, ev)
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+func (s *BoltRoomStore) SaveRoom(update *StoredRoomUpdate) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		roomKey := []byte(update.RoomID)
+		if update.Replace {
+			if err := tx.DeleteBucket(roomKey); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		room, err := tx.CreateBucketIfNotExists(roomKey)
+		if err != nil {
+			return err
+		}
+
+		if err = boltPutJSON(room, boltMetaKey, update.StoredRoomMeta); err != nil {
+			return err
+		}
+		if update.State != nil {
+			if err = boltPutJSON(room, boltStateKey, update.State); err != nil {
+				return err
+			}
+		}
+
+		events, err := room.CreateBucketIfNotExists(boltEventsBucket)
+		if err != nil {
+			return err
+		}
+		// drop the events which have been trimmed from the timeline.
+		firstKey := boltSeqKey(update.FirstSeq)
+		c := events.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, firstKey) < 0; k, _ = c.First() {
+			if err = c.Delete(); err != nil {
+				return err
+			}
+		}
+		for seq, ev := range update.Events {
+			if err = boltPutJSON(events, boltSeqKey(seq), ev); err != nil {
+				return err
+			}
+		}
+
+		relations, err := room.CreateBucketIfNotExists(boltRelationsBucket)
+		if err != nil {
+			return err
+		}
+		for _, eventID := range update.RemovedRelations {
+			if err = relations.Delete([]byte(eventID)); err != nil {
+				return err
+			}
+		}
+		for _, ev := range update.Relations {
+			if err = boltPutJSON(relations, []byte(ev.ID), ev); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func boltPutJSON(bucket *bolt.Bucket, key []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, data)
+}
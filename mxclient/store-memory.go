@@ -0,0 +1,140 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mxclient
+
+import (
+	"container/list"
+	"github.com/matrix-org/gomatrix"
+	"sort"
+	"sync"
+)
+
+// MemoryRoomStore is a RoomStore keeping rooms in memory, so that rooms evicted from the workers can be loaded again
+// without fetching them afresh, though not across restarts. Once it holds more than maxEvents events the least
+// recently saved rooms are forgotten.
+type MemoryRoomStore struct {
+	maxEvents int
+
+	mu        sync.Mutex
+	rooms     map[string]*list.Element
+	lru       *list.List // of *memoryRoom, most recently saved first
+	numEvents int
+}
+
+type memoryRoom struct {
+	meta      StoredRoomMeta
+	state     StoredRoomState
+	events    map[int]gomatrix.Event
+	relations map[string]gomatrix.Event
+}
+
+func (room *memoryRoom) numEvents() int {
+	return len(room.events) + len(room.relations)
+}
+
+// NewMemoryRoomStore returns an empty MemoryRoomStore holding at most maxEvents events.
+func NewMemoryRoomStore(maxEvents int) *MemoryRoomStore {
+	return &MemoryRoomStore{
+		maxEvents: maxEvents,
+		rooms:     make(map[string]*list.Element),
+		lru:       list.New(),
+	}
+}
+
+func (s *MemoryRoomStore) LoadRoom(roomID string) (*StoredRoom, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, exists := s.rooms[roomID]
+	if !exists {
+		return nil, nil
+	}
+	room := elem.Value.(*memoryRoom)
+
+	stored := &StoredRoom{
+		StoredRoomMeta: room.meta,
+		Events:         make([]gomatrix.Event, 0, len(room.events)),
+		State:          room.state,
+		Relations:      make([]gomatrix.Event, 0, len(room.relations)),
+	}
+	seqs := make([]int, 0, len(room.events))
+	for seq := range room.events {
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+	for _, seq := range seqs {
+		stored.Events = append(stored.Events, room.events[seq])
+	}
+	if len(seqs) > 0 {
+		stored.FirstSeq = seqs[0]
+	}
+	for _, ev := range room.relations {
+		stored.Relations = append(stored.Relations, ev)
+	}
+	return stored, nil
+}
+
+func (s *MemoryRoomStore) SaveRoom(update *StoredRoomUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	elem, exists := s.rooms[update.RoomID]
+	if exists && update.Replace {
+		s.remove(elem)
+		exists = false
+	}
+	if !exists {
+		elem = s.lru.PushFront(&memoryRoom{
+			events:    make(map[int]gomatrix.Event),
+			relations: make(map[string]gomatrix.Event),
+		})
+		s.rooms[update.RoomID] = elem
+	}
+	s.lru.MoveToFront(elem)
+
+	room := elem.Value.(*memoryRoom)
+	s.numEvents -= room.numEvents()
+	room.meta = update.StoredRoomMeta
+	if update.State != nil {
+		room.state = *update.State
+	}
+	for seq := range room.events {
+		if seq < update.FirstSeq {
+			delete(room.events, seq)
+		}
+	}
+	for seq, ev := range update.Events {
+		room.events[seq] = ev
+	}
+	for _, eventID := range update.RemovedRelations {
+		delete(room.relations, eventID)
+	}
+	for _, ev := range update.Relations {
+		room.relations[ev.ID] = ev
+	}
+	s.numEvents += room.numEvents()
+
+	// always keep the room just saved, even if it alone is over budget.
+	for s.numEvents > s.maxEvents && s.lru.Len() > 1 {
+		s.remove(s.lru.Back())
+	}
+	return nil
+}
+
+func (s *MemoryRoomStore) remove(elem *list.Element) {
+	room := s.lru.Remove(elem).(*memoryRoom)
+	delete(s.rooms, room.meta.RoomID)
+	s.numEvents -= room.numEvents()
+}
@@ -0,0 +1,299 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mxclient
+
+import (
+	"github.com/matrix-org/gomatrix"
+)
+
+// RoomStore persists rooms so that they survive being evicted from memory or the process restarting.
+// Rooms are saved incrementally, each save only holding what changed since the previous one.
+type RoomStore interface {
+	// LoadRoom returns the stored room, or nil if there is none.
+	LoadRoom(roomID string) (*StoredRoom, error)
+	SaveRoom(update *StoredRoomUpdate) error
+}
+
+type StoredMember struct {
+	MXID        string
+	Membership  string
+	DisplayName string
+	AvatarURL   string
+}
+
+type StoredRoomState struct {
+	Creator        string
+	Topic          string
+	Name           string
+	CanonicalAlias string
+	RoomVersion    string
+	AvatarURL      string
+	Aliases        map[string][]string
+	PowerLevels    PowerLevels
+	Members        []StoredMember
+
+	PredecessorRoomID  string
+	PredecessorEventID string
+	SuccessorRoomID    string
+
+	// EventIDs holds the ID of the event setting each piece of state by its type then state key.
+	EventIDs map[string]map[string]string
+}
+
+// StoredRoomMeta is what is saved of a Room in full every time it changes, being small.
+type StoredRoomMeta struct {
+	RoomID                          string
+	BackPaginationToken             string
+	ForwardPaginationToken          string
+	HasReachedHistoricEndOfTimeline bool
+	// BackTokens are the positions the timeline can be trimmed back to, see Room.Trim.
+	BackTokens []StoredBackToken
+}
+
+type StoredBackToken struct {
+	Seq   int
+	Token string
+}
+
+// StoredRoom is the serialisable form of a Room, detached timeline fragments are not stored.
+type StoredRoom struct {
+	StoredRoomMeta
+	// Events are in chronological order, the oldest having timeline sequence number FirstSeq, the next FirstSeq+1...
+	Events   []gomatrix.Event
+	FirstSeq int
+	State    StoredRoomState
+	// Relations are the events relating to the stored events which are kept out of the timeline, in no particular order.
+	Relations []gomatrix.Event
+}
+
+// StoredRoomUpdate is what changed of a Room since it was last saved.
+type StoredRoomUpdate struct {
+	StoredRoomMeta
+	// Replace is set if whatever is stored of the room is to be discarded in favour of this update.
+	Replace bool
+	// FirstSeq is the sequence number of the oldest event of the timeline, any stored before it have been trimmed.
+	FirstSeq int
+	// Events holds the new and changed events of the timeline by sequence number.
+	Events map[int]gomatrix.Event
+	// State is nil if it did not change.
+	State            *StoredRoomState
+	Relations        []gomatrix.Event
+	RemovedRelations []string
+}
+
+// savedRoom tracks what of a Room its RoomStore holds, so that each save only writes what changed, see Persist.
+type savedRoom struct {
+	// stored is whether the RoomStore holds the room, if not it is to be saved in full.
+	stored bool
+	// firstSeq and endSeq bound the sequence numbers of the timeline events which are stored.
+	firstSeq, endSeq int
+	// changedSeqs are those of the stored events which have changed since, such as by being redacted.
+	changedSeqs map[int]bool
+	// relations maps the IDs of the related events added or removed since to whether they were added.
+	relations map[string]bool
+	state     bool
+}
+
+// changedEvent records that the event at position pos of the live timeline changed.
+func (r *Room) changedEvent(pos int) {
+	if r.saved.changedSeqs == nil {
+		r.saved.changedSeqs = make(map[int]bool)
+	}
+	r.saved.changedSeqs[r.timeline.Seq(pos)] = true
+}
+
+// changedRelation records that the related event with the given ID was added or removed.
+func (r *Room) changedRelation(eventID string, added bool) {
+	if r.saved.relations == nil {
+		r.saved.relations = make(map[string]bool)
+	}
+	r.saved.relations[eventID] = added
+}
+
+func (rs *RoomState) toStored() StoredRoomState {
+	members := make([]StoredMember, 0, len(rs.MemberMap))
+	for _, member := range rs.MemberMap {
+		members = append(members, StoredMember{
+			member.MXID,
+			member.Membership,
+			member.DisplayName,
+			member.AvatarURL.string,
+		})
+	}
+
+	eventIDs := make(map[string]map[string]string)
+	for key, eventID := range rs.eventIDs {
+		if eventIDs[key.Type] == nil {
+			eventIDs[key.Type] = make(map[string]string)
+		}
+		eventIDs[key.Type][key.StateKey] = eventID
+	}
+
+	return StoredRoomState{
+		Creator:        rs.Creator,
+		Topic:          rs.Topic,
+		Name:           rs.Name,
+		CanonicalAlias: rs.canonicalAlias,
+		RoomVersion:    rs.roomVersion,
+		AvatarURL:      rs.AvatarURL.string,
+		Aliases:        rs.aliasMap,
+		PowerLevels:    rs.PowerLevels,
+		Members:        members,
+
+		PredecessorRoomID:  rs.predecessorRoomID,
+		PredecessorEventID: rs.predecessorEventID,
+		SuccessorRoomID:    rs.successorRoomID,
+		EventIDs:           eventIDs,
+	}
+}
+
+func newRoomStateFromStored(client *Client, stored StoredRoomState) RoomState {
+	rs := NewRoomState(client)
+	rs.Creator = stored.Creator
+	rs.Topic = stored.Topic
+	rs.Name = stored.Name
+	rs.canonicalAlias = stored.CanonicalAlias
+	rs.roomVersion = stored.RoomVersion
+	rs.predecessorRoomID = stored.PredecessorRoomID
+	rs.predecessorEventID = stored.PredecessorEventID
+	rs.successorRoomID = stored.SuccessorRoomID
+	if stored.AvatarURL != "" {
+		rs.AvatarURL = *NewMXCURL(stored.AvatarURL, client.MediaBaseURL)
+	}
+	rs.PowerLevels = stored.PowerLevels
+	for server, aliases := range stored.Aliases {
+		rs.aliasMap[server] = aliases
+	}
+	for _, member := range stored.Members {
+		memberInfo := &MemberInfo{
+			MXID:        member.MXID,
+			Membership:  member.Membership,
+			DisplayName: member.DisplayName,
+		}
+		if member.AvatarURL != "" {
+			memberInfo.AvatarURL = *NewMXCURL(member.AvatarURL, client.MediaBaseURL)
+		}
+		rs.MemberMap[member.MXID] = memberInfo
+	}
+	for evType, eventIDs := range stored.EventIDs {
+		for stateKey, eventID := range eventIDs {
+			rs.eventIDs[stateKeyTuple{evType, stateKey}] = eventID
+		}
+	}
+	rs.RecalculateMemberListAndServers()
+	return *rs
+}
+
+func (r *Room) storedMeta() StoredRoomMeta {
+	backTokens := make([]StoredBackToken, 0, len(r.backTokens))
+	for _, bt := range r.backTokens {
+		backTokens = append(backTokens, StoredBackToken{bt.seq, bt.token})
+	}
+	return StoredRoomMeta{
+		RoomID:                          r.ID,
+		BackPaginationToken:             r.backPaginationToken,
+		ForwardPaginationToken:          r.forwardPaginationToken,
+		HasReachedHistoricEndOfTimeline: r.HasReachedHistoricEndOfTimeline,
+		BackTokens:                      backTokens,
+	}
+}
+
+// storedUpdate returns what changed of the room since it was last saved.
+func (r *Room) storedUpdate() *StoredRoomUpdate {
+	update := &StoredRoomUpdate{
+		StoredRoomMeta: r.storedMeta(),
+		Replace:        !r.saved.stored,
+		FirstSeq:       r.timeline.FirstSeq(),
+		Events:         make(map[int]gomatrix.Event),
+	}
+	if update.Replace || r.saved.state {
+		state := r.latestRoomState.toStored()
+		update.State = &state
+	}
+
+	firstSeq, endSeq := r.timeline.FirstSeq(), r.timeline.FirstSeq()+r.timeline.Len()
+	for seq := firstSeq; seq < endSeq; seq++ {
+		if update.Replace || seq < r.saved.firstSeq || seq >= r.saved.endSeq || r.saved.changedSeqs[seq] {
+			update.Events[seq] = *r.timeline.slot(seq - firstSeq)
+		}
+	}
+
+	if update.Replace {
+		update.Relations = r.storedRelations()
+		return update
+	}
+	for eventID, added := range r.saved.relations {
+		if !added {
+			update.RemovedRelations = append(update.RemovedRelations, eventID)
+		} else if ev, found := r.relatedEvent(eventID); found {
+			update.Relations = append(update.Relations, ev)
+		}
+	}
+	return update
+}
+
+func (r *Room) storedRelations() []gomatrix.Event {
+	var relations []gomatrix.Event
+	for _, related := range r.relations {
+		relations = append(relations, related...)
+	}
+	return relations
+}
+
+func (m *Client) newRoomFromStored(stored *StoredRoom) *Room {
+	room := &Room{
+		Client:                          m,
+		ID:                              stored.RoomID,
+		backPaginationToken:             stored.BackPaginationToken,
+		forwardPaginationToken:          stored.ForwardPaginationToken,
+		HasReachedHistoricEndOfTimeline: stored.HasReachedHistoricEndOfTimeline,
+		latestRoomState:                 newRoomStateFromStored(m, stored.State),
+	}
+	// keep the sequence numbers the events are stored by, so that later saves can refer to them.
+	room.timeline.firstSeq = stored.FirstSeq
+	for _, ev := range stored.Events {
+		room.timeline.PushNewest(ev)
+	}
+	for _, bt := range stored.BackTokens {
+		room.backTokens = append(room.backTokens, backToken{bt.Seq, bt.Token})
+	}
+	for _, ev := range stored.Relations {
+		room.addRelation(ev)
+	}
+	room.markSaved()
+	return room
+}
+
+// markSaved records that the RoomStore holds the room as it is now.
+func (r *Room) markSaved() {
+	r.saved = savedRoom{
+		stored:   true,
+		firstSeq: r.timeline.FirstSeq(),
+		endSeq:   r.timeline.FirstSeq() + r.timeline.Len(),
+	}
+	r.dirty = false
+}
+
+// Persist saves what changed of the room to the Client's RoomStore if there is one.
+func (r *Room) Persist() error {
+	if r.Client.Store == nil || !r.dirty {
+		return nil
+	}
+	if err := r.Client.Store.SaveRoom(r.storedUpdate()); err != nil {
+		return err
+	}
+	r.markSaved()
+	return nil
+}
@@ -0,0 +1,183 @@
+package mxclient
+
+import (
+	"context"
+	"github.com/matrix-org/gomatrix"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestBoltRoomStore_RoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "matrix-static-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := NewBoltRoomStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	cli, _ := NewRawClient("https://example.org", "https://media.example.org", "", "")
+	cli.Store = store
+	cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+		// nothing happened whilst the room was not loaded.
+		return jsonResponse(gomatrix.RespMessages{End: "forward2"})
+	})
+
+	if stored, err := store.LoadRoom("!room:example.org"); stored != nil || err != nil {
+		t.Fatal("Unknown room should not be found", stored, err)
+	}
+
+	room := &Room{
+		Client:                 cli,
+		ID:                     "!room:example.org",
+		backPaginationToken:    "back",
+		forwardPaginationToken: "forward",
+		latestRoomState:        *NewRoomState(cli),
+	}
+	room.latestRoomState.UpdateOnEvent(&gomatrix.Event{Type: "m.room.name", StateKey: strPtr(""), Content: map[string]interface{}{"name": "Room"}}, false)
+	room.concatForwardPagination(append(messageEvents(1, 3), gomatrix.Event{
+		ID:       "$member",
+		Type:     "m.room.member",
+		StateKey: strPtr("@alice:example.org"),
+		Content:  map[string]interface{}{"membership": "join", "displayname": "Alice", "avatar_url": "mxc://example.org/alice"},
+	}), "forward")
+
+	if err = room.Persist(); err != nil {
+		t.Fatal(err)
+	}
+	if room.dirty {
+		t.Error("Room should not be dirty once persisted")
+	}
+
+	restored, err := cli.NewRoom(context.Background(), "!room:example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(restored.RoomInfo(), room.RoomInfo()) {
+		t.Error("RoomInfo mismatch expectation", restored.RoomInfo(), room.RoomInfo())
+	}
+	if !reflect.DeepEqual(restored.GetState().Members(), room.GetState().Members()) {
+		t.Error("Members mismatch expectation", restored.GetState().Members(), room.GetState().Members())
+	}
+	expIDs := eventIDs(room.timeline.Range(0, room.timeline.Len()))
+	if ids := eventIDs(restored.timeline.Range(0, restored.timeline.Len())); !reflect.DeepEqual(ids, expIDs) {
+		t.Error("Timeline mismatch expectation", ids, expIDs)
+	}
+	if restored.backPaginationToken != "back" || restored.forwardPaginationToken != "forward2" {
+		t.Error("Pagination tokens mismatch expectation", restored.backPaginationToken, restored.forwardPaginationToken)
+	}
+}
+
+// storedRoundTrip returns room as it is rehydrated from a RoomStore.
+func storedRoundTrip(room *Room) *Room {
+	store := NewMemoryRoomStore(math.MaxInt32)
+	store.SaveRoom(room.storedUpdate())
+	stored, _ := store.LoadRoom(room.ID)
+	return (&Client{}).newRoomFromStored(stored)
+}
+
+func TestRoomStore_IncrementalSaves(t *testing.T) {
+	dir, err := ioutil.TempDir("", "matrix-static-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	boltStore, err := NewBoltRoomStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer boltStore.Close()
+
+	for name, store := range map[string]RoomStore{
+		"bolt":   boltStore,
+		"memory": NewMemoryRoomStore(math.MaxInt32),
+	} {
+		t.Run(name, func(t *testing.T) {
+			cli := &Client{Store: store}
+			room := cli.newLoadedRoom("!room:example.org", nil, "forward")
+			// initial sync of $21-$30, then two back paginations of $11-$20 and $1-$10.
+			room.concatForwardPagination(messageEvents(21, 30), "forward")
+			room.setBackPaginationToken("before21")
+			room.concatBackpagination(messageEvents(20, 11), "before11")
+			room.concatBackpagination(messageEvents(10, 1), "before1")
+			if err := room.Persist(); err != nil {
+				t.Fatal(err)
+			}
+
+			room.concatForwardPagination([]gomatrix.Event{
+				{ID: "$31", Type: "m.room.message"},
+				makeEdit("$e30", "@alice:example.org", "$30", "edited", 1),
+				{ID: "$r", Type: "m.room.redaction", Redacts: "$25"},
+			}, "forward2")
+			room.Trim(21)
+
+			update := room.storedUpdate()
+			if update.Replace || len(update.Events) != 2 || len(update.Relations) != 1 || update.State != nil {
+				t.Error("Update should only hold what changed", update.Replace, len(update.Events), len(update.Relations), update.State)
+			}
+			if err := room.Persist(); err != nil {
+				t.Fatal(err)
+			}
+
+			stored, err := store.LoadRoom("!room:example.org")
+			if err != nil || stored == nil {
+				t.Fatal("Room should be found", stored, err)
+			}
+			restored := cli.newRoomFromStored(stored)
+
+			expIDs := eventIDs(room.timeline.Range(0, room.timeline.Len()))
+			if ids := eventIDs(restored.timeline.Range(0, restored.timeline.Len())); !reflect.DeepEqual(ids, expIDs) {
+				t.Error("Timeline mismatch expectation", ids, expIDs)
+			}
+			if pos, _ := restored.timeline.IndexOf("$25"); len(restored.timeline.At(pos).Content) != 0 {
+				t.Error("Redaction should have been saved", restored.timeline.At(pos))
+			}
+			if edits := restored.relatedEvents("$30", "m.replace"); len(edits) != 1 {
+				t.Error("Edit should have been saved", edits)
+			}
+			if restored.backPaginationToken != "before11" || restored.forwardPaginationToken != "forward2" {
+				t.Error("Pagination tokens mismatch expectation", restored.backPaginationToken, restored.forwardPaginationToken)
+			}
+			// the room can still be trimmed back to where it was initially synced.
+			if restored.MinEvents() != room.MinEvents() || restored.Trim(5) != 10 {
+				t.Error("Restored room should be trimmable", restored.MinEvents(), room.MinEvents())
+			}
+		})
+	}
+}
+
+func TestMemoryRoomStore_Bounded(t *testing.T) {
+	store := NewMemoryRoomStore(15)
+	cli := &Client{Store: store}
+
+	var rooms []*Room
+	for _, roomID := range []string{"!a", "!b", "!c"} {
+		room := cli.newLoadedRoom(roomID, nil, "forward")
+		room.concatForwardPagination(messageEvents(1, 6), "forward")
+		if err := room.Persist(); err != nil {
+			t.Fatal(err)
+		}
+		rooms = append(rooms, room)
+	}
+
+	// the least recently saved room makes way for the others.
+	if stored, _ := store.LoadRoom("!a"); stored != nil {
+		t.Error("Oldest room should have been forgotten")
+	}
+	if stored, _ := store.LoadRoom("!c"); stored == nil || len(stored.Events) != 6 {
+		t.Error("Newest room should be kept", stored)
+	}
+	if store.numEvents != 12 {
+		t.Error("Event count mismatch expectation", store.numEvents)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mxclient
+
+import (
+	"context"
+	"encoding/json"
+	log "github.com/Sirupsen/logrus"
+	"github.com/matrix-org/gomatrix"
+	"strconv"
+	"time"
+)
+
+// SyncJoinedRoom is the portion of a joined room in a /sync response which we need to keep a Room up to date.
+type SyncJoinedRoom struct {
+	State struct {
+		Events []gomatrix.Event `json:"events"`
+	} `json:"state"`
+	Timeline struct {
+		Events    []gomatrix.Event `json:"events"`
+		Limited   bool             `json:"limited"`
+		PrevBatch string           `json:"prev_batch"`
+	} `json:"timeline"`
+}
+
+// This is a Truncated RespSync as we only need SOME information from it.
+type RespSync struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]SyncJoinedRoom `json:"join"`
+	} `json:"rooms"`
+}
+
+// SyncTimeout is how long the homeserver may hold a /sync open, must be comfortably below the http.Client Timeout.
+const SyncTimeout = 20 * time.Second
+
+// SyncTimelineLimit is the most timeline events per room we accept in a single /sync response, anything beyond is
+// fetched through forward pagination once the response marks the timeline as limited.
+const SyncTimelineLimit = 50
+
+// syncRetryDelay is how long to wait before polling again after a failed /sync or while there are no rooms to sync.
+const syncRetryDelay = 10 * time.Second
+
+// LongPollSync makes an HTTP request according to https://matrix.org/docs/spec/client_server/r0.6.0#get-matrix-client-r0-sync
+// filter may be either a filter ID or an inline JSON filter.
+func (m *Client) LongPollSync(ctx context.Context, since, filter string, timeout time.Duration) (resp *RespSync, err error) {
+	query := map[string]string{
+		"timeout":      strconv.FormatInt(int64(timeout/time.Millisecond), 10),
+		"filter":       filter,
+		"set_presence": "offline",
+	}
+	if since != "" {
+		query["since"] = since
+	}
+
+	urlPath := m.BuildURLWithQuery([]string{"sync"}, query)
+	err = m.MakeRequestWithContext(ctx, "GET", urlPath, nil, &resp)
+	return
+}
+
+// roomSyncFilter builds an inline /sync filter which only includes the timeline and state of the given rooms.
+func roomSyncFilter(roomIDs []string) string {
+	excludeAll := map[string][]string{"not_types": {"*"}}
+	filter := map[string]interface{}{
+		"account_data": excludeAll,
+		"presence":     excludeAll,
+		"room": map[string]interface{}{
+			"rooms":         roomIDs,
+			"account_data":  excludeAll,
+			"ephemeral":     excludeAll,
+			"include_leave": false,
+			"timeline": map[string]interface{}{
+				"limit": SyncTimelineLimit,
+			},
+		},
+	}
+
+	data, _ := json.Marshal(filter)
+	return string(data)
+}
+
+// RoomSyncHandler is called with each joined room found in a /sync response along with that response's next_batch.
+type RoomSyncHandler func(roomID string, room SyncJoinedRoom, nextBatch string)
+
+// SyncLoop long-polls /sync for the rooms returned by roomIDs, which is consulted before every request so the filter
+// follows rooms being loaded and discarded, and hands each room's new events to handle. It runs until ctx is done.
+func (m *Client) SyncLoop(ctx context.Context, roomIDs func(ctx context.Context) []string, handle RoomSyncHandler) {
+	var since string
+	for ctx.Err() == nil {
+		ids := roomIDs(ctx)
+		if len(ids) == 0 {
+			sleepContext(ctx, syncRetryDelay)
+			continue
+		}
+
+		resp, err := m.LongPollSync(ctx, since, roomSyncFilter(ids), SyncTimeout)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.WithError(err).Error("Failed to Sync")
+				sleepContext(ctx, syncRetryDelay)
+			}
+			continue
+		}
+
+		for roomID, room := range resp.Rooms.Join {
+			handle(roomID, room, resp.NextBatch)
+		}
+		since = resp.NextBatch
+	}
+}
+
+// sleepContext sleeps for d or until ctx is done, whichever comes first.
+func sleepContext(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,100 @@
+package mxclient
+
+import (
+	"context"
+	"github.com/matrix-org/gomatrix"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRoom_ApplySync(t *testing.T) {
+	homeserver := &forwardHomeserver{numEvents: 30, pageCap: 100}
+	cli, _ := NewRawClient("https://example.org", "", "@bot:example.org", "")
+	cli.Client.Client.Transport = RoundTripFunc(homeserver.RoundTrip)
+
+	room := &Room{Client: cli, ID: "!room", latestRoomState: *NewRoomState(cli)}
+	room.concatForwardPagination(messageEvents(1, 10), "after10")
+
+	// the first sync since loading leaves a gap behind it, even if not limited.
+	f := room.NeededSyncForwardpagination(false)
+	if f == nil || f.From != "after10" {
+		t.Fatal("Forwardpagination mismatch expectation", f)
+	}
+	resp, err := cli.FetchForwardpagination(context.Background(), f)
+	if err != nil {
+		t.Fatal("Failed forward paginating", err)
+	}
+	room.ApplyForwardpagination(f, resp)
+
+	var sync SyncJoinedRoom
+	sync.State.Events = []gomatrix.Event{
+		stateEvent("m.room.member", "@bot:example.org", map[string]interface{}{"membership": "join"}),
+	}
+	// the gap fill raced ahead of the sync position, so the sync repeats events already held.
+	name := stateEvent("m.room.name", "", map[string]interface{}{"name": "Synced"})
+	name.ID = "$31"
+	sync.Timeline.Events = append(messageEvents(29, 30), name)
+	room.ApplySync(sync, "batch1")
+
+	if ids := eventIDs(room.timeline.Range(0, room.timeline.Len())); !reflect.DeepEqual(ids, eventIDs(messageEvents(31, 1))) {
+		t.Error("Timeline mismatch expectation", ids)
+	}
+	if info := room.RoomInfo(); info.Name != "Synced" {
+		t.Error("State events of the timeline should update the state", info.Name)
+	}
+	if !room.IsJoined() {
+		t.Error("State events of the sync should update the state")
+	}
+
+	if f := room.NeededSyncForwardpagination(false); f != nil {
+		t.Error("Should not fill a gap once synced", f)
+	}
+	if f := room.NeededSyncForwardpagination(true); f == nil || f.From != "batch1" {
+		t.Error("Should fill the gap before a limited timeline from the last sync", f)
+	}
+}
+
+func TestClient_SyncLoop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var requests []*http.Request
+	cli, _ := NewRawClient("https://example.org", "", "@bot:example.org", "")
+	cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+		requests = append(requests, req)
+		if req.URL.Query().Get("since") == "" {
+			return jsonResponse(map[string]interface{}{
+				"next_batch": "batch1",
+				"rooms": map[string]interface{}{"join": map[string]interface{}{
+					"!room": map[string]interface{}{"timeline": map[string]interface{}{
+						"events": messageEvents(1, 2), "limited": true,
+					}},
+				}},
+			})
+		}
+		cancel()
+		return jsonResponse(map[string]interface{}{"next_batch": "batch2"})
+	})
+
+	var synced []SyncJoinedRoom
+	cli.SyncLoop(ctx, func(ctx context.Context) []string {
+		return []string{"!room"}
+	}, func(roomID string, room SyncJoinedRoom, nextBatch string) {
+		if roomID != "!room" || nextBatch != "batch1" {
+			t.Error("Sync mismatch expectation", roomID, nextBatch)
+		}
+		synced = append(synced, room)
+	})
+
+	if len(synced) != 1 || !synced[0].Timeline.Limited || len(synced[0].Timeline.Events) != 2 {
+		t.Fatal("Synced rooms mismatch expectation", synced)
+	}
+	if len(requests) != 2 || requests[1].URL.Query().Get("since") != "batch1" {
+		t.Fatal("Should sync on from the last next_batch", requests)
+	}
+	if filter := requests[0].URL.Query().Get("filter"); !strings.Contains(filter, `"rooms":["!room"]`) {
+		t.Error("Filter should only include the loaded rooms", filter)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mxclient
+
+import (
+	"context"
+	"errors"
+	"github.com/matrix-org/gomatrix"
+)
+
+// IsThreadReply returns whether ev is a reply within the thread rooted at another event.
+func IsThreadReply(ev gomatrix.Event) bool {
+	relType, eventID := relation(ev)
+	return relType == "m.thread" && eventID != ""
+}
+
+// ThreadSummary describes the thread rooted at an event in the timeline, its replies being kept out of the timeline.
+type ThreadSummary struct {
+	Count int
+}
+
+// Threads summarises the threads rooted at each of events, events without replies are omitted.
+func (r *Room) Threads(events []gomatrix.Event) map[string]ThreadSummary {
+	threads := make(map[string]ThreadSummary)
+	for _, ev := range events {
+		// the server counts replies we have not seen, whereas we may have synced ones sent since it counted.
+		count := len(r.relatedEvents(ev.ID, "m.thread"))
+		if serverCount := serverAggregatedThreadCount(&ev); serverCount > count {
+			count = serverCount
+		}
+
+		if count > 0 {
+			threads[ev.ID] = ThreadSummary{count}
+		}
+	}
+	return threads
+}
+
+func serverAggregatedThreadCount(ev *gomatrix.Event) int {
+	relations, _ := ev.Unsigned["m.relations"].(map[string]interface{})
+	thread, _ := relations["m.thread"].(map[string]interface{})
+	count, _ := thread["count"].(float64)
+	return int(count)
+}
+
+// Thread is a page of the replies to an event, which are not held in the timeline but fetched as needed.
+type Thread struct {
+	Root gomatrix.Event
+	// Replies are newest first, as the timeline is.
+	Replies []gomatrix.Event
+	// NextBatch is the token to fetch the page of older replies with, empty if there are none.
+	NextBatch string
+}
+
+// ErrRelationsUnsupported is returned by FetchThread if the server cannot be asked for the replies to an event.
+var ErrRelationsUnsupported = errors.New("server does not support fetching relations")
+
+// FetchThread fetches the root with the given ID and a page of its replies, starting at the latest unless from is
+// a NextBatch token.
+func (m *Client) FetchThread(ctx context.Context, roomID, rootID, from string, limit int) (*Thread, error) {
+	root, err := m.RoomEvent(ctx, roomID, rootID)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := m.RoomRelations(ctx, roomID, rootID, "m.thread", from, limit)
+	if isUnsupportedEndpoint(err) {
+		return nil, ErrRelationsUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Thread{*root, resp.Chunk, resp.NextBatch}, nil
+}
+
+// LocalThread returns the thread rooted at the event with the given ID from the replies held in memory, for when the
+// server cannot be asked for them, see ErrRelationsUnsupported.
+func (r *Room) LocalThread(rootID string) (*Thread, bool) {
+	root, found := r.findEvent(rootID)
+	if !found {
+		return nil, false
+	}
+
+	replies := r.relatedEvents(rootID, "m.thread")
+	newestFirst := make([]gomatrix.Event, 0, len(replies))
+	for i := len(replies) - 1; i >= 0; i-- {
+		newestFirst = append(newestFirst, replies[i])
+	}
+	return &Thread{Root: root, Replies: newestFirst}, true
+}
@@ -0,0 +1,79 @@
+package mxclient
+
+import (
+	"context"
+	"github.com/matrix-org/gomatrix"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func makeThreadReply(id, rootID string) gomatrix.Event {
+	return gomatrix.Event{
+		ID:   id,
+		Type: "m.room.message",
+		Content: map[string]interface{}{
+			"msgtype":      "m.text",
+			"body":         "reply",
+			"m.relates_to": map[string]interface{}{"rel_type": "m.thread", "event_id": rootID},
+		},
+	}
+}
+
+func TestRoom_Threads(t *testing.T) {
+	root := gomatrix.Event{ID: "$1", Type: "m.room.message", Content: map[string]interface{}{"msgtype": "m.text", "body": "hi"}}
+	aggregated := gomatrix.Event{ID: "$2", Type: "m.room.message", Content: map[string]interface{}{"msgtype": "m.text", "body": "hey"},
+		Unsigned: map[string]interface{}{"m.relations": map[string]interface{}{"m.thread": map[string]interface{}{"count": float64(5)}}}}
+
+	room := &Room{latestRoomState: *NewRoomState(nil)}
+	room.concatForwardPagination([]gomatrix.Event{
+		root,
+		makeThreadReply("$t1", "$1"),
+		aggregated,
+		makeThreadReply("$t2", "$1"),
+		makeThreadReply("$t3", "$2"),
+	}, "forward")
+	room.HasReachedHistoricEndOfTimeline = true
+
+	events, _, _, err := room.GetEventPage(context.Background(), "$t1", 0, 10)
+	if err != nil {
+		t.Fatal("Failed getting page", err)
+	}
+	if ids := eventIDs(events); !reflect.DeepEqual(ids, []string{"$1"}) {
+		t.Error("Thread replies should be kept out of the timeline, anchoring on their root", ids)
+	}
+
+	expected := map[string]ThreadSummary{"$1": {2}, "$2": {5}}
+	if threads := room.Threads([]gomatrix.Event{root, aggregated}); !reflect.DeepEqual(threads, expected) {
+		t.Error("Thread summaries mismatch expectation", threads)
+	}
+}
+
+func TestClient_FetchThread(t *testing.T) {
+	var paths []string
+	cli, _ := NewRawClient("https://example.org", "", "", "")
+	cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+		paths = append(paths, req.URL.Path)
+		if req.URL.Path == "/_matrix/client/r0/rooms/!room/event/$1" {
+			return jsonResponse(gomatrix.Event{ID: "$1", Type: "m.room.message"})
+		}
+		if q := req.URL.Query(); q.Get("from") != "older" || q.Get("limit") != "2" {
+			t.Error("Should fetch the page asked for", req.URL.RawQuery)
+		}
+		return jsonResponse(map[string]interface{}{
+			"chunk":      []gomatrix.Event{makeThreadReply("$t3", "$1"), makeThreadReply("$t2", "$1")},
+			"next_batch": "oldest",
+		})
+	})
+
+	thread, err := cli.FetchThread(context.Background(), "!room", "$1", "older", 2)
+	if err != nil {
+		t.Fatal("Failed fetching thread", err)
+	}
+	if !reflect.DeepEqual(paths, []string{"/_matrix/client/r0/rooms/!room/event/$1", "/_matrix/client/v1/rooms/!room/relations/$1/m.thread"}) {
+		t.Error("Requests mismatch expectation", paths)
+	}
+	if thread.Root.ID != "$1" || !reflect.DeepEqual(eventIDs(thread.Replies), []string{"$t3", "$t2"}) || thread.NextBatch != "oldest" {
+		t.Error("Thread mismatch expectation", thread)
+	}
+}
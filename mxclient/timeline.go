@@ -0,0 +1,155 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mxclient
+
+import (
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/utils"
+)
+
+const timelineChunkSize = 256
+
+type timelineChunk [timelineChunkSize]gomatrix.Event
+
+// timeline is a chunked deque of events with an index on event ID.
+// Events are addressed by position, where position 0 is the latest event we know, so that both
+// forward and back pagination are cheap appends and finding an event is a map lookup.
+// The zero value is an empty timeline ready to use.
+type timeline struct {
+	// chunks hold the events in chronological order, starting at chunks[0][head].
+	chunks []*timelineChunk
+	head   int
+	length int
+
+	// seqs maps event ID to the sequence number of the event, which stays stable as events are added at either end.
+	// The oldest event has sequence number firstSeq, the next oldest firstSeq+1 and so on.
+	seqs     map[string]int
+	firstSeq int
+}
+
+// Len returns the number of events in the timeline.
+func (t *timeline) Len() int {
+	return t.length
+}
+
+// slot returns the address of the event at chronological index i, where 0 is the oldest event.
+func (t *timeline) slot(i int) *gomatrix.Event {
+	abs := t.head + i
+	return &t.chunks[abs/timelineChunkSize][abs%timelineChunkSize]
+}
+
+// At returns the event at position pos, where 0 is the latest event.
+func (t *timeline) At(pos int) gomatrix.Event {
+	return *t.slot(t.length - 1 - pos)
+}
+
+// Set replaces the event at position pos, where 0 is the latest event.
+func (t *timeline) Set(pos int, ev gomatrix.Event) {
+	*t.slot(t.length - 1 - pos) = ev
+}
+
+// IndexOf returns the position of the event with the given ID.
+func (t *timeline) IndexOf(eventID string) (pos int, found bool) {
+	seq, found := t.seqs[eventID]
+	if !found {
+		return 0, false
+	}
+	return t.length - 1 - (seq - t.firstSeq), true
+}
+
+// Range returns a copy of the events from position start up to but excluding end, latest first.
+func (t *timeline) Range(start, end int) []gomatrix.Event {
+	if start >= end {
+		return nil
+	}
+	events := make([]gomatrix.Event, 0, end-start)
+	for pos := start; pos < end; pos++ {
+		events = append(events, t.At(pos))
+	}
+	return events
+}
+
+func (t *timeline) index(ev gomatrix.Event, seq int) {
+	if t.seqs == nil {
+		t.seqs = make(map[string]int)
+	}
+	t.seqs[ev.ID] = seq
+}
+
+// PushNewest adds an event newer than all others, it will become position 0.
+func (t *timeline) PushNewest(ev gomatrix.Event) {
+	if t.head+t.length == len(t.chunks)*timelineChunkSize {
+		t.chunks = append(t.chunks, new(timelineChunk))
+	}
+	*t.slot(t.length) = ev
+	t.index(ev, t.firstSeq+t.length)
+	t.length++
+}
+
+// PushOldest adds an event older than all others, it will become position Len()-1.
+func (t *timeline) PushOldest(ev gomatrix.Event) {
+	if t.head == 0 {
+		t.chunks = append([]*timelineChunk{new(timelineChunk)}, t.chunks...)
+		t.head = timelineChunkSize
+	}
+	t.head--
+	t.length++
+	t.firstSeq--
+	*t.slot(0) = ev
+	t.index(ev, t.firstSeq)
+}
+
+// backwardRange returns up to number events older than position anchorIndex+offset, inclusive, latest first.
+func (t *timeline) backwardRange(anchorIndex, offset, number int) []gomatrix.Event {
+	startIndex := utils.Min(anchorIndex+offset, t.length)
+	return t.Range(startIndex, utils.Min(startIndex+number, t.length))
+}
+
+// forwardRange returns up to number events ending offset events newer than position anchorIndex, latest first.
+func (t *timeline) forwardRange(anchorIndex, offset, number int) []gomatrix.Event {
+	topIndex := utils.Bound(0, anchorIndex+number-offset, t.length)
+	return t.Range(utils.Max(topIndex-number, 0), topIndex)
+}
+
+// Seq returns the sequence number of the event at position pos.
+func (t *timeline) Seq(pos int) int {
+	return t.firstSeq + t.length - 1 - pos
+}
+
+// FirstSeq returns the sequence number of the oldest event, later pushes of older events will decrease it.
+func (t *timeline) FirstSeq() int {
+	return t.firstSeq
+}
+
+// TrimOldest discards the n oldest events.
+func (t *timeline) TrimOldest(n int) {
+	n = utils.Min(n, t.length)
+	for i := 0; i < n; i++ {
+		slot := t.slot(i)
+		delete(t.seqs, slot.ID)
+		*slot = gomatrix.Event{}
+	}
+
+	t.head += n
+	t.length -= n
+	t.firstSeq += n
+
+	// release chunks which no longer hold any events.
+	for t.head >= timelineChunkSize {
+		t.chunks[0] = nil
+		t.chunks = t.chunks[1:]
+		t.head -= timelineChunkSize
+	}
+}
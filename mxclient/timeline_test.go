@@ -0,0 +1,112 @@
+package mxclient
+
+import (
+	"github.com/matrix-org/gomatrix"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func makeEvent(n int) gomatrix.Event {
+	return gomatrix.Event{ID: "$" + strconv.Itoa(n)}
+}
+
+func eventIDs(events []gomatrix.Event) []string {
+	ids := make([]string, 0, len(events))
+	for _, ev := range events {
+		ids = append(ids, ev.ID)
+	}
+	return ids
+}
+
+func TestTimeline(t *testing.T) {
+	var tl timeline
+
+	// grow in both directions across several chunks, events are numbered chronologically.
+	const numEachWay = timelineChunkSize*2 + 10
+	for i := 0; i < numEachWay; i++ {
+		tl.PushNewest(makeEvent(i))
+		tl.PushOldest(makeEvent(-1 - i))
+	}
+
+	if tl.Len() != numEachWay*2 {
+		t.Fatal("Length mismatch expectation", tl.Len(), numEachWay*2)
+	}
+
+	for pos := 0; pos < tl.Len(); pos++ {
+		exp := makeEvent(numEachWay - 1 - pos)
+		if ev := tl.At(pos); ev.ID != exp.ID {
+			t.Fatal("Event at position mismatch expectation", pos, ev.ID, exp.ID)
+		}
+		if index, found := tl.IndexOf(exp.ID); !found || index != pos {
+			t.Fatal("IndexOf mismatch expectation", exp.ID, index, pos)
+		}
+	}
+
+	if _, found := tl.IndexOf("$unknown"); found {
+		t.Error("Unknown event should not be found")
+	}
+
+	expRange := eventIDs([]gomatrix.Event{makeEvent(numEachWay - 1), makeEvent(numEachWay - 2), makeEvent(numEachWay - 3)})
+	if ids := eventIDs(tl.Range(0, 3)); !reflect.DeepEqual(ids, expRange) {
+		t.Error("Range mismatch expectation", ids, expRange)
+	}
+
+	tl.Set(1, gomatrix.Event{ID: makeEvent(numEachWay - 2).ID, Type: "replaced"})
+	if ev := tl.At(1); ev.Type != "replaced" {
+		t.Error("Set did not replace event", ev)
+	}
+}
+
+const benchmarkTimelineSize = 200000
+
+// BenchmarkTimeline_IndexOf and BenchmarkSlice_LinearScan compare looking up an event deep in history
+// with the index against scanning a slice as Room used to.
+func BenchmarkTimeline_IndexOf(b *testing.B) {
+	var tl timeline
+	for i := 0; i < benchmarkTimelineSize; i++ {
+		tl.PushNewest(makeEvent(i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tl.IndexOf("$0")
+	}
+}
+
+func BenchmarkSlice_LinearScan(b *testing.B) {
+	var events []gomatrix.Event
+	for i := benchmarkTimelineSize - 1; i >= 0; i-- {
+		events = append(events, makeEvent(i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, ev := range events {
+			if ev.ID == "$0" {
+				break
+			}
+		}
+	}
+}
+
+// BenchmarkTimeline_PushNewest and BenchmarkSlice_Prepend compare adding new events to the front of a large timeline.
+func BenchmarkTimeline_PushNewest(b *testing.B) {
+	var tl timeline
+	for i := 0; i < benchmarkTimelineSize; i++ {
+		tl.PushNewest(makeEvent(i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tl.PushNewest(makeEvent(benchmarkTimelineSize + i))
+	}
+}
+
+func BenchmarkSlice_Prepend(b *testing.B) {
+	var events []gomatrix.Event
+	for i := benchmarkTimelineSize - 1; i >= 0; i-- {
+		events = append(events, makeEvent(i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		events = append([]gomatrix.Event{makeEvent(benchmarkTimelineSize + i)}, events...)
+	}
+}
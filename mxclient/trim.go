@@ -0,0 +1,80 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mxclient
+
+type backToken struct {
+	// seq is the timeline sequence number of the oldest event at the time the token was received.
+	seq   int
+	token string
+}
+
+func (r *Room) setBackPaginationToken(token string) {
+	r.backPaginationToken = token
+	// an empty token means the start of history was reached, so there is nothing to resume from after trimming.
+	if token != "" {
+		r.backTokens = append(r.backTokens, backToken{r.timeline.FirstSeq(), token})
+	}
+}
+
+// NumEvents returns the number of events held in memory for this room, including detached fragments.
+func (r *Room) NumEvents() int {
+	num := r.timeline.Len()
+	for _, f := range r.fragments {
+		num += f.timeline.Len()
+	}
+	return num
+}
+
+// MinEvents returns the fewest events Trim is able to leave this room with.
+func (r *Room) MinEvents() int {
+	if len(r.backTokens) == 0 {
+		return r.timeline.Len()
+	}
+	// the first token was received before any back pagination so is the newest position we can trim back to.
+	return r.timeline.Len() - (r.backTokens[0].seq - r.timeline.FirstSeq())
+}
+
+// Trim discards detached fragments and the oldest back paginated events so that the room holds at most maxEvents,
+// or as close to it as possible as the timeline can only be cut where we hold a token to back paginate from again.
+// It returns the number of events discarded.
+func (r *Room) Trim(maxEvents int) int {
+	numBefore := r.NumEvents()
+	r.fragments = nil
+	r.replyParents = nil
+	// rather than unindexing what is cut, the index is built again from what is left when next searched.
+	r.searchIndex = nil
+	defer r.pruneRelations()
+
+	// find the oldest position we can cut at whilst staying within maxEvents, tokens are ordered newest position first.
+	newestSeq := r.timeline.FirstSeq() + r.timeline.Len() - 1
+	cut := 0
+	for i, bt := range r.backTokens {
+		if newestSeq-bt.seq+1 > maxEvents {
+			break
+		}
+		cut = i
+	}
+
+	if len(r.backTokens) > 0 && r.backTokens[cut].seq > r.timeline.FirstSeq() {
+		bt := r.backTokens[cut]
+		r.timeline.TrimOldest(bt.seq - r.timeline.FirstSeq())
+		r.backPaginationToken = bt.token
+		r.backTokens = r.backTokens[:cut+1]
+		r.HasReachedHistoricEndOfTimeline = false
+		r.markDirty()
+	}
+
+	return numBefore - r.NumEvents()
+}
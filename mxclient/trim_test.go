@@ -0,0 +1,51 @@
+package mxclient
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRoom_Trim(t *testing.T) {
+	room := &Room{latestRoomState: *NewRoomState(nil)}
+	// initial sync of $21-$30, then two back paginations of $11-$20 and $1-$10.
+	room.concatForwardPagination(messageEvents(21, 30), "forward")
+	room.setBackPaginationToken("before21")
+	room.concatBackpagination(messageEvents(20, 11), "before11")
+	room.concatBackpagination(messageEvents(10, 1), "before1")
+	room.HasReachedHistoricEndOfTimeline = true
+	room.fragments = []*eventFragment{{}}
+	room.fragments[0].timeline.PushNewest(messageEvents(100, 100)[0])
+
+	if room.NumEvents() != 31 || room.MinEvents() != 10 {
+		t.Fatal("Event counts mismatch expectation", room.NumEvents(), room.MinEvents())
+	}
+	// rooms loaded from the store remember where they can be trimmed back to.
+	if restored := storedRoundTrip(room); restored.MinEvents() != 10 {
+		t.Error("Restored room should be as trimmable", restored.MinEvents())
+	}
+
+	// can only cut at $11 so 25 leaves 20 events.
+	if removed := room.Trim(25); removed != 11 {
+		t.Error("Removed mismatch expectation", removed)
+	}
+	if ids := eventIDs(room.timeline.Range(0, room.timeline.Len())); !reflect.DeepEqual(ids, eventIDs(messageEvents(30, 11))) {
+		t.Error("Timeline mismatch expectation", ids)
+	}
+	if room.backPaginationToken != "before11" || room.HasReachedHistoricEndOfTimeline {
+		t.Error("Trimmed room should resume back paginating from the cut", room.backPaginationToken)
+	}
+	if _, found := room.timeline.IndexOf("$5"); found {
+		t.Error("Trimmed events should no longer be indexed")
+	}
+	if len(room.fragments) != 0 {
+		t.Error("Fragments should have been discarded")
+	}
+
+	// nothing can be trimmed beyond the initial sync.
+	if removed := room.Trim(5); removed != 10 || room.timeline.Len() != 10 || room.backPaginationToken != "before21" {
+		t.Error("Room should have been trimmed back to its initial sync", removed, room.timeline.Len(), room.backPaginationToken)
+	}
+	if removed := room.Trim(5); removed != 0 {
+		t.Error("Room should not be trimmed past its initial sync", removed)
+	}
+}
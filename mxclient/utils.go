@@ -14,7 +14,10 @@
 
 package mxclient
 
-import "github.com/matrix-org/gomatrix"
+import (
+	"github.com/matrix-org/gomatrix"
+	"net/http"
+)
 
 // Keeping here in case it becomes used again.
 //func ConcatEventsSlices(slices ...[]gomatrix.Event) []gomatrix.Event {
@@ -47,6 +50,18 @@ func UnwrapRespError(err error) (respErr gomatrix.RespError, respErrOk bool) {
 	return
 }
 
+// isUnsupportedEndpoint returns whether err indicates that the server does not implement the request made of it.
+func isUnsupportedEndpoint(err error) bool {
+	httpErr, ok := err.(gomatrix.HTTPError)
+	if !ok {
+		return false
+	}
+	if respErr, ok := httpErr.WrappedError.(gomatrix.RespError); ok {
+		return respErr.ErrCode == "M_UNRECOGNIZED"
+	}
+	return httpErr.Code == http.StatusNotFound || httpErr.Code == http.StatusMethodNotAllowed
+}
+
 var textForRespError = map[string]string{
 	"M_GUEST_ACCESS_FORBIDDEN": "This Room does not exist or does not permit guests to access it.",
 }
@@ -69,8 +84,8 @@ func ShouldHideEvent(ev gomatrix.Event) bool {
 	// m.room.canonical_alias
 
 	if ev.StateKey == nil {
-		// Message Event
-		if ev.Type == "m.room.message" {
+		// Message Event, edits are shown in place of the event they edit.
+		if ev.Type == "m.room.message" && !IsEdit(ev) {
 			return false
 		}
 	} else {
@@ -85,7 +100,11 @@ func ShouldHideEvent(ev gomatrix.Event) bool {
 			return false
 		}
 
-		if ev.Type == "im.vector.modular.widgets" {
+		if ev.Type == "im.vector.modular.widgets" || ev.Type == "m.room.tombstone" {
+			return false
+		}
+		// only the creation of rooms which continue another is of interest.
+		if ev.Type == "m.room.create" && ev.Content["predecessor"] != nil {
 			return false
 		}
 	}
@@ -0,0 +1,128 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package oembed implements the provider side of the oEmbed spec (https://oembed.com/) for links to rooms and their
+// events, which are embedded as an iframe of the compact view of the room served at /room/:roomID/embed.
+package oembed
+
+import (
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const Version = "1.0"
+const ProviderName = "Matrix Static"
+
+// DefaultWidth and DefaultHeight are the size of the iframe unless the consumer asks for at most less.
+const DefaultWidth = 500
+const DefaultHeight = 600
+
+// Response is the JSON body of a "rich" type oEmbed response, the only type offered.
+type Response struct {
+	Type         string `json:"type"`
+	Version      string `json:"version"`
+	Title        string `json:"title,omitempty"`
+	ProviderName string `json:"provider_name"`
+	ProviderURL  string `json:"provider_url"`
+	HTML         string `json:"html"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+// Target is what a link embeds, a room and optionally an event in it to show the timeline around.
+type Target struct {
+	RoomID  string
+	EventID string
+}
+
+// ParseURL returns what rawURL links to if it is a room or event permalink of the instance served at baseURL, in any
+// of the forms /room/:roomID/, /room/:roomID/$eventID or /room/:roomID/?highlight=$eventID (or ?anchor=$eventID).
+// The schemes are not compared, as reverse proxies commonly serve the same pages over both http and https.
+func ParseURL(rawURL, baseURL string) (Target, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Target{}, false
+	}
+	base, err := url.Parse(baseURL)
+	if err != nil || !strings.EqualFold(u.Host, base.Host) {
+		return Target{}, false
+	}
+
+	prefix := strings.TrimSuffix(base.Path, "/") + "/room/"
+	if !strings.HasPrefix(u.Path, prefix) {
+		return Target{}, false
+	}
+	path := strings.TrimPrefix(u.Path, prefix)
+
+	i := strings.IndexByte(path, '/')
+	if i < 0 {
+		return Target{}, false
+	}
+	t := Target{RoomID: path[:i]}
+	if !strings.HasPrefix(t.RoomID, "!") {
+		return Target{}, false
+	}
+
+	switch rest := path[i+1:]; {
+	case rest == "":
+		query := u.Query()
+		t.EventID = query.Get("highlight")
+		if t.EventID == "" {
+			t.EventID = query.Get("anchor")
+		}
+	case strings.HasPrefix(rest, "$"):
+		t.EventID = rest
+	default:
+		return Target{}, false
+	}
+	return t, true
+}
+
+// EmbedURL returns the address of the compact view of t on the instance served at baseURL.
+func (t Target) EmbedURL(baseURL string) string {
+	embedURL := baseURL + "/room/" + url.PathEscape(t.RoomID) + "/embed"
+	if t.EventID != "" {
+		embedURL += "?anchor=" + url.QueryEscape(t.EventID)
+	}
+	return embedURL
+}
+
+// NewResponse returns the response embedding t from the instance served at baseURL, at its default size unless
+// maxWidth or maxHeight are positive and smaller.
+func NewResponse(t Target, baseURL, title string, maxWidth, maxHeight int) Response {
+	width, height := DefaultWidth, DefaultHeight
+	if maxWidth > 0 && maxWidth < width {
+		width = maxWidth
+	}
+	if maxHeight > 0 && maxHeight < height {
+		height = maxHeight
+	}
+
+	iframe := `<iframe src="` + html.EscapeString(t.EmbedURL(baseURL)) + `"` +
+		` width="` + strconv.Itoa(width) + `" height="` + strconv.Itoa(height) + `"` +
+		` title="` + html.EscapeString(title) + `" frameborder="0"></iframe>`
+
+	return Response{
+		Type:         "rich",
+		Version:      Version,
+		Title:        title,
+		ProviderName: ProviderName,
+		ProviderURL:  baseURL + "/",
+		HTML:         iframe,
+		Width:        width,
+		Height:       height,
+	}
+}
@@ -0,0 +1,45 @@
+package oembed
+
+import (
+	"testing"
+)
+
+func TestParseURL(t *testing.T) {
+	const base = "https://static.example.org/matrix"
+	tests := []struct {
+		url   string
+		exp   Target
+		expOk bool
+	}{
+		{"https://static.example.org/matrix/room/!r:b/", Target{RoomID: "!r:b"}, true},
+		{"http://Static.Example.org/matrix/room/%21r:b/", Target{RoomID: "!r:b"}, true},
+		{"https://static.example.org/matrix/room/!r:b/$e1", Target{RoomID: "!r:b", EventID: "$e1"}, true},
+		{"https://static.example.org/matrix/room/!r:b/?highlight=$e1&anchor=$e2", Target{RoomID: "!r:b", EventID: "$e1"}, true},
+		{"https://static.example.org/matrix/room/!r:b/?anchor=%24e2&offset=-10", Target{RoomID: "!r:b", EventID: "$e2"}, true},
+		{"https://static.example.org/matrix/room/!r:b/members", Target{}, false},
+		{"https://static.example.org/matrix/room/!r:b", Target{}, false},
+		{"https://static.example.org/matrix/room/#a:b/", Target{}, false},
+		{"https://static.example.org/room/!r:b/", Target{}, false},
+		{"https://example.org/matrix/room/!r:b/", Target{}, false},
+		{"not a url\x7f", Target{}, false},
+	}
+	for _, tt := range tests {
+		if got, ok := ParseURL(tt.url, base); got != tt.exp || ok != tt.expOk {
+			t.Errorf("ParseURL(%q) = %+v, %t, want %+v, %t", tt.url, got, ok, tt.exp, tt.expOk)
+		}
+	}
+}
+
+func TestNewResponse(t *testing.T) {
+	resp := NewResponse(Target{RoomID: "!r:b", EventID: "$e&1"}, "https://example.org", `Room "A"`, 300, 0)
+	if resp.Width != 300 || resp.Height != DefaultHeight {
+		t.Errorf("NewResponse() size = %dx%d, want %dx%d", resp.Width, resp.Height, 300, DefaultHeight)
+	}
+	exp := `<iframe src="https://example.org/room/%21r:b/embed?anchor=%24e%261" width="300" height="600" title="Room &#34;A&#34;" frameborder="0"></iframe>`
+	if resp.HTML != exp {
+		t.Errorf("NewResponse().HTML = %s, want %s", resp.HTML, exp)
+	}
+	if resp.ProviderURL != "https://example.org/" {
+		t.Errorf("NewResponse().ProviderURL = %s, want %s", resp.ProviderURL, "https://example.org/")
+	}
+}
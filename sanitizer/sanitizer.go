@@ -26,6 +26,7 @@ type Sanitizer struct {
 }
 
 // Sanitize will parse and clean up the HTML of the input string, then sanitize allowed tags.
+// Reply fallbacks are removed entirely as the event replied to is rendered separately.
 // TODO consider passing err back out of this instead of just ok
 func (s *Sanitizer) Sanitize(str string) (sanitizedStr string, ok bool) {
 	reader := strings.NewReader(str)
@@ -35,12 +36,46 @@ func (s *Sanitizer) Sanitize(str string) (sanitizedStr string, ok bool) {
 		return "", false
 	}
 
+	body := root.FirstChild.LastChild
+	removeElements(body, "mx-reply")
+
 	var b bytes.Buffer
-	html.Render(&b, root.FirstChild.LastChild)
+	html.Render(&b, body)
 
 	return string(s.SanitizeBytes(b.Bytes())), true
 }
 
+// removeElements removes the elements with the given tag from beneath n, including their contents.
+func removeElements(n *html.Node, tag string) {
+	for child := n.FirstChild; child != nil; {
+		next := child.NextSibling
+		if child.Type == html.ElementNode && child.Data == tag {
+			n.RemoveChild(child)
+		} else {
+			removeElements(child, tag)
+		}
+		child = next
+	}
+}
+
+// StripReplyFallback removes the quote of the event replied to which the plain body of a reply starts with.
+func StripReplyFallback(body string) string {
+	lines := strings.Split(body, "\n")
+
+	i := 0
+	for i < len(lines) && strings.HasPrefix(lines[i], ">") {
+		i++
+	}
+	if i == 0 {
+		return body
+	}
+	// the quote is separated from the reply by an empty line.
+	if i < len(lines) && lines[i] == "" {
+		i++
+	}
+	return strings.Join(lines[i:], "\n")
+}
+
 // InitSanitizer sets up and returns a bluemonday policy.
 func InitSanitizer() *Sanitizer {
 	p := bluemonday.NewPolicy()
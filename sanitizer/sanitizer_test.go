@@ -0,0 +1,33 @@
+package sanitizer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizer_SanitizeStripsReplyFallback(t *testing.T) {
+	s := InitSanitizer()
+	str := `<mx-reply><blockquote><a href="https://matrix.to/#/!room/$1">In reply to</a> <a href="https://matrix.to/#/@alice:example.org">@alice:example.org</a><br>hi</blockquote></mx-reply>hello <b>there</b>`
+
+	sanitized, ok := s.Sanitize(str)
+	// the body element is stripped, leaving space in its place.
+	if !ok || strings.TrimSpace(sanitized) != "hello <b>there</b>" {
+		t.Error("Reply fallback should be stripped", sanitized)
+	}
+}
+
+func TestStripReplyFallback(t *testing.T) {
+	tests := []struct {
+		body string
+		exp  string
+	}{
+		{"> <@alice:example.org> hi\n> there\n\nhello", "hello"},
+		{"> <@alice:example.org> hi\n\nhello\n> quoted later", "hello\n> quoted later"},
+		{"hello\n> not a fallback", "hello\n> not a fallback"},
+	}
+	for _, tt := range tests {
+		if stripped := StripReplyFallback(tt.body); stripped != tt.exp {
+			t.Errorf("StripReplyFallback(%q) = %q, expected %q", tt.body, stripped, tt.exp)
+		}
+	}
+}
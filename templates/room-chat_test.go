@@ -0,0 +1,87 @@
+package templates
+
+import (
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/mxclient"
+	"html"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var metaRegex = regexp.MustCompile(`<meta (?:property|name)="([^"]+)" content="([^"]*)">`)
+
+// metaTags returns the content of each meta tag in head by its property or name.
+func metaTags(head string) map[string]string {
+	tags := make(map[string]string)
+	for _, match := range metaRegex.FindAllStringSubmatch(head, -1) {
+		tags[match[1]] = html.UnescapeString(match[2])
+	}
+	return tags
+}
+
+func makeTestChatPage() *RoomChatPage {
+	return &RoomChatPage{
+		RoomInfo: mxclient.RoomInfo{
+			RoomID:    "!room:example.org",
+			Name:      "Tom & Jerry's <room>",
+			Topic:     "Cats \"and\" mice",
+			AvatarURL: *mxclient.NewMXCURL("mxc://example.org/avatar", "https://example.org"),
+		},
+		MemberMap: map[string]mxclient.MemberInfo{
+			"@alice:example.org": {MXID: "@alice:example.org", DisplayName: "Alice"},
+		},
+		Events: []gomatrix.Event{
+			{ID: "$1", Type: "m.room.message", Sender: "@bob:example.org", Timestamp: 1000, Content: map[string]interface{}{
+				"msgtype": "m.text", "body": "hello",
+			}},
+			{ID: "$2", Type: "m.room.message", Sender: "@alice:example.org", Timestamp: 2000, Content: map[string]interface{}{
+				"msgtype": "m.image", "body": "cat.png", "url": "mxc://example.org/cat",
+			}},
+		},
+		PageSize:     2,
+		AtTopEnd:     true,
+		AtBottomEnd:  true,
+		MediaBaseURL: "https://example.org",
+		BaseURL:      "https://example.org/view",
+	}
+}
+
+func TestRoomChatPage_HeadRoomCard(t *testing.T) {
+	tags := metaTags(makeTestChatPage().Head())
+
+	if tags["og:title"] != "Tom & Jerry's <room>" {
+		t.Error("Title should be the room name", tags["og:title"])
+	}
+	if tags["og:description"] != `Cats "and" mice` || tags["description"] != tags["og:description"] {
+		t.Error("Description should be the room topic", tags["og:description"], tags["description"])
+	}
+	if image := tags["og:image"]; !strings.Contains(image, "/thumbnail/example.org/avatar?") || !strings.Contains(image, "method=crop") {
+		t.Error("Image should be the room avatar", image)
+	}
+	if tags["twitter:card"] != "summary" {
+		t.Error("Room should have a small card", tags["twitter:card"])
+	}
+}
+
+func TestRoomChatPage_HeadImageCard(t *testing.T) {
+	page := makeTestChatPage()
+	page.Highlight = "$2"
+	tags := metaTags(page.Head())
+
+	if tags["og:title"] != "Alice in Tom & Jerry's <room>" {
+		t.Error("Title should be the sender and room name", tags["og:title"])
+	}
+	if tags["og:description"] != "cat.png" {
+		t.Error("Description should be the message", tags["og:description"])
+	}
+	if image := tags["og:image"]; !strings.Contains(image, "/thumbnail/example.org/cat?") || !strings.Contains(image, "method=scale") {
+		t.Error("Image should be the thumbnail of the image sent", image)
+	}
+	if tags["twitter:card"] != "summary_large_image" {
+		t.Error("Image should have a large card", tags["twitter:card"])
+	}
+	if !strings.Contains(tags["og:url"], "/room/!room:example.org/") {
+		t.Error("URL should link to the room", tags["og:url"])
+	}
+}
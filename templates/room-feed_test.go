@@ -0,0 +1,108 @@
+package templates
+
+import (
+	"encoding/xml"
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/mxclient"
+	"github.com/matrix-org/matrix-static/sanitizer"
+	"strings"
+	"testing"
+)
+
+func makeTestFeed() *RoomFeed {
+	return &RoomFeed{
+		RoomInfo: mxclient.RoomInfo{RoomID: "!room:example.org", Name: "Tom & Jerry's <room>", Topic: "Cats \"and\" mice"},
+		MemberMap: map[string]mxclient.MemberInfo{
+			"@alice:example.org": {MXID: "@alice:example.org", DisplayName: "Alice <3"},
+		},
+		Events: []gomatrix.Event{
+			{ID: "$3", Type: "m.room.message", Sender: "@alice:example.org", Timestamp: 3000, Content: map[string]interface{}{
+				"msgtype": "m.image", "body": "cat.png", "url": "mxc://example.org/cat",
+				"info": map[string]interface{}{"mimetype": "image/png", "size": float64(1024)},
+			}},
+			{ID: "$2", Type: "m.room.member", Sender: "@bob:example.org", Timestamp: 2000, Content: map[string]interface{}{"membership": "join"}},
+			{ID: "$1", Type: "m.room.message", Sender: "@bob:example.org", Timestamp: 1000, Content: map[string]interface{}{
+				"msgtype": "m.text", "body": "a < b && c > d\nsecond line",
+				"format": "org.matrix.custom.html", "formatted_body": "a &lt; b <b>&amp;&amp;</b> c &gt; d<script>alert(1)</script>",
+			}},
+		},
+		BaseURL:      "https://example.org/view",
+		Sanitizer:    sanitizer.InitSanitizer(),
+		MediaBaseURL: "https://example.org",
+	}
+}
+
+func TestRoomFeed_Atom(t *testing.T) {
+	var feed struct {
+		XMLName  xml.Name `xml:"http://www.w3.org/2005/Atom feed"`
+		Title    string   `xml:"title"`
+		Subtitle string   `xml:"subtitle"`
+		Entries  []struct {
+			ID     string `xml:"id"`
+			Title  string `xml:"title"`
+			Author string `xml:"author>name"`
+			Links  []struct {
+				Rel    string `xml:"rel,attr"`
+				Href   string `xml:"href,attr"`
+				Length string `xml:"length,attr"`
+			} `xml:"link"`
+			Content string `xml:"content"`
+		} `xml:"entry"`
+	}
+	if err := xml.Unmarshal([]byte(makeTestFeed().Atom()), &feed); err != nil {
+		t.Fatal("Atom feed is not valid XML", err)
+	}
+
+	if feed.Title != "Tom & Jerry's <room>" || feed.Subtitle != `Cats "and" mice` {
+		t.Error("Feed metadata mismatch expectation", feed.Title, feed.Subtitle)
+	}
+	if len(feed.Entries) != 2 {
+		t.Fatal("Only messages should be entries", feed.Entries)
+	}
+	if image := feed.Entries[0]; image.ID != "https://example.org/view/room/!room:example.org/$3" || image.Author != "Alice <3" ||
+		len(image.Links) != 2 || image.Links[1].Rel != "enclosure" || image.Links[1].Length != "1024" {
+		t.Error("Image entry mismatch expectation", image)
+	}
+	if text := feed.Entries[1]; text.Title != "@bob:example.org: a < b && c > d" ||
+		!strings.Contains(text.Content, "<b>&amp;&amp;</b>") || strings.Contains(text.Content, "<script>") {
+		t.Error("Text entry mismatch expectation", text)
+	}
+}
+
+func TestRoomFeed_RSS(t *testing.T) {
+	var rss struct {
+		XMLName xml.Name `xml:"rss"`
+		Channel struct {
+			Title string `xml:"title"`
+			// Links include the atom:link to the feed itself.
+			Links []string `xml:"link"`
+			Items []struct {
+				Title     string `xml:"title"`
+				GUID      string `xml:"guid"`
+				Enclosure *struct {
+					URL    string `xml:"url,attr"`
+					Length string `xml:"length,attr"`
+					Type   string `xml:"type,attr"`
+				} `xml:"enclosure"`
+				Description string `xml:"description"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	if err := xml.Unmarshal([]byte(makeTestFeed().RSS()), &rss); err != nil {
+		t.Fatal("RSS feed is not valid XML", err)
+	}
+
+	if rss.Channel.Title != "Tom & Jerry's <room>" || rss.Channel.Links[0] != "https://example.org/view/room/!room:example.org/" {
+		t.Error("Channel mismatch expectation", rss.Channel.Title, rss.Channel.Links)
+	}
+	if len(rss.Channel.Items) != 2 {
+		t.Fatal("Only messages should be items", rss.Channel.Items)
+	}
+	if image := rss.Channel.Items[0]; image.Enclosure == nil || image.Enclosure.Type != "image/png" || image.Enclosure.Length != "1024" {
+		t.Error("Image item mismatch expectation", image)
+	}
+	if text := rss.Channel.Items[1]; text.GUID != "https://example.org/view/room/!room:example.org/$1" ||
+		!strings.Contains(text.Description, "<b>&amp;&amp;</b>") {
+		t.Error("Text item mismatch expectation", text)
+	}
+}
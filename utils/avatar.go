@@ -0,0 +1,47 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"bytes"
+	"github.com/disintegration/letteravatar"
+	"image/png"
+	"unicode"
+	"unicode/utf8"
+)
+
+// AvatarLetter returns the letter shown by the generated avatar for identifier, skipping any leading sigil.
+func AvatarLetter(identifier string) rune {
+	if len(identifier) > 1 && (identifier[0] == '#' || identifier[0] == '!' || identifier[0] == '@') {
+		identifier = identifier[1:]
+	}
+
+	letter, _ := utf8.DecodeRuneInString(identifier)
+	return unicode.ToUpper(letter)
+}
+
+// DrawAvatar returns a PNG of the generated avatar showing letter.
+func DrawAvatar(letter rune) ([]byte, error) {
+	img, err := letteravatar.Draw(100, letter, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := new(bytes.Buffer)
+	if err = png.Encode(buffer, img); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
@@ -26,10 +26,10 @@ func StrToIntDefault(str string, def int) (page int) {
 }
 
 // CalcPaginationStartEnd calculates the slice offsets needed to perform pagination for desired page, pageSize and length
-// if page=0 it will return slice offsets 0:length-1 for a "get all entries" page.
+// if page=0 it will return slice offsets 0:length for a "get all entries" page.
 func CalcPaginationStartEnd(page, pageSize, length int) (start, end int) {
 	if page == 0 {
-		return 0, length - 1
+		return 0, length
 	}
 
 	start = Min((page-1)*pageSize, length)
@@ -0,0 +1,22 @@
+package utils
+
+import "testing"
+
+func TestCalcPaginationStartEnd(t *testing.T) {
+	tests := []struct {
+		page, pageSize, length int
+		expStart, expEnd       int
+	}{
+		{1, 10, 25, 0, 10},
+		{3, 10, 25, 20, 25},
+		{4, 10, 25, 25, 25},
+		// page 0 gets every entry, including the last and without going out of range when there are none.
+		{0, 10, 25, 0, 25},
+		{0, 10, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		if start, end := CalcPaginationStartEnd(tt.page, tt.pageSize, tt.length); start != tt.expStart || end != tt.expEnd {
+			t.Errorf("CalcPaginationStartEnd(%d, %d, %d) = %d, %d, want %d, %d", tt.page, tt.pageSize, tt.length, start, end, tt.expStart, tt.expEnd)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workers
+
+import (
+	"context"
+	log "github.com/Sirupsen/logrus"
+	"github.com/prometheus/client_golang/prometheus"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+var (
+	cachedRoomsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "matrix_static_cached_rooms",
+		Help: "Number of rooms held in memory by each worker.",
+	}, []string{"worker"})
+	cachedEventsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "matrix_static_cached_events",
+		Help: "Number of events held in memory by each worker.",
+	}, []string{"worker"})
+)
+
+func init() {
+	prometheus.MustRegister(cachedRoomsGauge, cachedEventsGauge)
+}
+
+// planEventBudget decides which rooms to trim and which to discard so that no more than maxEvents are held in total.
+// The least recently accessed rooms are trimmed first, and only once no more can be trimmed are they discarded,
+// always keeping the keepMin most recently accessed rooms and those with subscribers, which would otherwise stop being
+// kept up to date.
+func planEventBudget(stats []RoomStats, maxEvents, keepMin int) []RoomTrimJob {
+	var total int
+	for _, room := range stats {
+		total += room.NumEvents
+	}
+	excess := total - maxEvents
+	if excess <= 0 {
+		return nil
+	}
+
+	// order by LastAccess ascending so the coldest rooms are first.
+	rooms := append([]RoomStats(nil), stats...)
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].LastAccess.Before(rooms[j].LastAccess)
+	})
+
+	jobs := make(map[string]*RoomTrimJob)
+	var plan []*RoomTrimJob
+
+	remaining := make([]int, len(rooms))
+	for i, room := range rooms {
+		remaining[i] = room.NumEvents
+		if excess <= 0 {
+			continue
+		}
+		if trimmable := room.NumEvents - room.MinEvents; trimmable > 0 {
+			cut := trimmable
+			if cut > excess {
+				cut = excess
+			}
+			remaining[i] -= cut
+			excess -= cut
+
+			job := &RoomTrimJob{RoomID: room.RoomID, MaxEvents: remaining[i]}
+			jobs[room.RoomID] = job
+			plan = append(plan, job)
+		}
+	}
+
+	for i := 0; excess > 0 && i < len(rooms)-keepMin; i++ {
+		room := rooms[i]
+		if room.Subscribed {
+			continue
+		}
+		if job, ok := jobs[room.RoomID]; ok {
+			job.Discard = true
+		} else {
+			plan = append(plan, &RoomTrimJob{RoomID: room.RoomID, Discard: true})
+		}
+		excess -= remaining[i]
+	}
+
+	result := make([]RoomTrimJob, 0, len(plan))
+	for _, job := range plan {
+		result = append(result, *job)
+	}
+	return result
+}
+
+// eventBudget estimates how many events the workers hold between enforcements of the event budget, so that it can be
+// enforced as soon as the workers' rooms grow beyond it rather than only periodically.
+type eventBudget struct {
+	// maxEvents is the budget, unlimited if 0.
+	maxEvents int64
+	// numEvents is the number of events held as of the last enforcement plus those gained since, accessed atomically.
+	numEvents int64
+	exceeded  chan struct{}
+}
+
+func newEventBudget() *eventBudget {
+	return &eventBudget{exceeded: make(chan struct{}, 1)}
+}
+
+// grew records that a room gained delta events, signalling if the budget is now exceeded.
+func (b *eventBudget) grew(delta int) {
+	if b == nil || delta <= 0 {
+		return
+	}
+	maxEvents := atomic.LoadInt64(&b.maxEvents)
+	if maxEvents <= 0 {
+		return
+	}
+	if atomic.AddInt64(&b.numEvents, int64(delta)) > maxEvents {
+		// an enforcement is already pending if the channel is full, which will account for these events too.
+		select {
+		case b.exceeded <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// StartEventBudget enforces the event budget in the background whenever the workers' rooms grow beyond maxEvents,
+// see EnforceEventBudget, until ctx is done. It should only be called once.
+func (ws *Workers) StartEventBudget(ctx context.Context, maxEvents, keepMin int) {
+	atomic.StoreInt64(&ws.budget.maxEvents, int64(maxEvents))
+	go func() {
+		for {
+			select {
+			case <-ws.budget.exceeded:
+			case <-ctx.Done():
+				return
+			}
+			if err := ws.EnforceEventBudget(ctx, maxEvents, keepMin); err != nil {
+				log.WithError(err).Error("Failed to enforce event budget")
+			}
+		}
+	}()
+}
+
+// EnforceEventBudget gathers the memory usage of every worker, updating the metrics, and trims or discards rooms
+// across the pool as necessary to hold no more than maxEvents, unless maxEvents is not positive.
+func (ws *Workers) EnforceEventBudget(ctx context.Context, maxEvents, keepMin int) error {
+	var stats []RoomStats
+	for _, worker := range ws.workers {
+		resp, err := worker.Submit(ctx, RoomStatsJob{})
+		if err != nil {
+			return err
+		}
+
+		workerStats := resp.(RoomStatsResp)
+		var numEvents int
+		for _, room := range workerStats.Rooms {
+			numEvents += room.NumEvents
+		}
+
+		workerLabel := strconv.Itoa(workerStats.WorkerID)
+		cachedRoomsGauge.WithLabelValues(workerLabel).Set(float64(len(workerStats.Rooms)))
+		cachedEventsGauge.WithLabelValues(workerLabel).Set(float64(numEvents))
+
+		stats = append(stats, workerStats.Rooms...)
+	}
+
+	if maxEvents <= 0 {
+		return nil
+	}
+
+	var total int
+	for _, room := range stats {
+		total += room.NumEvents
+	}
+	if total > maxEvents {
+		total = maxEvents
+	}
+	atomic.StoreInt64(&ws.budget.numEvents, int64(total))
+
+	for _, job := range planEventBudget(stats, maxEvents, keepMin) {
+		if _, err := ws.Submit(ctx, job.RoomID, job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,406 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workers
+
+import (
+	"context"
+	log "github.com/Sirupsen/logrus"
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/mxclient"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The methods in this file make the slow requests to the homeserver needed to serve a room away from the worker owning
+// it, so that the worker is free to serve its other rooms meanwhile and only has to apply the results.
+// Concurrent requests for the same data are coalesced so that a burst of hits to a cold room only fetches it once.
+
+// LoadRoom ensures that the room is held by its worker, initial syncing it if necessary.
+func (ws *Workers) LoadRoom(ctx context.Context, roomID string) error {
+	resp, err := ws.Submit(ctx, roomID, RoomLoadedJob{roomID})
+	if err != nil {
+		return err
+	}
+	if resp.(RoomLoadedResp).Loaded {
+		return nil
+	}
+
+	return ws.fetches.Do(ctx, "initialsync\x00"+roomID, func(ctx context.Context) error {
+		loggerWithFields := log.WithField("RoomID", roomID)
+		loggerWithFields.Info("Started Initial Syncing Room")
+
+		room, err := ws.client.NewRoom(ctx, roomID)
+		if err != nil {
+			loggerWithFields.WithError(err).Error("Failed Initial Syncing Room")
+			return err
+		}
+
+		loggerWithFields.Info("Finished Initial Syncing Room")
+		_, err = ws.Submit(ctx, roomID, RoomInitialSyncJob{room})
+		return err
+	})
+}
+
+// maxBackpaginationRounds bounds how many back paginations GetRoomEvents fetches itself before falling back to letting
+// the worker back paginate inline, in case others keep consuming what it fetched.
+const maxBackpaginationRounds = 3
+
+// GetRoomEvents runs job, fetching any back pagination it needs first.
+func (ws *Workers) GetRoomEvents(ctx context.Context, job RoomEventsJob) (RoomEventsResp, error) {
+	for round := 0; ; round++ {
+		job.FetchInline = job.FetchInline || round >= maxBackpaginationRounds
+
+		resp, err := ws.Submit(ctx, job.RoomID, job)
+		if err != nil {
+			return RoomEventsResp{}, err
+		}
+
+		eventsResp := resp.(RoomEventsResp)
+		b := eventsResp.Backpagination
+		if b == nil {
+			ws.fetchReplyParents(ctx, job.RoomID, eventsResp.InReplyTo, eventsResp.missingReplyParents)
+			return eventsResp, nil
+		}
+
+		err = ws.backpaginate(ctx, b)
+		if ctx.Err() != nil {
+			return RoomEventsResp{}, ctx.Err()
+		}
+		// let the worker have a go itself if the homeserver failed us, which serves what it has if it fails again.
+		if err != nil {
+			job.FetchInline = true
+		}
+	}
+}
+
+// backpaginate fetches b and hands it to the worker owning its room.
+func (ws *Workers) backpaginate(ctx context.Context, b *mxclient.Backpagination) error {
+	return ws.fetches.Do(ctx, "backpaginate\x00"+b.RoomID+"\x00"+b.From, func(ctx context.Context) error {
+		loggerWithFields := log.WithField("roomID", b.RoomID).WithField("amount", b.Limit)
+		loggerWithFields.Info("Backpaginating Room")
+
+		messages, err := ws.client.FetchBackpagination(ctx, b)
+		if err != nil {
+			loggerWithFields.WithError(err).Error("Failed Backpaginating Room")
+			return err
+		}
+
+		_, err = ws.Submit(ctx, b.RoomID, RoomBackpaginateJob{b, messages})
+		return err
+	})
+}
+
+// ForwardpaginatePeekedRooms brings every loaded room which /sync does not keep up to date, as our account only peeks
+// it, up to date by forward paginating it. It gives up at the first worker which cannot be reached before ctx is done.
+func (ws *Workers) ForwardpaginatePeekedRooms(ctx context.Context) error {
+	var forwardpaginations []*mxclient.Forwardpagination
+	for _, worker := range ws.workers {
+		resp, err := worker.Submit(ctx, RoomForwardpaginationsJob{})
+		if err != nil {
+			return err
+		}
+		forwardpaginations = append(forwardpaginations, resp.(RoomForwardpaginationsResp).Forwardpaginations...)
+	}
+
+	for _, f := range forwardpaginations {
+		if err := ws.forwardpaginate(ctx, f); ctx.Err() != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// forwardpaginate fetches f and hands it to the worker owning its room.
+func (ws *Workers) forwardpaginate(ctx context.Context, f *mxclient.Forwardpagination) error {
+	return ws.fetches.Do(ctx, "forwardpaginate\x00"+f.RoomID+"\x00"+f.From, func(ctx context.Context) error {
+		messages, err := ws.client.FetchForwardpagination(ctx, f)
+		if err != nil {
+			log.WithField("roomID", f.RoomID).WithError(err).Error("Failed Forward paginating Room")
+			return err
+		}
+
+		_, err = ws.Submit(ctx, f.RoomID, RoomForwardpaginateJob{f, messages})
+		return err
+	})
+}
+
+// ApplySync applies a room's portion of a /sync response to it. Any gap the server left before its events is filled
+// first by forward paginating away from the worker, so that other rooms of the worker are not held up meanwhile.
+func (ws *Workers) ApplySync(ctx context.Context, roomID string, sync mxclient.SyncJoinedRoom, nextBatch string) error {
+	resp, err := ws.Submit(ctx, roomID, RoomSyncGapJob{roomID, sync.Timeline.Limited})
+	if err != nil {
+		return err
+	}
+	if f := resp.(RoomSyncGapResp).Forwardpagination; f != nil {
+		// a gap which cannot be filled is left rather than falling behind /sync.
+		if err := ws.forwardpaginate(ctx, f); ctx.Err() != nil {
+			return err
+		}
+	}
+
+	_, err = ws.Submit(ctx, roomID, RoomSyncJob{roomID, sync, nextBatch})
+	return err
+}
+
+// maxHistoryBackpaginations bounds how many back paginations are made looking for the events of a day, so that a
+// date long before a busy room's history still held in memory cannot make us fetch all of it. /messages can only be
+// walked a page at a time, so there is no bisecting our way back to a date either.
+const maxHistoryBackpaginations = 4
+
+// FindRoomEventByDate finds the first event sent at or after ts, the EventID being "" if there is none.
+// The homeserver is asked if it supports /timestamp_to_event, else the room is back paginated until it reaches ts,
+// settling for the oldest event held and setting OutsideHistory if it does not within maxHistoryBackpaginations.
+func (ws *Workers) FindRoomEventByDate(ctx context.Context, roomID string, ts int64) (RoomDateResp, error) {
+	found, err := ws.client.TimestampToEvent(ctx, roomID, ts, 'f')
+	if err == nil {
+		return RoomDateResp{EventID: found.EventID}, nil
+	}
+	if ctx.Err() != nil {
+		return RoomDateResp{}, ctx.Err()
+	}
+	if respErr, ok := mxclient.UnwrapRespError(err); ok && respErr.ErrCode == "M_NOT_FOUND" {
+		return RoomDateResp{}, nil
+	}
+	log.WithField("roomID", roomID).WithError(err).Warn("Failed finding event by timestamp, back paginating instead")
+
+	job := RoomDateJob{RoomID: roomID, Timestamp: ts}
+	for round := 0; ; round++ {
+		job.Inline = job.Inline || round >= maxHistoryBackpaginations
+
+		resp, err := ws.Submit(ctx, roomID, job)
+		if err != nil {
+			return RoomDateResp{}, err
+		}
+
+		dateResp := resp.(RoomDateResp)
+		if dateResp.Backpagination == nil {
+			return dateResp, dateResp.Err
+		}
+		if err := ws.backpaginate(ctx, dateResp.Backpagination); err != nil {
+			if ctx.Err() != nil {
+				return RoomDateResp{}, ctx.Err()
+			}
+			job.Inline = true
+		}
+	}
+}
+
+// GetRoomCalendar runs job, first back paginating the room until it reaches back to job.Since, within
+// maxHistoryBackpaginations.
+func (ws *Workers) GetRoomCalendar(ctx context.Context, job RoomCalendarJob) (RoomCalendarResp, error) {
+	for round := 0; ; round++ {
+		job.Inline = job.Inline || round >= maxHistoryBackpaginations
+
+		resp, err := ws.Submit(ctx, job.RoomID, job)
+		if err != nil {
+			return RoomCalendarResp{}, err
+		}
+
+		calendarResp := resp.(RoomCalendarResp)
+		if calendarResp.Backpagination == nil {
+			return calendarResp, calendarResp.Err
+		}
+		if err := ws.backpaginate(ctx, calendarResp.Backpagination); err != nil {
+			if ctx.Err() != nil {
+				return RoomCalendarResp{}, ctx.Err()
+			}
+			job.Inline = true
+		}
+	}
+}
+
+// GetRoomThread fetches a page of the thread rooted at rootID, starting at the latest reply unless from is the
+// NextBatch of a previous page. Threads are not held by the worker, so the pages are fetched as needed and only
+// cached briefly, see fetchThread. If the server cannot be asked for the replies, those held in memory are shown.
+func (ws *Workers) GetRoomThread(ctx context.Context, roomID, rootID, from string, limit int) (RoomThreadResp, error) {
+	job := RoomThreadJob{RoomID: roomID}
+	thread, err := ws.fetchThread(ctx, roomID, rootID, from, limit)
+	if err == mxclient.ErrRelationsUnsupported && from == "" {
+		job.RootID = rootID
+	} else if err != nil {
+		log.WithField("roomID", roomID).WithField("rootID", rootID).WithError(err).Error("Failed Fetching Thread")
+		return RoomThreadResp{}, err
+	} else {
+		job.Thread = thread
+	}
+
+	resp, err := ws.Submit(ctx, roomID, job)
+	if err != nil {
+		return RoomThreadResp{}, err
+	}
+
+	threadResp := resp.(RoomThreadResp)
+	if threadResp.Err != nil {
+		return RoomThreadResp{}, threadResp.Err
+	}
+	ws.fetchReplyParents(ctx, roomID, threadResp.InReplyTo, threadResp.missingReplyParents)
+	return threadResp, nil
+}
+
+// ThreadCacheTTL is how long fetched pages of threads are reused for.
+const ThreadCacheTTL = 30 * time.Second
+
+// fetchThread fetches a page of a thread, see mxclient.Client.FetchThread, coalescing concurrent fetches of the same
+// page and reusing it for ThreadCacheTTL.
+func (ws *Workers) fetchThread(ctx context.Context, roomID, rootID, from string, limit int) (*mxclient.Thread, error) {
+	key := "thread\x00" + roomID + "\x00" + rootID + "\x00" + from + "\x00" + strconv.Itoa(limit)
+	if thread, found := ws.threads.get(key); found {
+		return thread, nil
+	}
+
+	err := ws.fetches.Do(ctx, key, func(ctx context.Context) error {
+		thread, err := ws.client.FetchThread(ctx, roomID, rootID, from, limit)
+		if err == nil {
+			ws.threads.put(key, thread)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if thread, found := ws.threads.get(key); found {
+		return thread, nil
+	}
+	// it expired in the meantime, which is as good as it not having been fetched for us.
+	return ws.client.FetchThread(ctx, roomID, rootID, from, limit)
+}
+
+// threadCache holds the recently fetched pages of threads by key, see Workers.fetchThread.
+type threadCache struct {
+	mu    sync.Mutex
+	pages map[string]cachedThread
+}
+
+type cachedThread struct {
+	thread  *mxclient.Thread
+	fetched time.Time
+}
+
+func (c *threadCache) get(key string) (*mxclient.Thread, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	page, found := c.pages[key]
+	if !found || time.Since(page.fetched) > ThreadCacheTTL {
+		return nil, false
+	}
+	return page.thread, true
+}
+
+func (c *threadCache) put(key string, thread *mxclient.Thread) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pages == nil {
+		c.pages = make(map[string]cachedThread)
+	}
+	// drop the expired pages as we go, so that the cache only ever holds those fetched within the TTL.
+	for k, page := range c.pages {
+		if time.Since(page.fetched) > ThreadCacheTTL {
+			delete(c.pages, k)
+		}
+	}
+	c.pages[key] = cachedThread{thread, time.Now()}
+}
+
+// SearchRoom runs job, searching the homeserver if it can search the room, and the events held in memory otherwise if
+// job allows it, including when the homeserver fails to be searched.
+func (ws *Workers) SearchRoom(ctx context.Context, job RoomSearchJob) (RoomSearchResp, error) {
+	resp, err := ws.Submit(ctx, job.RoomID, job)
+	if err != nil {
+		return RoomSearchResp{}, err
+	}
+
+	searchResp := resp.(RoomSearchResp)
+	if !searchResp.searchRemotely {
+		return searchResp, nil
+	}
+
+	results, err := ws.client.SearchRoom(ctx, job.RoomID, job.Query, job.From, job.PageSize)
+	if ctx.Err() != nil {
+		return RoomSearchResp{}, ctx.Err()
+	}
+	if err != nil {
+		log.WithField("roomID", job.RoomID).WithError(err).Warn("Failed Searching Room")
+		if !job.AllowLocal {
+			searchResp.Err = err
+			return searchResp, nil
+		}
+		job.skipRemote = true
+	}
+	job.remoteResults = results
+
+	resp, err = ws.Submit(ctx, job.RoomID, job)
+	if err != nil {
+		return RoomSearchResp{}, err
+	}
+	return resp.(RoomSearchResp), nil
+}
+
+// maxReplyParentFetches bounds how many events replied to are fetched for a single page, replies to any others are
+// shown without quoting them.
+const maxReplyParentFetches = 10
+
+// fetchReplyParents fetches the events replied to which the room does not hold, adding them to parents and caching
+// them in the room. Those which cannot be fetched, e.g. as they are not visible to us, are left out.
+func (ws *Workers) fetchReplyParents(ctx context.Context, roomID string, parents map[string]gomatrix.Event, missing []string) {
+	if len(missing) > maxReplyParentFetches {
+		missing = missing[:maxReplyParentFetches]
+	}
+
+	var wg sync.WaitGroup
+	for _, eventID := range missing {
+		wg.Add(1)
+		go func(eventID string) {
+			defer wg.Done()
+			// the result is cached in the room rather than returned, as coalesced callers do not run the fetch.
+			err := ws.fetches.Do(ctx, "event\x00"+roomID+"\x00"+eventID, func(ctx context.Context) error {
+				return ws.fetchReplyParent(ctx, roomID, eventID)
+			})
+			if err != nil && ctx.Err() == nil {
+				log.WithField("roomID", roomID).WithField("eventID", eventID).WithError(err).Warn("Unable to fetch replied to event")
+			}
+		}(eventID)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+
+	resp, err := ws.Submit(ctx, roomID, RoomReplyParentsJob{RoomID: roomID, EventIDs: missing})
+	if err != nil {
+		return
+	}
+	for eventID, parent := range resp.(RoomReplyParentsResp).Parents {
+		parents[eventID] = parent
+	}
+}
+
+// fetchReplyParent fetches the event replied to and caches it in the room. Should the server refuse it, that is cached
+// instead so that it is not asked for again, whereas other errors are returned to be retried with the next page.
+func (ws *Workers) fetchReplyParent(ctx context.Context, roomID, eventID string) error {
+	job := RoomReplyParentsJob{RoomID: roomID}
+	ev, err := ws.client.RoomEvent(ctx, roomID, eventID)
+	if _, refused := err.(gomatrix.HTTPError); refused {
+		log.WithField("roomID", roomID).WithField("eventID", eventID).WithError(err).Warn("Unable to fetch replied to event")
+		job.Failed = []string{eventID}
+	} else if err != nil {
+		return err
+	} else {
+		job.Fetched = []gomatrix.Event{*ev}
+	}
+
+	_, err = ws.Submit(ctx, roomID, job)
+	return err
+}
@@ -0,0 +1,474 @@
+package workers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/mxclient"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkers_LoadRoomCoalesces(t *testing.T) {
+	var numInitialSyncs int32
+	release := make(chan struct{})
+
+	cli, _ := mxclient.NewRawClient("https://example.org", "", "", "")
+	cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+		body := `{"chunk":[],"start":"s1","end":"e1"}`
+		if strings.HasSuffix(req.URL.Path, "/state") {
+			atomic.AddInt32(&numInitialSyncs, 1)
+			<-release
+			body = `[]`
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			Header:     make(http.Header),
+		}
+	})
+
+	pool := NewWorkers(1, cli)
+
+	const numCallers = 16
+	var wg sync.WaitGroup
+	wg.Add(numCallers)
+	for i := 0; i < numCallers; i++ {
+		go func() {
+			defer wg.Done()
+			if err := pool.LoadRoom(context.Background(), "!room:example.org"); err != nil {
+				t.Errorf("LoadRoom() error = %v", err)
+			}
+		}()
+	}
+
+	// the worker must remain free to serve other rooms whilst the initial sync is in flight.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := pool.Submit(ctx, "!other:example.org", echoJob{1}); err != nil {
+		t.Fatalf("worker blocked by initial sync: %v", err)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&numInitialSyncs); n != 1 {
+		t.Errorf("made %d initial syncs, want 1", n)
+	}
+	if ids := pool.LoadedRoomIDs(context.Background()); len(ids) != 1 || ids[0] != "!room:example.org" {
+		t.Errorf("LoadedRoomIDs() = %v", ids)
+	}
+}
+
+func TestFlightGroup_CancelsAbandoned(t *testing.T) {
+	var g flightGroup
+	cancelled := make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := g.Do(ctx, "key", func(ctx context.Context) error {
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	})
+	if err != context.Canceled {
+		t.Errorf("Do() error = %v, want %v", err, context.Canceled)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("abandoned call was not cancelled")
+	}
+
+	// a later call must not join the abandoned one.
+	if err := g.Do(context.Background(), "key", func(ctx context.Context) error { return nil }); err != nil {
+		t.Errorf("Do() error = %v, want nil", err)
+	}
+}
+
+func TestWorkers_ApplySyncFillsGapOffWorker(t *testing.T) {
+	fetching, release := make(chan struct{}, 1), make(chan struct{})
+	cli, _ := mxclient.NewRawClient("https://example.org", "", "@bot:example.org", "")
+	cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+		body := `[]`
+		if req.URL.Query().Get("dir") == "b" {
+			body = `{"chunk":[{"event_id":"$1","type":"m.room.message"}],"start":"s1"}`
+		} else if req.URL.Query().Get("dir") == "f" && req.URL.Query().Get("from") == "s1" {
+			fetching <- struct{}{}
+			<-release
+			body = `{"chunk":[{"event_id":"$2","type":"m.room.message"}],"start":"s1","end":"s2"}`
+		} else if req.URL.Query().Get("dir") == "f" {
+			body = `{"chunk":[],"start":"s2"}`
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			Header:     make(http.Header),
+		}
+	})
+
+	ctx := context.Background()
+	pool := NewWorkers(1, cli)
+	if err := pool.LoadRoom(ctx, "!room"); err != nil {
+		t.Fatal("Failed loading room", err)
+	}
+
+	var sync mxclient.SyncJoinedRoom
+	sync.Timeline.Events = []gomatrix.Event{{ID: "$3", Type: "m.room.message"}}
+	sync.Timeline.Limited = true
+	done := make(chan error, 1)
+	go func() {
+		done <- pool.ApplySync(ctx, "!room", sync, "n1")
+	}()
+
+	// the worker answers other jobs whilst the gap is being fetched.
+	<-fetching
+	submitCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if _, err := pool.Submit(submitCtx, "!room", RoomListJob{}); err != nil {
+		t.Error("Worker should not be blocked by filling the gap", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal("Failed applying sync", err)
+	}
+
+	resp, err := pool.GetRoomEvents(ctx, RoomEventsJob{RoomID: "!room", PageSize: 10})
+	if err != nil || resp.Err != nil {
+		t.Fatal("Failed getting events", err, resp.Err)
+	}
+	var ids []string
+	for _, event := range resp.Events {
+		ids = append(ids, event.ID)
+	}
+	if strings.Join(ids, ",") != "$3,$2,$1" {
+		t.Error("Gap should be filled before the sync is applied", ids)
+	}
+}
+
+func TestWorkers_GetRoomEventsAcrossUpgrades(t *testing.T) {
+	bodies := map[string]string{
+		"/rooms/!new/state":    `[{"type":"m.room.create","state_key":"","content":{"predecessor":{"room_id":"!old","event_id":"$o3"}}}]`,
+		"/rooms/!new/messages": `{"chunk":[{"event_id":"$n2","type":"m.room.message"},{"event_id":"$n1","type":"m.room.message"}],"start":"s1"}`,
+		"/rooms/!old/state":    `[{"type":"m.room.tombstone","state_key":"","content":{"replacement_room":"!new"}}]`,
+		"/rooms/!old/messages": `{"chunk":[{"event_id":"$o3","type":"m.room.message"},{"event_id":"$o2","type":"m.room.message"},{"event_id":"$o1","type":"m.room.message"}],"start":"s2"}`,
+	}
+
+	cli, _ := mxclient.NewRawClient("https://example.org", "", "", "")
+	cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(bodies[strings.TrimPrefix(req.URL.Path, "/_matrix/client/r0")])),
+			Header:     make(http.Header),
+		}
+	})
+
+	pool := NewWorkers(2, cli)
+	if err := pool.LoadRoom(context.Background(), "!new"); err != nil {
+		t.Fatal("Failed loading room", err)
+	}
+
+	resp, err := pool.GetRoomEventsAcrossUpgrades(context.Background(), RoomEventsJob{RoomID: "!new", PageSize: 4})
+	if err != nil || resp.Err != nil {
+		t.Fatal("Failed getting events", err, resp.Err)
+	}
+	if len(resp.Events) != 2 || !resp.AtTopEnd {
+		t.Fatal("Page mismatch expectation", resp.Events, resp.AtTopEnd)
+	}
+	if resp.Predecessor == nil {
+		t.Fatal("Page should have been continued into the predecessor room")
+	}
+	if events := resp.Predecessor.Events; len(events) != 2 || events[0].ID != "$o3" || events[1].ID != "$o2" {
+		t.Error("Predecessor page mismatch expectation", events)
+	}
+	if resp.Predecessor.RoomInfo.SuccessorRoomID != "!new" {
+		t.Error("Predecessor should link to its successor", resp.Predecessor.RoomInfo)
+	}
+}
+
+func TestWorkers_GetRoomEventsFetchesReplyParents(t *testing.T) {
+	var numEventFetches int32
+	bodies := map[string]string{
+		"/rooms/!room/state":    `[]`,
+		"/rooms/!room/messages": `{"chunk":[{"event_id":"$4","type":"m.room.message","content":{"body":"hey","m.relates_to":{"m.in_reply_to":{"event_id":"$2"}}}},{"event_id":"$3","type":"m.room.message","content":{"body":"hi","m.relates_to":{"m.in_reply_to":{"event_id":"$1"}}}}],"start":"s1"}`,
+		"/rooms/!room/event/$1": `{"event_id":"$1","type":"m.room.message","sender":"@alice:example.org","content":{"body":"hello"}}`,
+	}
+
+	cli, _ := mxclient.NewRawClient("https://example.org", "", "", "")
+	cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+		if strings.Contains(req.URL.Path, "/event/") {
+			atomic.AddInt32(&numEventFetches, 1)
+		}
+		body, status := bodies[strings.TrimPrefix(req.URL.Path, "/_matrix/client/r0")], 200
+		if body == "" {
+			body, status = `{"errcode":"M_NOT_FOUND"}`, 404
+		}
+		return &http.Response{
+			StatusCode: status,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			Header:     make(http.Header),
+		}
+	})
+
+	pool := NewWorkers(1, cli)
+	if err := pool.LoadRoom(context.Background(), "!room"); err != nil {
+		t.Fatal("Failed loading room", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := pool.GetRoomEvents(context.Background(), RoomEventsJob{RoomID: "!room", PageSize: 10})
+		if err != nil || resp.Err != nil {
+			t.Fatal("Failed getting events", err, resp.Err)
+		}
+		if parent := resp.InReplyTo["$1"]; parent.Content["body"] != "hello" {
+			t.Error("Event replied to should have been fetched", resp.InReplyTo)
+		}
+	}
+	if n := atomic.LoadInt32(&numEventFetches); n != 2 {
+		t.Errorf("fetched the events replied to %d times, want 2", n)
+	}
+}
+
+func TestWorkers_GetRoomEventsCapsReplyParentFetches(t *testing.T) {
+	var numEventFetches int32
+	var chunk []string
+	for i := 0; i < 2*maxReplyParentFetches; i++ {
+		chunk = append(chunk, fmt.Sprintf(`{"event_id":"$r%d","type":"m.room.message","content":{"body":"hi","m.relates_to":{"m.in_reply_to":{"event_id":"$%d"}}}}`, i, i))
+	}
+	messages := `{"chunk":[` + strings.Join(chunk, ",") + `],"start":"s1"}`
+
+	cli, _ := mxclient.NewRawClient("https://example.org", "", "", "")
+	cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+		body := `[]`
+		if strings.Contains(req.URL.Path, "/event/") {
+			atomic.AddInt32(&numEventFetches, 1)
+			body = `{"event_id":"` + path.Base(req.URL.Path) + `","type":"m.room.message","content":{"body":"hello"}}`
+		} else if strings.HasSuffix(req.URL.Path, "/messages") {
+			body = messages
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			Header:     make(http.Header),
+		}
+	})
+
+	pool := NewWorkers(1, cli)
+	if err := pool.LoadRoom(context.Background(), "!room"); err != nil {
+		t.Fatal("Failed loading room", err)
+	}
+
+	resp, err := pool.GetRoomEvents(context.Background(), RoomEventsJob{RoomID: "!room", PageSize: 2 * maxReplyParentFetches})
+	if err != nil || resp.Err != nil {
+		t.Fatal("Failed getting events", err, resp.Err)
+	}
+	if n := atomic.LoadInt32(&numEventFetches); n != maxReplyParentFetches || len(resp.InReplyTo) != maxReplyParentFetches {
+		t.Errorf("fetched %d events replied to for %d quotes, want %d", n, len(resp.InReplyTo), maxReplyParentFetches)
+	}
+}
+
+func TestWorkers_SearchRoomFallsBackToLocal(t *testing.T) {
+	var searchStatus int32 = 200
+	bodies := map[string]string{
+		"/rooms/!room/state":    `[{"type":"m.room.member","state_key":"@bot:example.org","content":{"membership":"join"}}]`,
+		"/rooms/!room/messages": `{"chunk":[{"event_id":"$2","type":"m.room.message","content":{"body":"local hit"}},{"event_id":"$1","type":"m.room.message","content":{"body":"miss"}}],"start":"s1"}`,
+		"/search":               `{"search_categories":{"room_events":{"results":[{"result":{"event_id":"$r","type":"m.room.message","content":{"body":"remote hit"}}}],"count":1,"highlights":["hit"]}}}`,
+	}
+
+	cli, _ := mxclient.NewRawClient("https://example.org", "", "@bot:example.org", "")
+	cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+		path := strings.TrimPrefix(req.URL.Path, "/_matrix/client/r0")
+		status := 200
+		if path == "/search" {
+			status = int(atomic.LoadInt32(&searchStatus))
+		}
+		return &http.Response{
+			StatusCode: status,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(bodies[path])),
+			Header:     make(http.Header),
+		}
+	})
+
+	pool := NewWorkers(1, cli)
+	if err := pool.LoadRoom(context.Background(), "!room"); err != nil {
+		t.Fatal("Failed loading room", err)
+	}
+
+	job := RoomSearchJob{RoomID: "!room", Query: "hit", PageSize: 10}
+	resp, err := pool.SearchRoom(context.Background(), job)
+	if err != nil || resp.Err != nil {
+		t.Fatal("Failed searching room", err, resp.Err)
+	}
+	if events := resp.Results.Events; len(events) != 1 || events[0].ID != "$r" {
+		t.Error("Homeserver should have been searched", events)
+	}
+
+	atomic.StoreInt32(&searchStatus, 500)
+	if resp, _ := pool.SearchRoom(context.Background(), job); resp.Err == nil {
+		t.Error("Search should fail without local search allowed")
+	}
+
+	job.AllowLocal = true
+	resp, err = pool.SearchRoom(context.Background(), job)
+	if err != nil || resp.Err != nil {
+		t.Fatal("Failed searching room", err, resp.Err)
+	}
+	if events := resp.Results.Events; len(events) != 1 || events[0].ID != "$2" {
+		t.Error("Events held in memory should have been searched", events)
+	}
+}
+
+func TestWorkers_FindRoomEventByDate(t *testing.T) {
+	var supportsTimestampToEvent int32 = 1
+	cli, _ := mxclient.NewRawClient("https://example.org", "", "", "")
+	cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+		status, body := 200, `[]`
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/timestamp_to_event"):
+			if atomic.LoadInt32(&supportsTimestampToEvent) == 1 {
+				body = `{"event_id":"$remote","origin_server_ts":15}`
+			} else {
+				status, body = 404, `{"errcode":"M_UNRECOGNIZED","error":"Unrecognized request"}`
+			}
+		case strings.HasSuffix(req.URL.Path, "/messages") && req.URL.Query().Get("from") == "e1":
+			body = `{"chunk":[{"event_id":"$2","type":"m.room.message","origin_server_ts":20},{"event_id":"$1","type":"m.room.message","origin_server_ts":10}],"start":"e1"}`
+		case strings.HasSuffix(req.URL.Path, "/messages"):
+			body = `{"chunk":[{"event_id":"$4","type":"m.room.message","origin_server_ts":40},{"event_id":"$3","type":"m.room.message","origin_server_ts":30}],"start":"s1","end":"e1"}`
+		}
+		return &http.Response{
+			StatusCode: status,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			Header:     make(http.Header),
+		}
+	})
+
+	pool := NewWorkers(1, cli)
+	if err := pool.LoadRoom(context.Background(), "!room"); err != nil {
+		t.Fatal("Failed loading room", err)
+	}
+
+	if resp, err := pool.FindRoomEventByDate(context.Background(), "!room", 15); err != nil || resp.EventID != "$remote" {
+		t.Error("Homeserver should have been asked", resp, err)
+	}
+
+	atomic.StoreInt32(&supportsTimestampToEvent, 0)
+	tests := []struct {
+		ts         int64
+		expEventID string
+	}{
+		{35, "$4"},
+		{15, "$2"},
+		{50, ""},
+	}
+	for _, tt := range tests {
+		if resp, err := pool.FindRoomEventByDate(context.Background(), "!room", tt.ts); err != nil || resp.EventID != tt.expEventID || resp.OutsideHistory {
+			t.Errorf("FindRoomEventByDate(%d) = %+v, %v, want %q", tt.ts, resp, err, tt.expEventID)
+		}
+	}
+}
+
+func TestWorkers_FindRoomEventByDateOutsideHistory(t *testing.T) {
+	var numBackpaginations, lastLimit, numEvents int32
+	cli, _ := mxclient.NewRawClient("https://example.org", "", "", "")
+	cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+		status, body := 200, `[]`
+		switch {
+		case strings.HasSuffix(req.URL.Path, "/timestamp_to_event"):
+			status, body = 404, `{"errcode":"M_UNRECOGNIZED","error":"Unrecognized request"}`
+		case strings.HasSuffix(req.URL.Path, "/messages"):
+			// the history goes back further than we are willing to go, a page of limit events at a time.
+			n := atomic.AddInt32(&numBackpaginations, 1)
+			limit, _ := strconv.Atoi(req.URL.Query().Get("limit"))
+			atomic.StoreInt32(&lastLimit, int32(limit))
+			atomic.AddInt32(&numEvents, int32(limit))
+			var chunk []string
+			for i := 0; i < limit; i++ {
+				chunk = append(chunk, fmt.Sprintf(`{"event_id":"$%d-%d","type":"m.room.message","origin_server_ts":%d}`, n, i, 100000-int(n)*limit-i))
+			}
+			body = fmt.Sprintf(`{"chunk":[%s],"start":"t%d","end":"t%d"}`, strings.Join(chunk, ","), n-1, n)
+		}
+		return &http.Response{
+			StatusCode: status,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			Header:     make(http.Header),
+		}
+	})
+
+	pool := NewWorkers(1, cli)
+	if err := pool.LoadRoom(context.Background(), "!room"); err != nil {
+		t.Fatal("Failed loading room", err)
+	}
+	numBefore := atomic.LoadInt32(&numEvents)
+
+	resp, err := pool.FindRoomEventByDate(context.Background(), "!room", 1)
+	if err != nil || !resp.OutsideHistory {
+		t.Fatal("Date should be outside the history loaded", resp, err)
+	}
+	if fetched := atomic.LoadInt32(&numEvents) - numBefore; fetched > 2000 {
+		t.Errorf("back paginated %d events looking for the date, want at most 2000", fetched)
+	}
+	n := atomic.LoadInt32(&numBackpaginations)
+	if expEventID := fmt.Sprintf("$%d-%d", n, atomic.LoadInt32(&lastLimit)-1); resp.EventID != expEventID {
+		t.Errorf("EventID = %q, want the oldest loaded %q", resp.EventID, expEventID)
+	}
+}
+
+func TestWorkers_GetRoomThread(t *testing.T) {
+	var numRelationFetches int32
+	var relationsUnsupported int32
+	bodies := map[string]string{
+		"/_matrix/client/r0/rooms/!room/state":                 `[]`,
+		"/_matrix/client/r0/rooms/!room/messages":              `{"chunk":[{"event_id":"$2","type":"m.room.message","content":{"body":"reply","m.relates_to":{"rel_type":"m.thread","event_id":"$1"}}},{"event_id":"$1","type":"m.room.message","content":{"body":"root"}}],"start":"s1"}`,
+		"/_matrix/client/r0/rooms/!room/event/$1":              `{"event_id":"$1","type":"m.room.message","content":{"body":"root"}}`,
+		"/_matrix/client/v1/rooms/!room/relations/$1/m.thread": `{"chunk":[{"event_id":"$3","type":"m.room.message","content":{"body":"fetched reply"}}]}`,
+	}
+
+	cli, _ := mxclient.NewRawClient("https://example.org", "", "", "")
+	cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+		status, body := 200, bodies[req.URL.Path]
+		if strings.Contains(req.URL.Path, "/relations/") {
+			atomic.AddInt32(&numRelationFetches, 1)
+			if atomic.LoadInt32(&relationsUnsupported) == 1 {
+				status, body = 404, `{"errcode":"M_UNRECOGNIZED","error":"Unrecognized request"}`
+			}
+		}
+		return &http.Response{
+			StatusCode: status,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			Header:     make(http.Header),
+		}
+	})
+
+	ctx := context.Background()
+	pool := NewWorkers(1, cli)
+	if err := pool.LoadRoom(ctx, "!room"); err != nil {
+		t.Fatal("Failed loading room", err)
+	}
+
+	// pages of threads are reused rather than fetched for every view.
+	for i := 0; i < 2; i++ {
+		resp, err := pool.GetRoomThread(ctx, "!room", "$1", "", 10)
+		if err != nil || len(resp.Replies) != 1 || resp.Replies[0].ID != "$3" {
+			t.Fatal("Thread mismatch expectation", err, resp.Replies)
+		}
+	}
+	if n := atomic.LoadInt32(&numRelationFetches); n != 1 {
+		t.Errorf("fetched the thread %d times, want 1", n)
+	}
+
+	// servers without /relations fall back to the replies held in memory.
+	atomic.StoreInt32(&relationsUnsupported, 1)
+	resp, err := pool.GetRoomThread(ctx, "!room", "$1", "", 20)
+	if err != nil || resp.Root.ID != "$1" || len(resp.Replies) != 1 || resp.Replies[0].ID != "$2" {
+		t.Error("Local thread mismatch expectation", err, resp.Root.ID, resp.Replies)
+	}
+}
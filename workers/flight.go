@@ -0,0 +1,84 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workers
+
+import (
+	"context"
+	"sync"
+)
+
+// flightGroup coalesces concurrent calls sharing a key into a single execution whose result every caller receives,
+// akin to golang.org/x/sync/singleflight. The execution is cancelled once every caller waiting on it has given up.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	done    chan struct{}
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// Do runs fn unless a call for key is already in progress, in which case it waits for that to finish instead.
+func (g *flightGroup) Do(ctx context.Context, key string, fn func(ctx context.Context) error) error {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flight)
+	}
+	f, inProgress := g.calls[key]
+	if !inProgress {
+		flightCtx, cancel := context.WithCancel(context.Background())
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = f
+
+		go func() {
+			f.err = fn(flightCtx)
+			g.forget(key, f)
+			cancel()
+			close(f.done)
+		}()
+	}
+	f.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		f.waiters--
+		abandoned := f.waiters == 0
+		// later callers must not join a call which is being cancelled.
+		if abandoned && g.calls[key] == f {
+			delete(g.calls, key)
+		}
+		g.mu.Unlock()
+
+		if abandoned {
+			f.cancel()
+		}
+		return ctx.Err()
+	}
+}
+
+func (g *flightGroup) forget(key string, f *flight) {
+	g.mu.Lock()
+	if g.calls[key] == f {
+		delete(g.calls, key)
+	}
+	g.mu.Unlock()
+}
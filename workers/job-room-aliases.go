@@ -15,6 +15,7 @@
 package workers
 
 import (
+	"context"
 	"github.com/matrix-org/matrix-static/mxclient"
 	"github.com/matrix-org/matrix-static/utils"
 )
@@ -24,6 +25,7 @@ type RoomAliasesResp struct {
 	RoomAliases mxclient.RoomAliases
 	PageSize    int
 	Page        int
+	Err         error
 }
 
 type RoomAliasesJob struct {
@@ -32,17 +34,21 @@ type RoomAliasesJob struct {
 	PageSize int
 }
 
-func (job RoomAliasesJob) Work(w *Worker) {
-	room := w.rooms[job.RoomID]
+func (job RoomAliasesJob) Work(ctx context.Context, w *Worker) JobResp {
+	room, exists := w.rooms[job.RoomID]
+	if !exists {
+		return RoomAliasesResp{Err: ErrRoomNotLoaded}
+	}
 	aliases := room.GetState().Aliases
 
 	start, end := utils.CalcPaginationStartEnd(job.Page, job.PageSize, len(aliases))
 
-	w.Output <- RoomAliasesResp{
+	room.Access()
+	return RoomAliasesResp{
 		room.RoomInfo(),
 		aliases[start:end],
 		job.PageSize,
 		job.Page,
+		nil,
 	}
-	room.Access()
 }
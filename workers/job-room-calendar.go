@@ -0,0 +1,62 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workers
+
+import (
+	"context"
+	"github.com/matrix-org/matrix-static/mxclient"
+)
+
+type RoomCalendarResp struct {
+	RoomInfo mxclient.RoomInfo
+	// Days counts the messages held by the day they were sent on, see mxclient.Room.MessageDays.
+	Days map[string]int
+	// AtHistoryStart is set if Days covers all of the room's history.
+	AtHistoryStart bool
+	Err            error
+
+	// Backpagination is set instead of everything else if the timeline does not yet reach back to Since,
+	// see Workers.GetRoomCalendar.
+	Backpagination *mxclient.Backpagination
+}
+
+// RoomCalendarJob counts the messages held in memory by day.
+type RoomCalendarJob struct {
+	RoomID string
+	// Since is the timestamp the timeline should reach back to first, if any.
+	Since int64
+
+	// Inline makes the worker answer with what it holds rather than ask for further back pagination.
+	Inline bool
+}
+
+func (job RoomCalendarJob) Work(ctx context.Context, w *Worker) JobResp {
+	room, exists := w.rooms[job.RoomID]
+	if !exists {
+		return RoomCalendarResp{Err: ErrRoomNotLoaded}
+	}
+	if job.Since != 0 && !job.Inline {
+		if b := room.BackpaginationSince(job.Since); b != nil {
+			return RoomCalendarResp{Backpagination: b}
+		}
+	}
+
+	room.Access()
+	return RoomCalendarResp{
+		RoomInfo:       room.RoomInfo(),
+		Days:           room.MessageDays(),
+		AtHistoryStart: room.HasReachedHistoricEndOfTimeline,
+	}
+}
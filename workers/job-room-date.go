@@ -0,0 +1,58 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workers
+
+import (
+	"context"
+	"github.com/matrix-org/matrix-static/mxclient"
+)
+
+type RoomDateResp struct {
+	// EventID is the oldest event sent at or after the job's Timestamp, or "" if there is none.
+	EventID string
+	// OutsideHistory is set if the timeline held does not reach back to Timestamp, EventID being the oldest event held.
+	OutsideHistory bool
+	Err            error
+
+	// Backpagination is set if the timeline does not yet reach back to Timestamp, see Workers.FindRoomEventByDate.
+	Backpagination *mxclient.Backpagination
+}
+
+// RoomDateJob finds the first event sent at or after Timestamp amongst those held in memory.
+type RoomDateJob struct {
+	RoomID    string
+	Timestamp int64
+
+	// Inline makes the worker answer with what it holds rather than ask for further back pagination.
+	Inline bool
+}
+
+func (job RoomDateJob) Work(ctx context.Context, w *Worker) JobResp {
+	room, exists := w.rooms[job.RoomID]
+	if !exists {
+		return RoomDateResp{Err: ErrRoomNotLoaded}
+	}
+	if !job.Inline {
+		if b := room.BackpaginationSince(job.Timestamp); b != nil {
+			return RoomDateResp{Backpagination: b}
+		}
+	}
+
+	room.Access()
+	return RoomDateResp{
+		EventID:        room.EventAtOrAfter(job.Timestamp),
+		OutsideHistory: room.BackpaginationSince(job.Timestamp) != nil,
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workers
+
+import (
+	"context"
+	"fmt"
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/mxclient"
+)
+
+type RoomEventNotFoundError struct {
+	roomID  string
+	eventID string
+}
+
+func (err *RoomEventNotFoundError) Error() string {
+	return fmt.Sprintf("Event %s not found in %s.", err.eventID, err.roomID)
+}
+
+type RoomEditsResp struct {
+	RoomInfo  mxclient.RoomInfo
+	MemberMap map[string]mxclient.MemberInfo
+	Original  gomatrix.Event
+	// Edits of Original, oldest first.
+	Edits []gomatrix.Event
+	Err   error
+}
+
+// RoomEditsJob returns the edit history of an event which has been loaded already.
+type RoomEditsJob struct {
+	RoomID  string
+	EventID string
+}
+
+func (job RoomEditsJob) Work(ctx context.Context, w *Worker) JobResp {
+	room, exists := w.rooms[job.RoomID]
+	if !exists {
+		return RoomEditsResp{Err: ErrRoomNotLoaded}
+	}
+
+	var err error
+	original, edits, found := room.EditHistory(job.EventID)
+	if !found {
+		err = &RoomEventNotFoundError{
+			job.RoomID,
+			job.EventID,
+		}
+	}
+
+	membersMap := make(map[string]mxclient.MemberInfo)
+	for mxid, member := range room.GetState().MemberMap {
+		membersMap[mxid] = *member
+	}
+
+	room.Access()
+	return RoomEditsResp{
+		room.RoomInfo(),
+		membersMap,
+		original,
+		edits,
+		err,
+	}
+}
@@ -15,17 +15,33 @@
 package workers
 
 import (
+	"context"
 	"github.com/matrix-org/gomatrix"
 	"github.com/matrix-org/matrix-static/mxclient"
 )
 
 type RoomEventsResp struct {
-	Events      []gomatrix.Event
-	RoomInfo    mxclient.RoomInfo
-	MemberMap   map[string]mxclient.MemberInfo
+	Events    []gomatrix.Event
+	RoomInfo  mxclient.RoomInfo
+	MemberMap map[string]mxclient.MemberInfo
+	// Reactions to Events by event ID.
+	Reactions map[string][]mxclient.ReactionGroup
+	// Threads rooted at Events by event ID.
+	Threads map[string]mxclient.ThreadSummary
+	// InReplyTo holds the events replied to by Events by event ID.
+	InReplyTo   map[string]gomatrix.Event
 	AtTopEnd    bool
 	AtBottomEnd bool
 	Err         error
+
+	// Backpagination is set instead of everything else if the page cannot be served without it, see FetchInline.
+	Backpagination *mxclient.Backpagination
+	// Predecessor holds the page continuing this one into the room it was upgraded from, if it was asked for,
+	// see Workers.GetRoomEventsAcrossUpgrades.
+	Predecessor *RoomEventsResp
+
+	// missingReplyParents are the IDs of the events replied to which are not in memory, see Workers.GetRoomEvents.
+	missingReplyParents []string
 }
 
 type RoomEventsJob struct {
@@ -33,24 +49,46 @@ type RoomEventsJob struct {
 	Anchor   string
 	Offset   int
 	PageSize int
+
+	// FetchInline makes the worker back paginate itself rather than leave that to the caller, see Workers.GetRoomEvents.
+	FetchInline bool
 }
 
-func (job RoomEventsJob) Work(w *Worker) {
-	room := w.rooms[job.RoomID]
-	events, atTopEnd, atBottomEnd, err := room.GetEventPage(job.Anchor, job.Offset, job.PageSize)
+func (job RoomEventsJob) Work(ctx context.Context, w *Worker) JobResp {
+	room, exists := w.rooms[job.RoomID]
+	if !exists {
+		return RoomEventsResp{Err: ErrRoomNotLoaded}
+	}
+	if !job.FetchInline {
+		if b := room.NeededBackpagination(job.Anchor, job.Offset, job.PageSize); b != nil {
+			return RoomEventsResp{Backpagination: b}
+		}
+	}
+
+	// pages of permalinks and those fetched inline may load further events into the room.
+	numEvents := room.NumEvents()
+	events, atTopEnd, atBottomEnd, err := room.GetEventPage(ctx, job.Anchor, job.Offset, job.PageSize)
+	w.budget.grew(room.NumEvents() - numEvents)
+	inReplyTo, missingReplyParents := room.ReplyParents(events)
 
 	membersMap := make(map[string]mxclient.MemberInfo)
 	for mxid, member := range room.GetState().MemberMap {
 		membersMap[mxid] = *member
 	}
 
-	w.Output <- RoomEventsResp{
+	room.Access()
+	return RoomEventsResp{
 		events,
 		room.RoomInfo(),
 		membersMap,
+		room.Reactions(events),
+		room.Threads(events),
+		inReplyTo,
 		atTopEnd,
 		atBottomEnd,
 		err,
+		nil,
+		nil,
+		missingReplyParents,
 	}
-	room.Access()
 }
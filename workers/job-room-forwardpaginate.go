@@ -15,49 +15,48 @@
 package workers
 
 import (
-	log "github.com/Sirupsen/logrus"
+	"context"
+	"github.com/matrix-org/gomatrix"
 	"github.com/matrix-org/matrix-static/mxclient"
-	"sort"
-	"sync"
-	"time"
 )
 
-// This Job has no Resp.
-
-type RoomForwardPaginateJob struct {
-	Wg      *sync.WaitGroup
-	TTL     time.Duration
-	KeepMin int
+type RoomForwardpaginationsResp struct {
+	Forwardpaginations []*mxclient.Forwardpagination
 }
 
-func (job RoomForwardPaginateJob) Work(w *Worker) {
-	numRoomsBefore := len(w.rooms)
-
-	// discard old rooms, ignoring the first N
-	if numRoomsBefore > job.KeepMin {
-		rooms := make([]*mxclient.Room, 0, numRoomsBefore)
+// RoomForwardpaginationsJob lists the forward paginations needed to bring the rooms of the worker which /sync does not
+// keep up to date up to date, see Workers.ForwardpaginatePeekedRooms.
+type RoomForwardpaginationsJob struct{}
 
-		// create a slice of rooms ordered by LastAccess descending
-		for _, room := range w.rooms {
-			rooms = append(rooms, room)
-		}
-		sort.Slice(rooms, func(i, j int) bool {
-			return rooms[i].LastAccess.After(rooms[j].LastAccess)
-		})
-
-		for _, room := range rooms[job.KeepMin:] {
-			if room.LastAccess.Before(time.Now().Add(-job.TTL)) {
-				log.WithField("worker", w.ID).WithField("room_id", room.ID).Info("Removing room")
-				delete(w.rooms, room.ID)
-			}
+func (job RoomForwardpaginationsJob) Work(ctx context.Context, w *Worker) JobResp {
+	var forwardpaginations []*mxclient.Forwardpagination
+	for _, room := range w.rooms {
+		if f := room.NeededForwardpagination(); f != nil {
+			forwardpaginations = append(forwardpaginations, f)
 		}
 	}
+	return RoomForwardpaginationsResp{forwardpaginations}
+}
 
-	numRoomsAfter := len(w.rooms)
-	log.WithField("worker", w.ID).WithField("numRooms", numRoomsAfter).Infof("Removed %d rooms", numRoomsBefore-numRoomsAfter)
+// This Job has no Resp.
 
-	for _, room := range w.rooms {
-		room.ForwardPaginateRoom()
+// RoomForwardpaginateJob applies a forward pagination which was fetched away from the worker, passing the new events
+// on to the room's subscribers.
+type RoomForwardpaginateJob struct {
+	Forwardpagination *mxclient.Forwardpagination
+	Resp              *gomatrix.RespMessages
+}
+
+func (job RoomForwardpaginateJob) Work(ctx context.Context, w *Worker) JobResp {
+	room, exists := w.rooms[job.Forwardpagination.RoomID]
+	if !exists {
+		return nil
+	}
+
+	latest, numEvents := room.LatestEventID(), room.NumEvents()
+	if room.ApplyForwardpagination(job.Forwardpagination, job.Resp) {
+		w.budget.grew(room.NumEvents() - numEvents)
+		w.publishNewEvents(room, latest)
 	}
-	job.Wg.Done()
+	return nil
 }
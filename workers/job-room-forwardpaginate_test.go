@@ -2,6 +2,7 @@ package workers
 
 import (
 	"bytes"
+	"context"
 	"github.com/matrix-org/matrix-static/mxclient"
 	"io/ioutil"
 	"net/http"
@@ -23,18 +24,18 @@ func (f RoundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
 
 func makeWorker(rooms map[string]*mxclient.Room) *Worker {
 	worker := &Worker{
-		ID:     count,
-		client: nil,
-		Queue:  make(chan Job),
-		Output: make(chan JobResp),
-		rooms:  rooms,
+		ID:            count,
+		client:        nil,
+		queue:         make(chan jobRequest),
+		rooms:         rooms,
+		subscriptions: newSubscriptions(),
 	}
 	go worker.Start()
 	count += 1
 	return worker
 }
 
-func TestRoomForwardPaginateJob_Work(t *testing.T) {
+func TestRoomEvictJob_Work(t *testing.T) {
 	cli, _ := mxclient.NewRawClient("", "", "", "")
 	cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
 		// Test request parameters
@@ -54,13 +55,13 @@ func TestRoomForwardPaginateJob_Work(t *testing.T) {
 
 	tests := []struct {
 		name   string
-		fields RoomForwardPaginateJob
+		fields RoomEvictJob
 		rooms  map[string]*mxclient.Room
 		exp    map[string]*mxclient.Room
 	}{
 		{
 			"should not remove any if less rooms than KeepMin",
-			RoomForwardPaginateJob{
+			RoomEvictJob{
 				&sync.WaitGroup{},
 				100 * time.Minute,
 				10,
@@ -79,7 +80,7 @@ func TestRoomForwardPaginateJob_Work(t *testing.T) {
 			},
 		}, {
 			"should not remove any if less rooms than KeepMin even if exceed TTL",
-			RoomForwardPaginateJob{
+			RoomEvictJob{
 				&sync.WaitGroup{},
 				10 * time.Minute,
 				10,
@@ -98,7 +99,7 @@ func TestRoomForwardPaginateJob_Work(t *testing.T) {
 			},
 		}, {
 			"should remove any exceeding TTL but keeping KeepMin",
-			RoomForwardPaginateJob{
+			RoomEvictJob{
 				&sync.WaitGroup{},
 				10 * time.Minute,
 				3,
@@ -119,13 +120,13 @@ func TestRoomForwardPaginateJob_Work(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			tt.fields.Wg.Add(1)
-			job := RoomForwardPaginateJob{
+			job := RoomEvictJob{
 				Wg:      tt.fields.Wg,
 				TTL:     tt.fields.TTL,
 				KeepMin: tt.fields.KeepMin,
 			}
 			w := makeWorker(tt.rooms)
-			job.Work(w)
+			job.Work(context.Background(), w)
 			job.Wg.Wait()
 
 			if !reflect.DeepEqual(w.rooms, tt.exp) {
@@ -134,3 +135,165 @@ func TestRoomForwardPaginateJob_Work(t *testing.T) {
 		})
 	}
 }
+
+func TestPlanEventBudget(t *testing.T) {
+	now := time.Now()
+	// listed hottest first.
+	stats := []RoomStats{
+		{"room1", now.Add(-1 * time.Minute), 100, 50, false},
+		{"room2", now.Add(-2 * time.Minute), 100, 50, false},
+		{"room3", now.Add(-3 * time.Minute), 100, 50, false},
+	}
+
+	tests := []struct {
+		name      string
+		maxEvents int
+		keepMin   int
+		exp       []RoomTrimJob
+	}{
+		{
+			"should do nothing if within budget",
+			300,
+			0,
+			nil,
+		}, {
+			"should trim the coldest room first",
+			270,
+			0,
+			[]RoomTrimJob{
+				{"room3", 70, false},
+			},
+		}, {
+			"should trim warmer rooms once colder ones cannot be trimmed further",
+			180,
+			0,
+			[]RoomTrimJob{
+				{"room3", 50, false},
+				{"room2", 50, false},
+				{"room1", 80, false},
+			},
+		}, {
+			"should discard the coldest room once all rooms are trimmed",
+			120,
+			0,
+			[]RoomTrimJob{
+				{"room3", 50, true},
+				{"room2", 50, false},
+				{"room1", 50, false},
+			},
+		}, {
+			"should not discard more than allowed by keepMin",
+			10,
+			2,
+			[]RoomTrimJob{
+				{"room3", 50, true},
+				{"room2", 50, false},
+				{"room1", 50, false},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := planEventBudget(stats, tt.maxEvents, tt.keepMin); !reflect.DeepEqual(got, tt.exp) {
+				t.Errorf("planEventBudget() = %v, want %v", got, tt.exp)
+			}
+		})
+	}
+
+	t.Run("should not discard rooms with subscribers", func(t *testing.T) {
+		subscribed := append([]RoomStats(nil), stats...)
+		subscribed[2].Subscribed = true
+		exp := []RoomTrimJob{
+			{"room3", 50, false},
+			{"room2", 50, true},
+			{"room1", 50, false},
+		}
+		if got := planEventBudget(subscribed, 120, 0); !reflect.DeepEqual(got, exp) {
+			t.Errorf("planEventBudget() = %v, want %v", got, exp)
+		}
+	})
+}
+
+func TestWorkers_StartEventBudget(t *testing.T) {
+	cli, _ := mxclient.NewRawClient("https://example.org", "", "", "")
+	cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+		body := `[]`
+		if req.URL.Query().Get("dir") == "b" {
+			body = `{"chunk":[{"event_id":"$2","type":"m.room.message"},{"event_id":"$1","type":"m.room.message"}],"start":"s1"}`
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			Header:     make(http.Header),
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pool := NewWorkers(2, cli)
+	pool.StartEventBudget(ctx, 3, 0)
+
+	for _, roomID := range []string{"!a", "!b"} {
+		if err := pool.LoadRoom(ctx, roomID); err != nil {
+			t.Fatal("Failed loading room", err)
+		}
+	}
+
+	// the budget is enforced as soon as the rooms outgrow it, rather than on the next periodic check.
+	deadline := time.Now().Add(time.Second)
+	for len(pool.LoadedRoomIDs(ctx)) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Event budget was not enforced", pool.LoadedRoomIDs(ctx))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWorkers_ForwardpaginatePeekedRooms(t *testing.T) {
+	cli, _ := mxclient.NewRawClient("https://example.org", "", "@bot:example.org", "")
+	cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+		body := `[]`
+		if req.URL.Query().Get("dir") == "b" {
+			body = `{"chunk":[{"event_id":"$1","type":"m.room.message"}],"start":"s1","end":"e1"}`
+		} else if req.URL.Query().Get("dir") == "f" && req.URL.Query().Get("from") == "s1" {
+			body = `{"chunk":[{"event_id":"$2","type":"m.room.message"}],"start":"s1","end":"s2"}`
+		} else if req.URL.Query().Get("dir") == "f" {
+			body = `{"chunk":[],"start":"s2"}`
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			Header:     make(http.Header),
+		}
+	})
+
+	ctx := context.Background()
+	pool := NewWorkers(1, cli)
+	if err := pool.LoadRoom(ctx, "!room"); err != nil {
+		t.Fatal("Failed loading room", err)
+	}
+	resp, err := pool.SubscribeRoom(ctx, "!room", "")
+	if err != nil || resp.Err != nil {
+		t.Fatal("Failed subscribing", err, resp.Err)
+	}
+	defer resp.Subscription.Close()
+
+	// the account has not joined the room so /sync never brings it up to date.
+	if err := pool.ForwardpaginatePeekedRooms(ctx); err != nil {
+		t.Fatal("Failed forward paginating", err)
+	}
+	update := <-resp.Subscription.Updates
+	if len(update.Events) != 1 || update.Events[0].ID != "$2" {
+		t.Error("Update mismatch expectation", update.Events)
+	}
+
+	// nothing new is not worth telling the subscribers about.
+	if err := pool.ForwardpaginatePeekedRooms(ctx); err != nil {
+		t.Fatal("Failed forward paginating", err)
+	}
+	select {
+	case update := <-resp.Subscription.Updates:
+		t.Error("Unexpected update", update.Events)
+	default:
+	}
+}
@@ -0,0 +1,42 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workers
+
+import (
+	"context"
+	"github.com/matrix-org/matrix-static/mxclient"
+)
+
+type RoomInfoResp struct {
+	RoomInfo mxclient.RoomInfo
+	Err      error
+}
+
+type RoomInfoJob struct {
+	RoomID string
+}
+
+func (job RoomInfoJob) Work(ctx context.Context, w *Worker) JobResp {
+	room, exists := w.rooms[job.RoomID]
+	if !exists {
+		return RoomInfoResp{Err: ErrRoomNotLoaded}
+	}
+
+	room.Access()
+	return RoomInfoResp{
+		room.RoomInfo(),
+		nil,
+	}
+}
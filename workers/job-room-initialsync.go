@@ -14,30 +14,23 @@
 
 package workers
 
-import log "github.com/Sirupsen/logrus"
+import (
+	"context"
+	"github.com/matrix-org/matrix-static/mxclient"
+)
 
-type RoomInitialSyncResp struct {
-	Err error
-}
+// This Job has no Resp.
 
+// RoomInitialSyncJob hands the worker Room once it has been initial synced, see Workers.LoadRoom.
+// The Room is dropped if the worker already holds one for the same ID.
 type RoomInitialSyncJob struct {
-	RoomID string
+	Room *mxclient.Room
 }
 
-func (job RoomInitialSyncJob) Work(w *Worker) {
-	resp := &RoomInitialSyncResp{}
-
-	if _, exists := w.rooms[job.RoomID]; !exists {
-		loggerWithFields := log.WithField("worker", w.ID).WithField("RoomID", job.RoomID)
-		loggerWithFields.Info("Started Initial Syncing Room")
-		if newRoom, err := w.client.NewRoom(job.RoomID); err == nil {
-			loggerWithFields.Info("Finished Initial Syncing Room")
-			w.rooms[job.RoomID] = newRoom
-		} else {
-			loggerWithFields.WithError(err).Error("Failed Initial Syncing Room")
-			resp.Err = err
-		}
+func (job RoomInitialSyncJob) Work(ctx context.Context, w *Worker) JobResp {
+	if _, exists := w.rooms[job.Room.ID]; !exists {
+		w.rooms[job.Room.ID] = job.Room
+		w.budget.grew(job.Room.NumEvents())
 	}
-
-	w.Output <- resp
+	return nil
 }
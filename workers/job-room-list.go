@@ -0,0 +1,32 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workers
+
+import "context"
+
+type RoomListResp struct {
+	RoomIDs []string
+}
+
+// RoomListJob lists the IDs of all rooms held by the worker.
+type RoomListJob struct{}
+
+func (job RoomListJob) Work(ctx context.Context, w *Worker) JobResp {
+	roomIDs := make([]string, 0, len(w.rooms))
+	for roomID := range w.rooms {
+		roomIDs = append(roomIDs, roomID)
+	}
+	return RoomListResp{roomIDs}
+}
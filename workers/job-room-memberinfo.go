@@ -15,6 +15,7 @@
 package workers
 
 import (
+	"context"
 	"fmt"
 	"github.com/matrix-org/matrix-static/mxclient"
 )
@@ -39,8 +40,11 @@ type RoomMemberInfoJob struct {
 	Mxid   string
 }
 
-func (job RoomMemberInfoJob) Work(w *Worker) {
-	room := w.rooms[job.RoomID]
+func (job RoomMemberInfoJob) Work(ctx context.Context, w *Worker) JobResp {
+	room, exists := w.rooms[job.RoomID]
+	if !exists {
+		return RoomMemberInfoResp{Err: ErrRoomNotLoaded}
+	}
 
 	var err error
 	var memberInfo mxclient.MemberInfo
@@ -54,10 +58,10 @@ func (job RoomMemberInfoJob) Work(w *Worker) {
 		memberInfo = *member
 	}
 
-	w.Output <- RoomMemberInfoResp{
+	room.Access()
+	return RoomMemberInfoResp{
 		room.RoomInfo(),
 		memberInfo,
 		err,
 	}
-	room.Access()
 }
@@ -15,6 +15,7 @@
 package workers
 
 import (
+	"context"
 	"github.com/matrix-org/matrix-static/mxclient"
 	"github.com/matrix-org/matrix-static/utils"
 )
@@ -24,6 +25,7 @@ type RoomMembersResp struct {
 	Members  []mxclient.MemberInfo
 	PageSize int
 	Page     int
+	Err      error
 }
 
 type RoomMembersJob struct {
@@ -32,8 +34,11 @@ type RoomMembersJob struct {
 	PageSize int
 }
 
-func (job RoomMembersJob) Work(w *Worker) {
-	room := w.rooms[job.RoomID]
+func (job RoomMembersJob) Work(ctx context.Context, w *Worker) JobResp {
+	room, exists := w.rooms[job.RoomID]
+	if !exists {
+		return RoomMembersResp{Err: ErrRoomNotLoaded}
+	}
 	members := room.GetState().Members()
 
 	start, end := utils.CalcPaginationStartEnd(job.Page, job.PageSize, len(members))
@@ -44,11 +49,12 @@ func (job RoomMembersJob) Work(w *Worker) {
 		membersSlice = append(membersSlice, *member)
 	}
 
-	w.Output <- RoomMembersResp{
+	room.Access()
+	return RoomMembersResp{
 		room.RoomInfo(),
 		membersSlice,
 		job.PageSize,
 		job.Page,
+		nil,
 	}
-	room.Access()
 }
@@ -0,0 +1,38 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workers
+
+import (
+	"context"
+	log "github.com/Sirupsen/logrus"
+	"sync"
+)
+
+// This Job has no Resp.
+
+// RoomPersistJob persists the changes to every room held by the worker, if there is a RoomStore.
+type RoomPersistJob struct {
+	Wg *sync.WaitGroup
+}
+
+func (job RoomPersistJob) Work(ctx context.Context, w *Worker) JobResp {
+	for _, room := range w.rooms {
+		if err := room.Persist(); err != nil {
+			log.WithField("worker", w.ID).WithField("room_id", room.ID).WithError(err).Error("Failed to persist room")
+		}
+	}
+	job.Wg.Done()
+	return nil
+}
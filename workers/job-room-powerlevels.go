@@ -15,25 +15,31 @@
 package workers
 
 import (
+	"context"
 	"github.com/matrix-org/matrix-static/mxclient"
 )
 
 type RoomPowerLevelsResp struct {
 	RoomInfo    mxclient.RoomInfo
 	PowerLevels mxclient.PowerLevels
+	Err         error
 }
 
 type RoomPowerLevelsJob struct {
 	RoomID string
 }
 
-func (job RoomPowerLevelsJob) Work(w *Worker) {
-	room := w.rooms[job.RoomID]
+func (job RoomPowerLevelsJob) Work(ctx context.Context, w *Worker) JobResp {
+	room, exists := w.rooms[job.RoomID]
+	if !exists {
+		return RoomPowerLevelsResp{Err: ErrRoomNotLoaded}
+	}
 	powerLevels := room.GetState().PowerLevels
 
-	w.Output <- RoomPowerLevelsResp{
+	room.Access()
+	return RoomPowerLevelsResp{
 		room.RoomInfo(),
 		powerLevels,
+		nil,
 	}
-	room.Access()
 }
@@ -0,0 +1,45 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workers
+
+import (
+	"context"
+	"github.com/matrix-org/gomatrix"
+)
+
+type RoomReplyParentsResp struct {
+	// Parents by event ID, edited as they would be in the timeline.
+	Parents map[string]gomatrix.Event
+}
+
+// RoomReplyParentsJob caches events fetched for being replied to and the IDs of those which could not be, then returns
+// those of EventIDs which are cached, see Workers.fetchReplyParents.
+type RoomReplyParentsJob struct {
+	RoomID   string
+	Fetched  []gomatrix.Event
+	Failed   []string
+	EventIDs []string
+}
+
+func (job RoomReplyParentsJob) Work(ctx context.Context, w *Worker) JobResp {
+	room, exists := w.rooms[job.RoomID]
+	if !exists {
+		// the room was evicted meanwhile, so there is nothing to cache them in, the replies are shown without quotes.
+		return RoomReplyParentsResp{}
+	}
+
+	room.CacheReplyParents(job.Fetched, job.Failed)
+	return RoomReplyParentsResp{room.CachedReplyParents(job.EventIDs)}
+}
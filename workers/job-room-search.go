@@ -0,0 +1,82 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workers
+
+import (
+	"context"
+	"errors"
+	"github.com/matrix-org/matrix-static/mxclient"
+)
+
+// ErrSearchUnavailable is returned for rooms which the homeserver cannot search for us when local search is disabled.
+var ErrSearchUnavailable = errors.New("Searching this room is not available.")
+
+type RoomSearchResp struct {
+	RoomInfo  mxclient.RoomInfo
+	MemberMap map[string]mxclient.MemberInfo
+	Results   mxclient.SearchResults
+	Err       error
+
+	// searchRemotely is set if the homeserver should be searched, see Workers.SearchRoom.
+	searchRemotely bool
+}
+
+type RoomSearchJob struct {
+	RoomID   string
+	Query    string
+	From     string
+	PageSize int
+
+	// AllowLocal permits searching the events held in memory when the homeserver cannot be searched.
+	AllowLocal bool
+
+	// remoteResults holds the results fetched from the homeserver away from the worker.
+	remoteResults *mxclient.SearchResults
+	// skipRemote is set once the homeserver failed to be searched.
+	skipRemote bool
+}
+
+func (job RoomSearchJob) Work(ctx context.Context, w *Worker) JobResp {
+	room, exists := w.rooms[job.RoomID]
+	if !exists {
+		return RoomSearchResp{Err: ErrRoomNotLoaded}
+	}
+
+	membersMap := make(map[string]mxclient.MemberInfo)
+	for mxid, member := range room.GetState().MemberMap {
+		membersMap[mxid] = *member
+	}
+
+	resp := RoomSearchResp{
+		RoomInfo:  room.RoomInfo(),
+		MemberMap: membersMap,
+	}
+	switch {
+	case job.Query == "":
+		// there is nothing to search for, only the room to show.
+	case job.remoteResults != nil:
+		resp.Results = *job.remoteResults
+		resp.Results.Events = room.WithEdits(resp.Results.Events)
+	case room.IsJoined() && !job.skipRemote:
+		resp.searchRemotely = true
+	case job.AllowLocal:
+		resp.Results = room.SearchLocal(job.Query, job.From, job.PageSize)
+	default:
+		resp.Err = ErrSearchUnavailable
+	}
+
+	room.Access()
+	return resp
+}
@@ -15,6 +15,7 @@
 package workers
 
 import (
+	"context"
 	"github.com/matrix-org/matrix-static/mxclient"
 	"github.com/matrix-org/matrix-static/utils"
 )
@@ -24,6 +25,7 @@ type RoomServersResp struct {
 	Servers  mxclient.ServerUserCounts
 	PageSize int
 	Page     int
+	Err      error
 }
 
 type RoomServersJob struct {
@@ -32,17 +34,21 @@ type RoomServersJob struct {
 	PageSize int
 }
 
-func (job RoomServersJob) Work(w *Worker) {
-	room := w.rooms[job.RoomID]
+func (job RoomServersJob) Work(ctx context.Context, w *Worker) JobResp {
+	room, exists := w.rooms[job.RoomID]
+	if !exists {
+		return RoomServersResp{Err: ErrRoomNotLoaded}
+	}
 	servers := room.GetState().Servers()
 
 	start, end := utils.CalcPaginationStartEnd(job.Page, job.PageSize, len(servers))
 
-	w.Output <- RoomServersResp{
+	room.Access()
+	return RoomServersResp{
 		room.RoomInfo(),
 		servers[start:end],
 		job.PageSize,
 		job.Page,
+		nil,
 	}
-	room.Access()
 }
@@ -0,0 +1,52 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workers
+
+import (
+	"context"
+	"time"
+)
+
+type RoomStats struct {
+	RoomID     string
+	LastAccess time.Time
+	NumEvents  int
+	// MinEvents is the fewest events the room can be trimmed down to without discarding it entirely.
+	MinEvents int
+	// Subscribed is set if anybody is subscribed to the room, in which case it should not be discarded.
+	Subscribed bool
+}
+
+type RoomStatsResp struct {
+	WorkerID int
+	Rooms    []RoomStats
+}
+
+// RoomStatsJob reports the memory usage of every room held by the worker.
+type RoomStatsJob struct{}
+
+func (job RoomStatsJob) Work(ctx context.Context, w *Worker) JobResp {
+	stats := make([]RoomStats, 0, len(w.rooms))
+	for _, room := range w.rooms {
+		stats = append(stats, RoomStats{
+			room.ID,
+			room.LastAccess,
+			room.NumEvents(),
+			room.MinEvents(),
+			w.subscriptions.has(room.ID),
+		})
+	}
+	return RoomStatsResp{w.ID, stats}
+}
@@ -0,0 +1,62 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workers
+
+import (
+	"context"
+)
+
+type RoomSubscribeResp struct {
+	Subscription *Subscription
+	// CatchUp holds the events since the one subscribed from, if any.
+	CatchUp RoomUpdate
+	// TooFarBehind is set if the event subscribed from is no longer in memory or too many events have followed it to
+	// catch up on, so the subscriber must reload the room instead.
+	TooFarBehind bool
+	Err          error
+}
+
+// RoomSubscribeJob subscribes to the events added to the live end of a room's timeline, catching up on those since
+// the event with ID Since if it is set. Subscribing on the worker ensures nothing is missed in between the two.
+type RoomSubscribeJob struct {
+	RoomID         string
+	Since          string
+	MaxSubscribers int
+	// MaxTotalSubscribers bounds the subscriptions across all workers, unlimited if 0.
+	MaxTotalSubscribers int
+}
+
+func (job RoomSubscribeJob) Work(ctx context.Context, w *Worker) JobResp {
+	room, exists := w.rooms[job.RoomID]
+	if !exists {
+		return RoomSubscribeResp{Err: ErrRoomNotLoaded}
+	}
+
+	var resp RoomSubscribeResp
+	if job.Since != "" {
+		events, previous, found := room.EventsSince(job.Since, MaxRoomUpdateEvents+1)
+		if !found || len(events) > MaxRoomUpdateEvents {
+			resp.TooFarBehind = true
+			return resp
+		}
+		if len(events) > 0 {
+			resp.CatchUp = roomUpdate(room, events, previous)
+		}
+	}
+
+	resp.Subscription, resp.Err = w.subscriptions.add(job.RoomID, job.MaxSubscribers, job.MaxTotalSubscribers)
+	room.Access()
+	return resp
+}
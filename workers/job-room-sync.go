@@ -0,0 +1,78 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workers
+
+import (
+	"context"
+	"github.com/matrix-org/matrix-static/mxclient"
+)
+
+type RoomSyncGapResp struct {
+	// Forwardpagination fills the gap, nil if there is none.
+	Forwardpagination *mxclient.Forwardpagination
+}
+
+// RoomSyncGapJob finds the gap to fill before a room's portion of a /sync response can be applied, see
+// Workers.ApplySync. Limited is whether the timeline of the response was limited.
+type RoomSyncGapJob struct {
+	RoomID  string
+	Limited bool
+}
+
+func (job RoomSyncGapJob) Work(ctx context.Context, w *Worker) JobResp {
+	room, exists := w.rooms[job.RoomID]
+	if !exists {
+		return RoomSyncGapResp{}
+	}
+	return RoomSyncGapResp{room.NeededSyncForwardpagination(job.Limited)}
+}
+
+// This Job has no Resp.
+
+// RoomSyncJob applies a room's portion of a /sync response to it, if this worker still holds the room.
+type RoomSyncJob struct {
+	RoomID    string
+	Sync      mxclient.SyncJoinedRoom
+	NextBatch string
+}
+
+func (job RoomSyncJob) Work(ctx context.Context, w *Worker) JobResp {
+	// the room may have been evicted whilst the /sync was in flight.
+	room, exists := w.rooms[job.RoomID]
+	if !exists {
+		return nil
+	}
+
+	latest, numEvents := room.LatestEventID(), room.NumEvents()
+	room.ApplySync(job.Sync, job.NextBatch)
+	w.budget.grew(room.NumEvents() - numEvents)
+	w.publishNewEvents(room, latest)
+	return nil
+}
+
+// publishNewEvents hands the events of room newer than latest to its subscribers, if it has any.
+func (w *Worker) publishNewEvents(room *mxclient.Room, latest string) {
+	if !w.subscriptions.has(room.ID) {
+		return
+	}
+
+	events, previous, _ := room.EventsSince(latest, MaxRoomUpdateEvents+1)
+	if len(events) > MaxRoomUpdateEvents {
+		// a gap was filled, let the subscribers find out that they must reload rather than skip over it.
+		w.subscriptions.closeRoom(room.ID)
+	} else if len(events) > 0 {
+		w.subscriptions.publish(room.ID, roomUpdate(room, events, previous))
+	}
+}
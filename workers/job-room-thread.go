@@ -0,0 +1,82 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workers
+
+import (
+	"context"
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/mxclient"
+)
+
+type RoomThreadResp struct {
+	RoomInfo  mxclient.RoomInfo
+	MemberMap map[string]mxclient.MemberInfo
+	Root      gomatrix.Event
+	// Replies to Root, newest first.
+	Replies []gomatrix.Event
+	// Reactions to Root and Replies by event ID.
+	Reactions map[string][]mxclient.ReactionGroup
+	// InReplyTo holds the events replied to by Root and Replies by event ID.
+	InReplyTo map[string]gomatrix.Event
+	NextBatch string
+	Err       error
+
+	// missingReplyParents are the IDs of the events replied to which are not in memory, see Workers.GetRoomThread.
+	missingReplyParents []string
+}
+
+// RoomThreadJob shows a fetched page of a thread as the timeline would, with the edits and reactions the room knows of.
+type RoomThreadJob struct {
+	RoomID string
+	Thread *mxclient.Thread
+	// RootID is set instead of Thread to show the replies to it which are held in memory, see mxclient.Room.LocalThread.
+	RootID string
+}
+
+func (job RoomThreadJob) Work(ctx context.Context, w *Worker) JobResp {
+	room, exists := w.rooms[job.RoomID]
+	if !exists {
+		return RoomThreadResp{Err: ErrRoomNotLoaded}
+	}
+
+	thread := job.Thread
+	if thread == nil {
+		var found bool
+		if thread, found = room.LocalThread(job.RootID); !found {
+			return RoomThreadResp{Err: &RoomEventNotFoundError{job.RoomID, job.RootID}}
+		}
+	}
+
+	events := room.WithEdits(append([]gomatrix.Event{thread.Root}, thread.Replies...))
+	inReplyTo, missingReplyParents := room.ReplyParents(events)
+
+	membersMap := make(map[string]mxclient.MemberInfo)
+	for mxid, member := range room.GetState().MemberMap {
+		membersMap[mxid] = *member
+	}
+
+	room.Access()
+	return RoomThreadResp{
+		room.RoomInfo(),
+		membersMap,
+		events[0],
+		events[1:],
+		room.Reactions(events),
+		inReplyTo,
+		thread.NextBatch,
+		nil,
+		missingReplyParents,
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workers
+
+import (
+	"context"
+	log "github.com/Sirupsen/logrus"
+)
+
+// This Job has no Resp.
+
+// RoomTrimJob trims the room down to at most MaxEvents or discards it entirely if Discard is set,
+// persisting it beforehand if there is a RoomStore.
+type RoomTrimJob struct {
+	RoomID    string
+	MaxEvents int
+	Discard   bool
+}
+
+func (job RoomTrimJob) Work(ctx context.Context, w *Worker) JobResp {
+	room, exists := w.rooms[job.RoomID]
+	if !exists {
+		return nil
+	}
+
+	loggerWithFields := log.WithField("worker", w.ID).WithField("room_id", room.ID)
+	if err := room.Persist(); err != nil {
+		loggerWithFields.WithError(err).Error("Failed to persist room")
+	}
+
+	if job.Discard {
+		loggerWithFields.Info("Removing room to stay within event budget")
+		delete(w.rooms, job.RoomID)
+		// subscribers would no longer be published to, dropping them makes them reload the room.
+		w.subscriptions.closeRoom(job.RoomID)
+	} else {
+		numRemoved := room.Trim(job.MaxEvents)
+		loggerWithFields.Infof("Trimmed %d events to stay within event budget", numRemoved)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workers
+
+import (
+	"context"
+	"errors"
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/mxclient"
+	"sync"
+	"sync/atomic"
+)
+
+// RoomUpdate holds events newly added to the live end of a room's timeline, along with what is needed to render them.
+type RoomUpdate struct {
+	// Events are latest first, as elsewhere.
+	Events    []gomatrix.Event
+	RoomInfo  mxclient.RoomInfo
+	MemberMap map[string]mxclient.MemberInfo
+	Reactions map[string][]mxclient.ReactionGroup
+	Threads   map[string]mxclient.ThreadSummary
+	InReplyTo map[string]gomatrix.Event
+	// Previous is the event Events follow on from, if it is in memory.
+	Previous *gomatrix.Event
+}
+
+// MaxRoomUpdateEvents bounds how many events a single RoomUpdate carries, subscribers which would need more than this
+// to catch up are better off reloading the room.
+const MaxRoomUpdateEvents = 100
+
+// subscriptionBuffer is how many RoomUpdates a subscriber may fall behind by before it is dropped.
+const subscriptionBuffer = 8
+
+var (
+	ErrTooManySubscribers   = errors.New("too many subscribers to room")
+	ErrTooManySubscriptions = errors.New("too many subscriptions in total")
+)
+
+// Subscription receives the RoomUpdates published to a room until it is closed, see Workers.SubscribeRoom.
+type Subscription struct {
+	// Updates is closed if the subscriber falls too far behind, after which it must subscribe afresh.
+	Updates <-chan RoomUpdate
+
+	updates chan RoomUpdate
+	roomID  string
+	subs    *subscriptions
+}
+
+// Close stops the subscription, it is safe to call more than once.
+func (s *Subscription) Close() {
+	s.subs.remove(s)
+}
+
+// subscriptions holds the subscribers to each room of a worker. It is only published to by the worker but subscribers
+// may close their subscription from anywhere so it is guarded by a mutex.
+type subscriptions struct {
+	mu    sync.Mutex
+	rooms map[string]map[*Subscription]struct{}
+	// total counts the subscriptions across all workers, it is shared between them and accessed atomically.
+	total *int64
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{rooms: make(map[string]map[*Subscription]struct{}), total: new(int64)}
+}
+
+// add subscribes to roomID unless it already has maxPerRoom subscribers or there are already maxTotal subscriptions
+// across all workers, zero allowing any number.
+func (subs *subscriptions) add(roomID string, maxPerRoom, maxTotal int) (*Subscription, error) {
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	room := subs.rooms[roomID]
+	if maxPerRoom > 0 && len(room) >= maxPerRoom {
+		return nil, ErrTooManySubscribers
+	}
+	if total := atomic.AddInt64(subs.total, 1); maxTotal > 0 && total > int64(maxTotal) {
+		atomic.AddInt64(subs.total, -1)
+		return nil, ErrTooManySubscriptions
+	}
+	if room == nil {
+		room = make(map[*Subscription]struct{})
+		subs.rooms[roomID] = room
+	}
+
+	updates := make(chan RoomUpdate, subscriptionBuffer)
+	s := &Subscription{Updates: updates, updates: updates, roomID: roomID, subs: subs}
+	room[s] = struct{}{}
+	return s, nil
+}
+
+func (subs *subscriptions) remove(s *Subscription) {
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+	subs.removeLocked(s)
+}
+
+func (subs *subscriptions) removeLocked(s *Subscription) {
+	room := subs.rooms[s.roomID]
+	if _, ok := room[s]; !ok {
+		return
+	}
+	delete(room, s)
+	if len(room) == 0 {
+		delete(subs.rooms, s.roomID)
+	}
+	atomic.AddInt64(subs.total, -1)
+	close(s.updates)
+}
+
+// closeRoom drops every subscriber to roomID, for when they can no longer be kept up to date.
+func (subs *subscriptions) closeRoom(roomID string) {
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	for s := range subs.rooms[roomID] {
+		subs.removeLocked(s)
+	}
+}
+
+// has returns whether anybody is subscribed to roomID, so that updates need only be built for rooms being watched.
+func (subs *subscriptions) has(roomID string) bool {
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+	return len(subs.rooms[roomID]) > 0
+}
+
+// publish hands update to each subscriber to roomID without blocking, dropping any which have fallen too far behind.
+func (subs *subscriptions) publish(roomID string, update RoomUpdate) {
+	subs.mu.Lock()
+	defer subs.mu.Unlock()
+
+	for s := range subs.rooms[roomID] {
+		select {
+		case s.updates <- update:
+		default:
+			subs.removeLocked(s)
+		}
+	}
+}
+
+// SubscribeRoom subscribes to the room, which must already be loaded, see RoomSubscribeJob. At most
+// MaxRoomSubscribers may be subscribed to a room at once, and MaxSubscribers to all rooms. A subscription made as ctx is done may be left without anyone
+// reading it, it is dropped once it falls behind.
+func (ws *Workers) SubscribeRoom(ctx context.Context, roomID, since string) (RoomSubscribeResp, error) {
+	resp, err := ws.Submit(ctx, roomID, RoomSubscribeJob{roomID, since, ws.MaxRoomSubscribers, ws.MaxSubscribers})
+	if err != nil {
+		return RoomSubscribeResp{}, err
+	}
+	return resp.(RoomSubscribeResp), nil
+}
+
+// roomUpdate builds the RoomUpdate for events of room, newest first, which follow on from previous.
+func roomUpdate(room *mxclient.Room, events []gomatrix.Event, previous *gomatrix.Event) RoomUpdate {
+	memberMap := make(map[string]mxclient.MemberInfo)
+	for mxid, member := range room.GetState().MemberMap {
+		memberMap[mxid] = *member
+	}
+	inReplyTo, _ := room.ReplyParents(events)
+
+	return RoomUpdate{
+		Events:    events,
+		RoomInfo:  room.RoomInfo(),
+		MemberMap: memberMap,
+		Reactions: room.Reactions(events),
+		Threads:   room.Threads(events),
+		InReplyTo: inReplyTo,
+		Previous:  previous,
+	}
+}
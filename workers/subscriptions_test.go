@@ -0,0 +1,119 @@
+package workers
+
+import (
+	"bytes"
+	"context"
+	"github.com/matrix-org/gomatrix"
+	"github.com/matrix-org/matrix-static/mxclient"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestWorkers_SubscribeRoom(t *testing.T) {
+	cli, _ := mxclient.NewRawClient("https://example.org", "", "", "")
+	cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+		body := `[]`
+		if req.URL.Query().Get("dir") == "b" {
+			body = `{"chunk":[{"event_id":"$1","type":"m.room.message"}],"start":"s1","end":"e1"}`
+		} else if req.URL.Query().Get("dir") == "f" {
+			body = `{"chunk":[],"start":"s1"}`
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			Header:     make(http.Header),
+		}
+	})
+
+	ctx := context.Background()
+	pool := NewWorkers(1, cli)
+	pool.MaxRoomSubscribers = 2
+	if err := pool.LoadRoom(ctx, "!room"); err != nil {
+		t.Fatal("Failed loading room", err)
+	}
+
+	resp, err := pool.SubscribeRoom(ctx, "!room", "")
+	if err != nil || resp.Err != nil {
+		t.Fatal("Failed subscribing", err, resp.Err)
+	}
+	sub := resp.Subscription
+	defer sub.Close()
+
+	var sync mxclient.SyncJoinedRoom
+	sync.Timeline.Events = []gomatrix.Event{{ID: "$2", Type: "m.room.message"}}
+	if _, err := pool.Submit(ctx, "!room", RoomSyncJob{RoomID: "!room", Sync: sync, NextBatch: "n1"}); err != nil {
+		t.Fatal("Failed syncing", err)
+	}
+
+	update := <-sub.Updates
+	if len(update.Events) != 1 || update.Events[0].ID != "$2" || update.Previous == nil || update.Previous.ID != "$1" {
+		t.Error("Update mismatch expectation", update.Events, update.Previous)
+	}
+
+	// subscribing from an earlier event catches up on what has happened since.
+	resp, _ = pool.SubscribeRoom(ctx, "!room", "$1")
+	if resp.Err != nil || len(resp.CatchUp.Events) != 1 || resp.CatchUp.Events[0].ID != "$2" {
+		t.Error("Catch up mismatch expectation", resp.Err, resp.CatchUp.Events)
+	}
+
+	if resp, _ := pool.SubscribeRoom(ctx, "!room", ""); resp.Err != ErrTooManySubscribers {
+		t.Error("Should limit the number of subscribers", resp.Err)
+	}
+	resp.Subscription.Close()
+	resp.Subscription.Close()
+	if _, ok := <-resp.Subscription.Updates; ok {
+		t.Error("Closing should close the updates channel")
+	}
+
+	if resp, _ := pool.SubscribeRoom(ctx, "!room", "$unknown"); !resp.TooFarBehind || resp.Subscription != nil {
+		t.Error("Should have to reload from an unknown event", resp)
+	}
+}
+
+func TestWorkers_SubscribeRoomLimitsTotal(t *testing.T) {
+	cli, _ := mxclient.NewRawClient("https://example.org", "", "@bot:example.org", "")
+	cli.Client.Client.Transport = RoundTripFunc(func(req *http.Request) *http.Response {
+		body := `[]`
+		if req.URL.Query().Get("dir") == "b" {
+			body = `{"chunk":[{"event_id":"$1","type":"m.room.message"}],"start":"s1","end":"e1"}`
+		} else if req.URL.Query().Get("dir") == "f" {
+			body = `{"chunk":[],"start":"s1"}`
+		}
+		return &http.Response{
+			StatusCode: 200,
+			Body:       ioutil.NopCloser(bytes.NewBufferString(body)),
+			Header:     make(http.Header),
+		}
+	})
+
+	ctx := context.Background()
+	pool := NewWorkers(2, cli)
+	pool.MaxSubscribers = 1
+	for _, roomID := range []string{"!room1", "!room2"} {
+		if err := pool.LoadRoom(ctx, roomID); err != nil {
+			t.Fatal("Failed loading room", err)
+		}
+	}
+
+	resp, err := pool.SubscribeRoom(ctx, "!room1", "")
+	if err != nil || resp.Err != nil {
+		t.Fatal("Failed subscribing", err, resp.Err)
+	}
+	if resp, _ := pool.SubscribeRoom(ctx, "!room2", ""); resp.Err != ErrTooManySubscriptions {
+		t.Error("Should limit the number of subscriptions across rooms", resp.Err)
+	}
+
+	// discarding the room drops its subscribers, freeing their place.
+	if _, err := pool.Submit(ctx, "!room1", RoomTrimJob{RoomID: "!room1", Discard: true}); err != nil {
+		t.Fatal("Failed discarding room", err)
+	}
+	if _, ok := <-resp.Subscription.Updates; ok {
+		t.Error("Discarding the room should close the updates channel")
+	}
+	resp, err = pool.SubscribeRoom(ctx, "!room2", "")
+	if err != nil || resp.Err != nil {
+		t.Fatal("Failed subscribing once another subscription was dropped", err, resp.Err)
+	}
+	resp.Subscription.Close()
+}
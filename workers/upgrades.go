@@ -0,0 +1,58 @@
+// Copyright 2017 Michael Telatynski <7t3chguy@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workers
+
+import (
+	"context"
+	log "github.com/Sirupsen/logrus"
+)
+
+// GetRoomEventsAcrossUpgrades runs job like GetRoomEvents but if the page reaches the creation of a room which was
+// upgraded from another then it is filled out with the newest events of that predecessor room, see
+// RoomEventsResp.Predecessor.
+func (ws *Workers) GetRoomEventsAcrossUpgrades(ctx context.Context, job RoomEventsJob) (RoomEventsResp, error) {
+	resp, err := ws.GetRoomEvents(ctx, job)
+	if err != nil || resp.Err != nil {
+		return resp, err
+	}
+
+	predecessorID := resp.RoomInfo.PredecessorRoomID
+	numMissing := job.PageSize - len(resp.Events)
+	if job.Offset < 0 || !resp.AtTopEnd || predecessorID == "" || numMissing <= 0 {
+		return resp, nil
+	}
+
+	// the predecessor need not be peekable, in which case the page simply ends with this room.
+	if err := ws.LoadRoom(ctx, predecessorID); err != nil {
+		if ctx.Err() != nil {
+			return RoomEventsResp{}, ctx.Err()
+		}
+		log.WithField("roomID", job.RoomID).WithField("predecessor", predecessorID).WithError(err).Warn("Unable to load predecessor room")
+		return resp, nil
+	}
+
+	// the room is no longer used after being upgraded so start from its latest event.
+	predecessorResp, err := ws.GetRoomEvents(ctx, RoomEventsJob{
+		RoomID:   predecessorID,
+		PageSize: numMissing,
+	})
+	if err != nil {
+		return RoomEventsResp{}, err
+	}
+	if predecessorResp.Err == nil {
+		resp.Predecessor = &predecessorResp
+	}
+	return resp, nil
+}
@@ -15,41 +15,102 @@
 package workers
 
 import (
+	"context"
+	"errors"
 	"github.com/matrix-org/matrix-static/mxclient"
 	"hash/fnv"
 )
 
 type JobResp interface{}
 type Job interface {
-	Work(w *Worker)
+	Work(ctx context.Context, w *Worker) JobResp
 }
 
+// jobRequest pairs a Job with the context of whoever submitted it and the channel its JobResp should be delivered to,
+// so that concurrent callers sharing a Worker only ever receive the response to the Job they submitted.
+type jobRequest struct {
+	ctx   context.Context
+	job   Job
+	reply chan JobResp
+}
+
+// ErrRoomNotLoaded is the error of jobs on a room which the worker does not hold, as it was evicted since being loaded.
+var ErrRoomNotLoaded = errors.New("room is not loaded")
+
 type Worker struct {
 	ID     int
 	client *mxclient.Client
-	Queue  chan Job
-	Output chan JobResp
+	queue  chan jobRequest
 	rooms  map[string]*mxclient.Room
+	// subscriptions to the rooms of this worker, see Workers.SubscribeRoom.
+	subscriptions *subscriptions
+	// budget is told of the events this worker's rooms gain, see Workers.StartEventBudget.
+	budget *eventBudget
 }
 
 func (w *Worker) Start() {
 	for {
-		job := <-w.Queue
-		job.Work(w)
+		req := <-w.queue
+		// the caller has already given up, don't waste the worker's time on it.
+		if req.ctx.Err() != nil {
+			continue
+		}
+
+		resp := req.job.Work(req.ctx, w)
+		if req.reply != nil {
+			// reply is buffered so this never blocks, even if the caller has since stopped listening.
+			req.reply <- resp
+		}
+	}
+}
+
+// Submit queues the job on this worker and blocks until its JobResp is ready,
+// returning ctx.Err() instead if ctx is done before then.
+func (w *Worker) Submit(ctx context.Context, job Job) (JobResp, error) {
+	reply := make(chan JobResp, 1)
+
+	select {
+	case w.queue <- jobRequest{ctx, job, reply}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case resp := <-reply:
+		return resp, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	}
 }
 
 type Workers struct {
 	NumWorkers uint32
 	workers    []Worker
+
+	// MaxRoomSubscribers bounds how many subscriptions each room may have at once, unlimited if 0.
+	MaxRoomSubscribers int
+	// MaxSubscribers bounds how many subscriptions all rooms may have between them at once, unlimited if 0.
+	MaxSubscribers int
+
+	client *mxclient.Client
+	// fetches coalesces requests to the homeserver made on behalf of the workers, see fetch.go.
+	fetches flightGroup
+	// threads caches the pages of threads fetched, see GetRoomThread.
+	threads threadCache
+	budget  *eventBudget
 }
 
 func NewWorkers(numWorkers uint32, m *mxclient.Client) *Workers {
+	budget := newEventBudget()
+	numSubscribers := new(int64)
 	workers := make([]Worker, 0, numWorkers)
 	for i := uint32(0); i < numWorkers; i++ {
-		workers = append(workers, *NewWorker(int(i), m))
+		worker := NewWorker(int(i), m)
+		worker.budget = budget
+		worker.subscriptions.total = numSubscribers
+		workers = append(workers, *worker)
 	}
-	return &Workers{numWorkers, workers}
+	return &Workers{NumWorkers: numWorkers, workers: workers, client: m, budget: budget}
 }
 
 func mod32(a, b uint32) uint32 {
@@ -69,21 +130,40 @@ func (ws *Workers) GetWorkerForRoomID(roomID string) Worker {
 	return ws.workers[workerID]
 }
 
+// Submit queues the job on the worker responsible for roomID, see Worker.Submit.
+func (ws *Workers) Submit(ctx context.Context, roomID string, job Job) (JobResp, error) {
+	worker := ws.GetWorkerForRoomID(roomID)
+	return worker.Submit(ctx, job)
+}
+
+// LoadedRoomIDs returns the IDs of the rooms held across all workers, or as many as could be gathered before ctx is done.
+func (ws *Workers) LoadedRoomIDs(ctx context.Context) []string {
+	var roomIDs []string
+	for _, worker := range ws.workers {
+		resp, err := worker.Submit(ctx, RoomListJob{})
+		if err != nil {
+			break
+		}
+		roomIDs = append(roomIDs, resp.(RoomListResp).RoomIDs...)
+	}
+	return roomIDs
+}
+
 // JobForAllWorkers sends the job to the channel of each worker.
 func (ws *Workers) JobForAllWorkers(job Job) {
 	for _, worker := range ws.workers {
-		worker.Queue <- job
+		worker.queue <- jobRequest{context.Background(), job, nil}
 	}
 }
 
 // NewWorker instantiates a worker and their necessary channels, then starts them and returns them.
 func NewWorker(id int, m *mxclient.Client) *Worker {
 	worker := &Worker{
-		ID:     id,
-		client: m,
-		Queue:  make(chan Job),
-		Output: make(chan JobResp),
-		rooms:  make(map[string]*mxclient.Room),
+		ID:            id,
+		client:        m,
+		queue:         make(chan jobRequest),
+		rooms:         make(map[string]*mxclient.Room),
+		subscriptions: newSubscriptions(),
 	}
 	go worker.Start()
 	return worker
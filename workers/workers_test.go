@@ -0,0 +1,136 @@
+package workers
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+type echoJob struct {
+	N int
+}
+
+func (job echoJob) Work(ctx context.Context, w *Worker) JobResp {
+	return job.N
+}
+
+type blockingJob struct {
+	Started chan struct{}
+	Release chan struct{}
+}
+
+func (job blockingJob) Work(ctx context.Context, w *Worker) JobResp {
+	close(job.Started)
+	<-job.Release
+	return nil
+}
+
+type recordingJob struct {
+	Ran *bool
+}
+
+func (job recordingJob) Work(ctx context.Context, w *Worker) JobResp {
+	*job.Ran = true
+	return nil
+}
+
+func TestWorker_SubmitConcurrent(t *testing.T) {
+	w := NewWorker(0, nil)
+
+	const numCallers = 64
+	const jobsPerCaller = 100
+
+	var wg sync.WaitGroup
+	wg.Add(numCallers)
+	for i := 0; i < numCallers; i++ {
+		go func(caller int) {
+			defer wg.Done()
+			for j := 0; j < jobsPerCaller; j++ {
+				n := caller*jobsPerCaller + j
+				resp, err := w.Submit(context.Background(), echoJob{n})
+				if err != nil {
+					t.Errorf("caller %d received error %v for job %d", caller, err, n)
+					return
+				}
+				if resp, ok := resp.(int); !ok || resp != n {
+					t.Errorf("caller %d received response %v for job %d", caller, resp, n)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestWorker_SubmitDeadline(t *testing.T) {
+	w := NewWorker(0, nil)
+
+	blocker := blockingJob{make(chan struct{}), make(chan struct{})}
+	go w.Submit(context.Background(), blocker)
+	<-blocker.Started
+
+	// the worker is busy so this job can't even be queued before its deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	ran := false
+	if _, err := w.Submit(ctx, recordingJob{&ran}); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	close(blocker.Release)
+	if _, err := w.Submit(context.Background(), echoJob{1}); err != nil {
+		t.Error("worker should be free once the blocking job finished", err)
+	}
+	if ran {
+		t.Error("job submitted with an expired context should not have run")
+	}
+}
+
+func TestWorker_SkipsCancelledJobs(t *testing.T) {
+	w := NewWorker(0, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ran := false
+	// bypass Submit as it would return before queueing on an already cancelled context.
+	w.queue <- jobRequest{ctx, recordingJob{&ran}, nil}
+
+	if _, err := w.Submit(context.Background(), echoJob{1}); err != nil {
+		t.Error(err)
+	}
+	if ran {
+		t.Error("job whose context was cancelled before it was picked up should not have run")
+	}
+}
+
+func TestWorker_JobsOnUnloadedRoom(t *testing.T) {
+	w := NewWorker(0, nil)
+
+	// the room may be evicted between being loaded and a job on it being run.
+	jobs := []Job{
+		RoomAliasesJob{RoomID: "!room"},
+		RoomCalendarJob{RoomID: "!room"},
+		RoomDateJob{RoomID: "!room"},
+		RoomEditsJob{RoomID: "!room"},
+		RoomEventsJob{RoomID: "!room"},
+		RoomMemberInfoJob{RoomID: "!room"},
+		RoomMembersJob{RoomID: "!room"},
+		RoomPowerLevelsJob{RoomID: "!room"},
+		RoomSearchJob{RoomID: "!room"},
+		RoomServersJob{RoomID: "!room"},
+		RoomThreadJob{RoomID: "!room"},
+	}
+	for _, job := range jobs {
+		resp, err := w.Submit(context.Background(), job)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := reflect.ValueOf(resp).FieldByName("Err").Interface(); err != ErrRoomNotLoaded {
+			t.Errorf("%T error = %v, want %v", job, err, ErrRoomNotLoaded)
+		}
+	}
+}